@@ -0,0 +1,9 @@
+// Package api embeds the OpenAPI specification of the HTTP API
+package api
+
+import _ "embed"
+
+// OpenAPISpec is the OpenAPI 3 document describing the HTTP API
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
@@ -2,13 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 
+	"google.golang.org/grpc"
+
 	"pinning-server/internal/config"
+	"pinning-server/internal/crypto"
 	"pinning-server/internal/logger"
 	"pinning-server/internal/server"
 )
@@ -27,40 +35,123 @@ func main() {
 	// Reinitialize logger with configured level
 	logger.InitWithLevel(cfg.LogLevel)
 
-	logger.Info("Configuration loaded successfully",
-		"port", cfg.Port,
-		"allowed_domains_count", len(cfg.AllowedDomains),
-		"signature_lifetime", cfg.SignatureLifetime.String(),
-		"log_level", cfg.LogLevel,
-		"read_timeout", cfg.ReadTimeout.String(),
-		"write_timeout", cfg.WriteTimeout.String(),
-		"read_header_timeout", cfg.ReadHeaderTimeout.String(),
-		"max_header_bytes", cfg.MaxHeaderBytes,
-		"cert_dial_timeout", cfg.CertDialTimeout.String(),
-		"cert_cache_ttl", cfg.CertCacheTTL.String(),
-		"allow_ip_literals", cfg.AllowIPLiterals)
+	// Log the redacted configuration (as served by /v1/admin/config), one attribute
+	// per setting in a stable order
+	redacted := cfg.Redacted()
+	configAttrs := make([]any, 0, 2*len(redacted))
+	for _, key := range slices.Sorted(maps.Keys(redacted)) {
+		configAttrs = append(configAttrs, key, redacted[key])
+	}
+	logger.Info("Configuration loaded successfully", configAttrs...)
+
+	if cfg.EphemeralKey {
+		logger.Warn("DEV_MODE: signing with an ephemeral key generated at startup; " +
+			"tokens are not verifiable by clients pinned to a real key and become invalid on restart. " +
+			"Never use this in production")
+	}
+
+	for _, warning := range cfg.WhitelistWarnings {
+		logger.Warn("Redundant ALLOWED_DOMAINS entry", "detail", warning)
+	}
+
+	crypto.ConfigureSPKICache(cfg.SPKICacheSize)
 
 	// Create HTTP server
 	srv := server.New(cfg)
-	httpServer := &http.Server{
-		Addr:              fmt.Sprintf(":%d", cfg.Port),
-		Handler:           srv,
-		ReadTimeout:       cfg.ReadTimeout,
-		WriteTimeout:      cfg.WriteTimeout,
-		IdleTimeout:       cfg.IdleTimeout,
-		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
-		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+
+	// `warm <domains-file>` fetches certificates with the same configuration and exits
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		os.Exit(warmCommand(srv, os.Args[2:], os.Stdout))
+	}
+	httpServer := srv.NewHTTPServer()
+
+	// Surface whitelist typos without delaying or failing startup
+	if cfg.ValidateDomainsOnStart {
+		go srv.CheckDomainsResolve()
+	}
+
+	// Warm the certificate cache, before opening the listener if requested
+	if len(cfg.WarmupDomains) > 0 {
+		if cfg.WarmupBlock {
+			if err := srv.Warmup(cfg.WarmupDomains); err != nil && cfg.WarmupAbortOnFailure {
+				logger.Error("Warmup failed", "error", err)
+				os.Exit(1)
+			}
+		} else {
+			go func() {
+				_ = srv.Warmup(cfg.WarmupDomains)
+			}()
+		}
+	}
+
+	// Serve HTTPS directly when a certificate is configured
+	if cfg.TLSCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			logger.Error("Failed to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		httpServer.TLSConfig = server.NewTLSConfig(certificate, cfg.ServerAllowedSNI)
 	}
 
 	// Start server in a goroutine
 	go func() {
-		logger.Info("Starting server", "address", httpServer.Addr)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("Starting server", "address", httpServer.Addr, "tls", httpServer.TLSConfig != nil)
+		var err error
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("Server failed", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	// Start optional gRPC server alongside the HTTP server
+	var grpcServer *grpc.Server
+	if cfg.GRPCPort > 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+		if err != nil {
+			logger.Error("Failed to listen for gRPC", "error", err)
+			os.Exit(1)
+		}
+
+		grpcServer = srv.NewGRPCServer()
+		go func() {
+			logger.Info("Starting gRPC server", "address", listener.Addr().String())
+			if err := grpcServer.Serve(listener); err != nil {
+				logger.Error("gRPC server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Start optional profiling endpoints on their own admin listener
+	var pprofServer *http.Server
+	if cfg.EnablePprof && !server.Features()["pprof"] {
+		logger.Warn("ENABLE_PPROF has no effect: this build omits pprof (nopprof build tag)")
+	}
+	if cfg.EnablePprof {
+		if cfg.PprofAddr == "" {
+			logger.Warn("pprof endpoints exposed on the main listener; set PPROF_ADDR to isolate them")
+		} else {
+			pprofServer = &http.Server{
+				Addr:              cfg.PprofAddr,
+				Handler:           server.NewPprofHandler(),
+				ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			}
+			go func() {
+				logger.Info("Starting pprof server", "address", pprofServer.Addr)
+				if err := pprofServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.Error("pprof server failed", "error", err)
+					os.Exit(1)
+				}
+			}()
+		}
+	}
+
 	// Wait for interrupt signal to gracefully shut down the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -71,10 +162,56 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
+	if grpcServer != nil {
+		stopGRPC(ctx, grpcServer)
+	}
+
+	if pprofServer != nil {
+		if err := pprofServer.Shutdown(ctx); err != nil {
+			logger.Error("pprof server forced to shutdown", "error", err)
+		}
+	}
+
+	// Drain certificate fetches alongside the HTTP handlers waiting on them, so
+	// fetches still running at the deadline are aborted and their handlers can
+	// still answer before the listener is forced down
+	fetchesDrained := make(chan error, 1)
+	go func() {
+		fetchesDrained <- srv.Shutdown(ctx)
+	}()
+
+	exitCode := 0
 	if err := httpServer.Shutdown(ctx); err != nil {
 		logger.Error("Server forced to shutdown", "error", err)
-		os.Exit(1)
+		exitCode = 1
+	}
+	if err := <-fetchesDrained; err != nil {
+		logger.Warn("Aborted in-flight certificate fetches", "error", err)
+	}
+
+	// No fetch is left to modify the cache while it is saved
+	if err := srv.SaveCertCache(); err != nil && !errors.Is(err, server.ErrNoCertCacheFile) {
+		logger.Error("Failed to save certificate cache", "path", cfg.CertCacheFile, "error", err)
 	}
 
 	logger.Info("Server stopped")
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// stopGRPC gracefully stops the gRPC server, forcing it down once ctx expires
+func stopGRPC(ctx context.Context, grpcServer *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Error("gRPC server forced to shutdown")
+		grpcServer.Stop()
+	}
 }
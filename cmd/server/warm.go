@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"pinning-server/internal/server"
+)
+
+// warmSummary counts the outcome of a warm run
+type warmSummary struct {
+	Total  int
+	Warmed int
+	Failed int
+}
+
+// warmCommand implements `server warm <domains-file>`: it fetches the
+// certificates of every listed domain with the configured retriever, prints a
+// summary and saves the certificate cache to CERT_CACHE_FILE. Returns the
+// process exit code.
+func warmCommand(srv *server.Server, args []string, out io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(out, "usage: server warm <domains-file>")
+		return 2
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(out, "failed to open domains file: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	summary, err := runWarm(srv, file, out)
+	if err != nil {
+		fmt.Fprintf(out, "failed to read domains file: %v\n", err)
+		return 1
+	}
+
+	// The warmed cache only outlives this process if it is saved for the server to load
+	if err := srv.SaveCertCache(); err != nil {
+		if !errors.Is(err, server.ErrNoCertCacheFile) {
+			fmt.Fprintf(out, "failed to save certificate cache: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(out, "warning: CERT_CACHE_FILE is not set; warmed certificates are not saved")
+	}
+	if summary.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runWarm warms each domain read from in (one per line; blank lines and lines
+// starting with # are skipped) and writes per-failure lines and a summary to out
+func runWarm(srv *server.Server, in io.Reader, out io.Writer) (warmSummary, error) {
+	var summary warmSummary
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		domain := strings.TrimSpace(scanner.Text())
+		if domain == "" || strings.HasPrefix(domain, "#") {
+			continue
+		}
+
+		summary.Total++
+		if err := srv.WarmDomain(domain); err != nil {
+			summary.Failed++
+			fmt.Fprintf(out, "FAIL %v\n", err)
+			continue
+		}
+		summary.Warmed++
+	}
+	if err := scanner.Err(); err != nil {
+		return summary, err
+	}
+
+	fmt.Fprintf(out, "warmed %d/%d domains (%d failed)\n", summary.Warmed, summary.Total, summary.Failed)
+	return summary, nil
+}
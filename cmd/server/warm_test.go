@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pinning-server/internal/cert"
+	"pinning-server/internal/config"
+	"pinning-server/internal/server"
+)
+
+func TestRunWarm(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{
+		AllowedDomains:    []string{"example.com", "*.example.com"},
+		SignatureLifetime: time.Hour,
+		PrivateKey:        privateKey,
+		PublicKey:         &privateKey.PublicKey,
+	}
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever := cert.NewFakeRetriever()
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+	retriever.SetCertificates("api.example.com", []*x509.Certificate{testCert})
+
+	domains := strings.Join([]string{
+		"# hot domains",
+		"example.com",
+		"",
+		"api.example.com",
+		"down.example.com",
+		"other.org",
+	}, "\n")
+
+	var out bytes.Buffer
+	summary, err := runWarm(server.NewWithRetriever(cfg, retriever), strings.NewReader(domains), &out)
+	if err != nil {
+		t.Fatalf("runWarm failed: %v", err)
+	}
+
+	expected := warmSummary{Total: 4, Warmed: 2, Failed: 2}
+	if summary != expected {
+		t.Errorf("Expected summary %+v, got %+v", expected, summary)
+	}
+	if !strings.Contains(out.String(), "warmed 2/4 domains (2 failed)") {
+		t.Errorf("Expected summary line, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "FAIL down.example.com") || !strings.Contains(out.String(), "FAIL other.org") {
+		t.Errorf("Expected failed domains to be listed, got %q", out.String())
+	}
+}
+
+// savingRetriever is a fake retriever whose chains are saved like the real
+// retriever's cache
+type savingRetriever struct {
+	*cert.FakeRetriever
+	cache *cert.MemoryCache
+}
+
+func (r *savingRetriever) GetCertificates(domain string) ([]*x509.Certificate, error) {
+	certs, err := r.FakeRetriever.GetCertificates(domain)
+	if err == nil {
+		r.cache.Set(domain, certs, time.Hour)
+	}
+	return certs, err
+}
+
+func (r *savingRetriever) SaveCacheFile(path string) error {
+	return r.cache.SaveFile(path)
+}
+
+func TestWarmCommand_SavesCertCache(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	dir := t.TempDir()
+	domainsFile := filepath.Join(dir, "domains.txt")
+	if err := os.WriteFile(domainsFile, []byte("example.com\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write domains file: %v", err)
+	}
+
+	newServer := func(cacheFile string) *server.Server {
+		cfg := &config.Config{
+			AllowedDomains:    []string{"example.com"},
+			SignatureLifetime: time.Hour,
+			PrivateKey:        privateKey,
+			PublicKey:         &privateKey.PublicKey,
+			CertCacheFile:     cacheFile,
+		}
+		retriever := &savingRetriever{FakeRetriever: cert.NewFakeRetriever(), cache: cert.NewMemoryCache()}
+		retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+		return server.NewWithRetriever(cfg, retriever)
+	}
+
+	t.Run("cache file written", func(t *testing.T) {
+		cacheFile := filepath.Join(dir, "cert-cache.json")
+		var out bytes.Buffer
+		if code := warmCommand(newServer(cacheFile), []string{domainsFile}, &out); code != 0 {
+			t.Fatalf("Expected exit code 0, got %d (%q)", code, out.String())
+		}
+
+		loaded, err := cert.NewMemoryCache().LoadFile(cacheFile)
+		if err != nil {
+			t.Fatalf("Failed to load saved cache: %v", err)
+		}
+		if loaded != 1 {
+			t.Errorf("Expected 1 saved cache entry, got %d", loaded)
+		}
+	})
+
+	t.Run("no cache file configured", func(t *testing.T) {
+		var out bytes.Buffer
+		if code := warmCommand(newServer(""), []string{domainsFile}, &out); code != 0 {
+			t.Fatalf("Expected exit code 0, got %d (%q)", code, out.String())
+		}
+		if !strings.Contains(out.String(), "warning: CERT_CACHE_FILE is not set") {
+			t.Errorf("Expected a warning about CERT_CACHE_FILE, got %q", out.String())
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+)
+
+// IssuerBlocklist matches certificate chains issued under distrusted CAs,
+// identified by common name or by the base64 SHA-256 hash of their SPKI
+type IssuerBlocklist struct {
+	commonNames map[string]bool // Lowercased issuer common names
+	spkiHashes  map[string]bool // base64(SHA256(SPKI)) of issuer certificates
+}
+
+// NewIssuerBlocklist creates a blocklist from entries that are either an
+// issuer common name or a base64 SHA-256 SPKI hash (as issued in pins);
+// it returns nil when entries is empty
+func NewIssuerBlocklist(entries []string) *IssuerBlocklist {
+	if len(entries) == 0 {
+		return nil
+	}
+	b := &IssuerBlocklist{
+		commonNames: make(map[string]bool),
+		spkiHashes:  make(map[string]bool),
+	}
+	for _, entry := range entries {
+		if isSPKIHash(entry) {
+			b.spkiHashes[entry] = true
+		} else {
+			b.commonNames[strings.ToLower(entry)] = true
+		}
+	}
+	return b
+}
+
+// isSPKIHash reports whether s is a base64 encoded SHA-256 hash
+func isSPKIHash(s string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// Match returns the first blocked issuer found in chain. Every certificate's
+// issuer name is checked, so roots the upstream does not send are covered by
+// name; issuing certificates (all but the leaf) are also checked by name and key.
+func (b *IssuerBlocklist) Match(chain []*x509.Certificate) (string, bool) {
+	if b == nil {
+		return "", false
+	}
+	for i, c := range chain {
+		if b.commonNames[strings.ToLower(c.Issuer.CommonName)] {
+			return c.Issuer.CommonName, true
+		}
+		if i == 0 {
+			continue
+		}
+		if b.commonNames[strings.ToLower(c.Subject.CommonName)] {
+			return c.Subject.CommonName, true
+		}
+		sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+		if hash := base64.StdEncoding.EncodeToString(sum[:]); b.spkiHashes[hash] {
+			return hash, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,39 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestIssuerBlocklist(t *testing.T) {
+	chain, err := GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate chain: %v", err)
+	}
+	sum := sha256.Sum256(chain[1].RawSubjectPublicKeyInfo)
+	intermediateHash := base64.StdEncoding.EncodeToString(sum[:])
+	leafSum := sha256.Sum256(chain[0].RawSubjectPublicKeyInfo)
+
+	tests := []struct {
+		name     string
+		entries  []string
+		expected string
+		blocked  bool
+	}{
+		{"issuer_common_name", []string{"Other CA", "intermediate ca"}, "Intermediate CA", true},
+		{"intermediate_spki", []string{intermediateHash}, intermediateHash, true},
+		{"clean", []string{"Other CA", base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}, "", false},
+		{"leaf_is_not_an_issuer", []string{"example.com", base64.StdEncoding.EncodeToString(leafSum[:])}, "", false},
+		{"empty", nil, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, blocked := NewIssuerBlocklist(tt.entries).Match(chain)
+			if issuer != tt.expected || blocked != tt.blocked {
+				t.Errorf("Match() = %q, %v; expected %q, %v", issuer, blocked, tt.expected, tt.blocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package cert
+
+import (
+	"crypto/x509"
+	"sync"
+	"time"
+)
+
+// CertCache stores retrieved certificate chains
+// Keys are domains, or domain:port/protocol for non-default retrievals
+// Implementations backed by an external store (e.g. Redis) are expected to
+// serialize chains as the DER bytes of each certificate (cert.Raw)
+type CertCache interface {
+	// Get returns the chain cached under key, if present and not expired
+	Get(key string) ([]*x509.Certificate, bool)
+	// Set caches certs under key for ttl
+	// Callers date entries from when the chain was fetched by shortening ttl
+	// by the fetch duration, so a later expiry means a fresher chain
+	Set(key string, certs []*x509.Certificate, ttl time.Duration)
+}
+
+// cacheEntry holds cached certificates with expiry
+// Exactly one of certs and der is set, depending on the cache mode
+type cacheEntry struct {
+	certs     []*x509.Certificate
+	der       [][]byte
+	info      *ConnectionInfo // connection the chain was fetched over (nil if unknown)
+	expiresAt time.Time
+}
+
+// MemoryCache is the default in-process CertCache
+type MemoryCache struct {
+	entries  map[string]*cacheEntry
+	mu       sync.RWMutex
+	storeDER bool
+}
+
+// NewMemoryCache creates an empty in-memory certificate cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]*cacheEntry)}
+}
+
+// NewDERMemoryCache creates an empty in-memory certificate cache that keeps
+// only the DER bytes of each certificate and parses them again on every hit
+// (CERT_CACHE_COMPRESS), using several times less memory per entry
+func NewDERMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]*cacheEntry), storeDER: true}
+}
+
+// Get implements CertCache
+func (c *MemoryCache) Get(key string) ([]*x509.Certificate, bool) {
+	certs, _, found := c.GetWithInfo(key)
+	return certs, found
+}
+
+// GetWithInfo is Get that also returns the connection the chain was fetched
+// over (nil if it was cached without one)
+func (c *MemoryCache) GetWithInfo(key string) ([]*x509.Certificate, *ConnectionInfo, bool) {
+	c.mu.RLock()
+	entry, found := c.entries[key]
+	c.mu.RUnlock()
+
+	if !found || !time.Now().Before(entry.expiresAt) {
+		return nil, nil, false
+	}
+	if entry.der == nil {
+		return entry.certs, entry.info, true
+	}
+
+	certs, err := parseDERChain(entry.der)
+	if err != nil {
+		// Cannot happen for bytes that parsed when stored; treat as a miss
+		return nil, nil, false
+	}
+	return certs, entry.info, true
+}
+
+// Set implements CertCache
+// An entry expiring later than the new one is kept, so a slow fetch finishing
+// after a faster, newer one cannot replace the fresher chain
+func (c *MemoryCache) Set(key string, certs []*x509.Certificate, ttl time.Duration) {
+	c.SetWithInfo(key, certs, nil, ttl)
+}
+
+// SetWithInfo is Set that also keeps the connection the chain was fetched
+// over, so it expires and is replaced together with the chain
+func (c *MemoryCache) SetWithInfo(key string, certs []*x509.Certificate, info *ConnectionInfo, ttl time.Duration) {
+	entry := &cacheEntry{info: info, expiresAt: time.Now().Add(ttl)}
+	if c.storeDER {
+		entry.der = make([][]byte, len(certs))
+		for i, cert := range certs {
+			// Copy so the parsed certificate, which cert.Raw points into, can be freed
+			entry.der[i] = append([]byte(nil), cert.Raw...)
+		}
+	} else {
+		entry.certs = certs
+	}
+
+	c.mu.Lock()
+	if existing, found := c.entries[key]; !found || !existing.expiresAt.After(entry.expiresAt) {
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+}
+
+// Len returns the number of entries held, including expired ones not yet overwritten
+func (c *MemoryCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
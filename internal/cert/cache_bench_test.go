@@ -0,0 +1,86 @@
+package cert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// BenchmarkMemoryCacheFootprint compares the heap held per cached chain by the
+// parsed and DER (CERT_CACHE_COMPRESS) cache modes
+func BenchmarkMemoryCacheFootprint(b *testing.B) {
+	chain, err := GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		b.Fatalf("Failed to generate test chain: %v", err)
+	}
+
+	const entries = 1000
+	modes := map[string]func() *MemoryCache{
+		"parsed": NewMemoryCache,
+		"der":    NewDERMemoryCache,
+	}
+
+	for name, newCache := range modes {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				var before runtime.MemStats
+				runtime.ReadMemStats(&before)
+
+				// Each entry gets its own parsed chain, as after real retrievals
+				chains := make([][]*x509.Certificate, entries)
+				for j := range chains {
+					chains[j] = reparse(b, chain)
+				}
+
+				c := newCache()
+				for j := range chains {
+					c.Set(fmt.Sprintf("host%d.example.com", j), chains[j], time.Hour)
+				}
+				chains = nil
+
+				runtime.GC()
+				var after runtime.MemStats
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(c)
+
+				b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/entries, "bytes/entry")
+			}
+		})
+	}
+}
+
+// BenchmarkMemoryCacheGet compares the cost of a cache hit in both modes
+func BenchmarkMemoryCacheGet(b *testing.B) {
+	chain, err := GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		b.Fatalf("Failed to generate test chain: %v", err)
+	}
+
+	for name, c := range map[string]*MemoryCache{"parsed": NewMemoryCache(), "der": NewDERMemoryCache()} {
+		c.Set("example.com", chain, time.Hour)
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, found := c.Get("example.com"); !found {
+					b.Fatal("Expected cache hit")
+				}
+			}
+		})
+	}
+}
+
+// reparse returns an independently parsed copy of chain
+func reparse(b *testing.B, chain []*x509.Certificate) []*x509.Certificate {
+	copies := make([]*x509.Certificate, len(chain))
+	for i, c := range chain {
+		parsed, err := x509.ParseCertificate(c.Raw)
+		if err != nil {
+			b.Fatalf("Failed to parse certificate: %v", err)
+		}
+		copies[i] = parsed
+	}
+	return copies
+}
@@ -0,0 +1,123 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheFileVersion is the format version written to CERT_CACHE_FILE
+// Files of any other version are rejected on load
+const cacheFileVersion = 1
+
+// cacheFile is the on-disk form of a MemoryCache
+type cacheFile struct {
+	Version int              `json:"version"`
+	Entries []cacheFileEntry `json:"entries"`
+}
+
+// cacheFileEntry is one cached chain, stored as the DER bytes of each certificate
+type cacheFileEntry struct {
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Certs     [][]byte  `json:"certs"`
+}
+
+// SaveFile writes the unexpired entries of the cache to path, replacing the
+// file atomically so a crash mid-write never leaves a truncated cache behind
+func (c *MemoryCache) SaveFile(path string) error {
+	now := time.Now()
+	file := cacheFile{Version: cacheFileVersion, Entries: []cacheFileEntry{}}
+
+	c.mu.RLock()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			continue
+		}
+		der := entry.der
+		if der == nil {
+			der = make([][]byte, len(entry.certs))
+			for i, cert := range entry.certs {
+				der[i] = cert.Raw
+			}
+		}
+		file.Entries = append(file.Entries, cacheFileEntry{Key: key, ExpiresAt: entry.expiresAt, Certs: der})
+	}
+	c.mu.RUnlock()
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write certificate cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write certificate cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write certificate cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write certificate cache: %w", err)
+	}
+	return nil
+}
+
+// LoadFile adds the entries saved by SaveFile at path to the cache and returns
+// how many were loaded. Expired entries and unparsable chains are dropped, and
+// a missing file loads nothing without error (e.g. on first start).
+func (c *MemoryCache) LoadFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read certificate cache: %w", err)
+	}
+
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return 0, fmt.Errorf("failed to decode certificate cache: %w", err)
+	}
+	if file.Version != cacheFileVersion {
+		return 0, fmt.Errorf("unsupported certificate cache version %d (expected %d)", file.Version, cacheFileVersion)
+	}
+
+	now := time.Now()
+	loaded := 0
+	for _, saved := range file.Entries {
+		if !now.Before(saved.ExpiresAt) || len(saved.Certs) == 0 {
+			continue
+		}
+		certs, err := parseDERChain(saved.Certs)
+		if err != nil {
+			continue
+		}
+		c.Set(saved.Key, certs, saved.ExpiresAt.Sub(now))
+		loaded++
+	}
+	return loaded, nil
+}
+
+// parseDERChain parses the DER bytes of each certificate of a chain
+func parseDERChain(der [][]byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(der))
+	for _, raw := range der {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
@@ -0,0 +1,111 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheFileRoundTrip(t *testing.T) {
+	testCert, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "cert-cache.json")
+
+	for _, tc := range []struct {
+		name string
+		c    *MemoryCache
+	}{
+		{"parsed", NewMemoryCache()},
+		{"der", NewDERMemoryCache()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.c.Set("example.com", []*x509.Certificate{testCert}, time.Hour)
+			tc.c.Set("example.com:8443/tls", []*x509.Certificate{testCert}, time.Hour)
+			tc.c.Set("expired.example.com", []*x509.Certificate{testCert}, -time.Second)
+			if err := tc.c.SaveFile(path); err != nil {
+				t.Fatalf("SaveFile failed: %v", err)
+			}
+
+			loaded := NewMemoryCache()
+			n, err := loaded.LoadFile(path)
+			if err != nil {
+				t.Fatalf("LoadFile failed: %v", err)
+			}
+			if n != 2 {
+				t.Errorf("Expected 2 entries loaded, got %d", n)
+			}
+			for _, key := range []string{"example.com", "example.com:8443/tls"} {
+				certs, found := loaded.Get(key)
+				if !found || len(certs) != 1 || !certs[0].Equal(testCert) {
+					t.Errorf("Expected %s to be restored, got %v (found=%v)", key, certs, found)
+				}
+			}
+			if _, found := loaded.Get("expired.example.com"); found {
+				t.Error("Expected expired entry not to be restored")
+			}
+		})
+	}
+}
+
+func TestMemoryCacheLoadFileDropsExpired(t *testing.T) {
+	testCert, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	// Entries that were live when saved but expired before the restart
+	path := filepath.Join(t.TempDir(), "cert-cache.json")
+	data, err := json.Marshal(cacheFile{
+		Version: cacheFileVersion,
+		Entries: []cacheFileEntry{
+			{Key: "fresh.example.com", ExpiresAt: time.Now().Add(time.Hour), Certs: [][]byte{testCert.Raw}},
+			{Key: "stale.example.com", ExpiresAt: time.Now().Add(-time.Minute), Certs: [][]byte{testCert.Raw}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode cache file: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write cache file: %v", err)
+	}
+
+	c := NewMemoryCache()
+	n, err := c.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 entry loaded, got %d", n)
+	}
+	if _, found := c.Get("fresh.example.com"); !found {
+		t.Error("Expected unexpired entry to be restored")
+	}
+	if _, found := c.Get("stale.example.com"); found {
+		t.Error("Expected expired entry to be dropped")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Expected expired entry not to be stored, cache holds %d", c.Len())
+	}
+}
+
+func TestMemoryCacheLoadFileVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cert-cache.json")
+	if err := os.WriteFile(path, []byte(`{"version":99,"entries":[]}`), 0o600); err != nil {
+		t.Fatalf("Failed to write cache file: %v", err)
+	}
+	if _, err := NewMemoryCache().LoadFile(path); err == nil {
+		t.Error("Expected an error for an unsupported cache file version")
+	}
+}
+
+func TestMemoryCacheLoadFileMissing(t *testing.T) {
+	n, err := NewMemoryCache().LoadFile(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || n != 0 {
+		t.Errorf("Expected a missing file to load nothing without error, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,319 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubCache is a CertCache recording its calls, standing in for an external store
+type stubCache struct {
+	mu      sync.Mutex
+	entries map[string][]*x509.Certificate
+	gets    []string
+	sets    map[string]time.Duration
+}
+
+func newStubCache() *stubCache {
+	return &stubCache{
+		entries: make(map[string][]*x509.Certificate),
+		sets:    make(map[string]time.Duration),
+	}
+}
+
+func (c *stubCache) Get(key string) ([]*x509.Certificate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets = append(c.gets, key)
+	certs, found := c.entries[key]
+	return certs, found
+}
+
+func (c *stubCache) Set(key string, certs []*x509.Certificate, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = certs
+	c.sets[key] = ttl
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCert, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	c := NewMemoryCache()
+	if _, found := c.Get("example.com"); found {
+		t.Error("Expected miss on empty cache")
+	}
+
+	c.Set("example.com", []*x509.Certificate{testCert}, time.Minute)
+	certs, found := c.Get("example.com")
+	if !found || len(certs) != 1 || !certs[0].Equal(testCert) {
+		t.Errorf("Expected cached certificate, got %v (found=%v)", certs, found)
+	}
+
+	c.Set("expired.example.com", []*x509.Certificate{testCert}, -time.Second)
+	if _, found := c.Get("expired.example.com"); found {
+		t.Error("Expected miss on expired entry")
+	}
+}
+
+func TestMemoryCache_KeepsFresherEntry(t *testing.T) {
+	c := NewMemoryCache()
+
+	// Writers race to store chains expiring at different times; whatever the
+	// order of the writes, the entry expiring last must be the one kept
+	const writers = 20
+	certs := make([]*x509.Certificate, writers)
+	for i := range certs {
+		testCert, err := GenerateTestCertificate("example.com")
+		if err != nil {
+			t.Fatalf("Failed to generate test certificate: %v", err)
+		}
+		certs[i] = testCert
+	}
+
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Set("example.com", certs[i:i+1], time.Minute+time.Duration(i)*time.Second)
+		}()
+	}
+	wg.Wait()
+
+	got, found := c.Get("example.com")
+	if !found || !got[0].Equal(certs[writers-1]) {
+		t.Error("Expected the entry expiring last to survive concurrent writes")
+	}
+
+	// A later write with an earlier expiry does not replace it
+	c.Set("example.com", certs[:1], time.Minute)
+	if got, _ := c.Get("example.com"); !got[0].Equal(certs[writers-1]) {
+		t.Error("Expected an entry expiring earlier not to replace a fresher one")
+	}
+}
+
+func TestMemoryCache_ConnectionInfo(t *testing.T) {
+	testCert, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	info := &ConnectionInfo{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256}
+
+	for _, c := range []*MemoryCache{NewMemoryCache(), NewDERMemoryCache()} {
+		c.SetWithInfo("example.com", []*x509.Certificate{testCert}, info, time.Minute)
+		if _, got, found := c.GetWithInfo("example.com"); !found || got != info {
+			t.Errorf("Expected cached connection info %+v, got %+v (found=%v)", info, got, found)
+		}
+
+		// The connection info expires with its chain
+		c.SetWithInfo("expired.example.com", []*x509.Certificate{testCert}, info, -time.Second)
+		if _, got, found := c.GetWithInfo("expired.example.com"); found || got != nil {
+			t.Errorf("Expected miss on expired entry, got %+v (found=%v)", got, found)
+		}
+
+		// Chains cached without a connection report none
+		c.Set("other.example.com", []*x509.Certificate{testCert}, time.Hour)
+		if _, got, _ := c.GetWithInfo("other.example.com"); got != nil {
+			t.Errorf("Expected no connection info, got %+v", got)
+		}
+	}
+}
+
+func TestRetriever_CustomCache(t *testing.T) {
+	server := NewMockTLSServer(t)
+
+	cache := newStubCache()
+	r := newTestRetriever(server, time.Minute)
+	r.SetCache(cache)
+
+	opts := FetchOptions{Port: server.Port()}
+	if _, err := r.GetCertificatesWithOptions(server.Host(), opts); err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+
+	key := cacheKey(server.Host(), server.Port(), "")
+	// The TTL is counted from the start of the fetch
+	if ttl, stored := cache.sets[key]; !stored || ttl > time.Minute || ttl < 50*time.Second {
+		t.Fatalf("Expected chain stored under %q with TTL just under 1m, got %v", key, cache.sets)
+	}
+
+	// Second call must be served by the custom cache even though the server is gone
+	server.Close()
+	certs, err := r.GetCertificatesWithOptions(server.Host(), opts)
+	if err != nil {
+		t.Fatalf("Expected certificates from custom cache, got error: %v", err)
+	}
+	if !certs[0].Equal(server.Certificate()) {
+		t.Error("Cached certificate does not match the server certificate")
+	}
+	if len(cache.gets) != 2 {
+		t.Errorf("Expected 2 cache lookups, got %d", len(cache.gets))
+	}
+}
+
+func TestRetriever_DomainCacheTTLs(t *testing.T) {
+	server := NewMockTLSServer(t)
+	defer server.Close()
+
+	// Both names reach the mock server, whose certificate covers them
+	cache := newStubCache()
+	r := newTestRetriever(server, 10*time.Minute)
+	r.SetCache(cache)
+	r.SetDomainCacheTTLs(map[string]time.Duration{"LocalHost": 30 * time.Second})
+
+	opts := FetchOptions{Port: server.Port()}
+	for _, domain := range []string{"localhost", "127.0.0.1"} {
+		if _, err := r.GetCertificatesWithOptions(domain, opts); err != nil {
+			t.Fatalf("Failed to retrieve certificates for %s: %v", domain, err)
+		}
+	}
+
+	tests := []struct {
+		domain string
+		ttl    time.Duration
+	}{
+		{"localhost", 30 * time.Second},
+		{"127.0.0.1", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		ttl := cache.sets[cacheKey(tt.domain, server.Port(), "")]
+		if ttl > tt.ttl || ttl < tt.ttl-5*time.Second {
+			t.Errorf("Expected %s cached for about %v, got %v", tt.domain, tt.ttl, ttl)
+		}
+	}
+
+	// An override of 0 disables caching for that domain only
+	r.SetDomainCacheTTLs(map[string]time.Duration{"localhost": 0})
+	cache.gets = nil
+	if _, err := r.GetCertificatesWithOptions("localhost", opts); err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+	if len(cache.gets) != 0 {
+		t.Errorf("Expected no cache lookups for a domain with caching disabled, got %v", cache.gets)
+	}
+}
+
+func TestRetriever_CustomCacheDisabledTTL(t *testing.T) {
+	server := NewMockTLSServer(t)
+	defer server.Close()
+
+	cache := newStubCache()
+	r := newTestRetriever(server, 0)
+	r.SetCache(cache)
+
+	if _, err := r.GetCertificatesWithOptions(server.Host(), FetchOptions{Port: server.Port()}); err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+	if len(cache.gets) != 0 || len(cache.sets) != 0 {
+		t.Errorf("Expected cache to be unused with TTL 0, got gets=%v sets=%v", cache.gets, cache.sets)
+	}
+}
+
+func TestRetriever_CacheEntries(t *testing.T) {
+	testCert, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	r := NewRetriever(time.Second, time.Minute)
+	if entries, known := r.CacheEntries(); !known || entries != 0 {
+		t.Errorf("Expected 0 known entries, got %d (known=%v)", entries, known)
+	}
+
+	cache := NewMemoryCache()
+	cache.Set("a.example.com", []*x509.Certificate{testCert}, time.Minute)
+	cache.Set("b.example.com", []*x509.Certificate{testCert}, time.Minute)
+	r.SetCache(cache)
+	if entries, known := r.CacheEntries(); !known || entries != 2 {
+		t.Errorf("Expected 2 known entries, got %d (known=%v)", entries, known)
+	}
+
+	// Caches without Len cannot report their size
+	r.SetCache(newStubCache())
+	if _, known := r.CacheEntries(); known {
+		t.Error("Expected unknown size for a cache without Len")
+	}
+}
+
+func TestDERMemoryCache_MatchesMemoryCache(t *testing.T) {
+	chain, err := GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test chain: %v", err)
+	}
+
+	plain := NewMemoryCache()
+	der := NewDERMemoryCache()
+	plain.Set("example.com", chain, time.Minute)
+	der.Set("example.com", chain, time.Minute)
+
+	want, found := plain.Get("example.com")
+	if !found {
+		t.Fatal("Expected hit in uncompressed cache")
+	}
+	got, found := der.Get("example.com")
+	if !found {
+		t.Fatal("Expected hit in DER cache")
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d certificates, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("Certificate %d differs after DER round trip", i)
+		}
+		if got[i].Subject.CommonName != want[i].Subject.CommonName || !got[i].NotAfter.Equal(want[i].NotAfter) {
+			t.Errorf("Certificate %d fields differ after DER round trip", i)
+		}
+	}
+
+	der.Set("expired.example.com", chain, -time.Second)
+	if _, found := der.Get("expired.example.com"); found {
+		t.Error("Expected miss on expired DER entry")
+	}
+	if der.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", der.Len())
+	}
+}
+
+func TestRetriever_CacheStats(t *testing.T) {
+	testCert, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	r := NewRetriever(time.Second, time.Minute)
+	cache := NewMemoryCache()
+	cache.Set("example.com", []*x509.Certificate{testCert}, time.Minute)
+	r.SetCache(cache)
+
+	for range 2 {
+		if _, err := r.GetCertificates("example.com"); err != nil {
+			t.Fatalf("Expected cached certificates, got error: %v", err)
+		}
+	}
+
+	// Nothing listens on the port, so the lookup misses and the fetch fails
+	if _, err := r.GetCertificatesWithOptions("127.0.0.1", FetchOptions{Port: unusedPort(t)}); err == nil {
+		t.Error("Expected error for an unreachable uncached domain")
+	}
+
+	if hits, misses := r.CacheStats(); hits != 2 || misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %d hits and %d misses", hits, misses)
+	}
+
+	// Without caching no lookups are made
+	r = NewRetriever(time.Second, 0)
+	if _, err := r.GetCertificatesWithOptions("127.0.0.1", FetchOptions{Port: unusedPort(t)}); err == nil {
+		t.Error("Expected error for an unreachable domain")
+	}
+	if hits, misses := r.CacheStats(); hits != 0 || misses != 0 {
+		t.Errorf("Expected no lookups with TTL 0, got %d hits and %d misses", hits, misses)
+	}
+}
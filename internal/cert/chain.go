@@ -0,0 +1,73 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+)
+
+// FindLeaf identifies the leaf certificate of a chain regardless of its order
+// The leaf is the certificate that issued no other certificate in the chain; if
+// several qualify, the one valid for domain wins. Falls back to chain[0].
+func FindLeaf(chain []*x509.Certificate, domain string) *x509.Certificate {
+	if len(chain) == 0 {
+		return nil
+	}
+
+	var candidates []*x509.Certificate
+	for i, cert := range chain {
+		issuesOther := false
+		for j, other := range chain {
+			if i != j && bytes.Equal(other.RawIssuer, cert.RawSubject) && !bytes.Equal(other.RawSubject, cert.RawSubject) {
+				issuesOther = true
+				break
+			}
+		}
+		if !issuesOther {
+			candidates = append(candidates, cert)
+		}
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	for _, cert := range candidates {
+		if cert.VerifyHostname(domain) == nil {
+			return cert
+		}
+	}
+	return chain[0]
+}
+
+// OrderChain returns the chain starting at the leaf found by FindLeaf, followed by
+// its issuers as far as they are present, then any remaining certificates
+func OrderChain(chain []*x509.Certificate, domain string) []*x509.Certificate {
+	leaf := FindLeaf(chain, domain)
+	if leaf == nil {
+		return chain
+	}
+
+	ordered := []*x509.Certificate{leaf}
+	used := map[*x509.Certificate]bool{leaf: true}
+	for current := leaf; ; {
+		var issuer *x509.Certificate
+		for _, cert := range chain {
+			if !used[cert] && bytes.Equal(cert.RawSubject, current.RawIssuer) {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		ordered = append(ordered, issuer)
+		used[issuer] = true
+		current = issuer
+	}
+
+	for _, cert := range chain {
+		if !used[cert] {
+			ordered = append(ordered, cert)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,63 @@
+package cert
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestFindLeaf(t *testing.T) {
+	chain, err := GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	leaf, intermediate := chain[0], chain[1]
+
+	tests := []struct {
+		name  string
+		chain []*x509.Certificate
+	}{
+		{"leaf_first", []*x509.Certificate{leaf, intermediate}},
+		{"intermediate_first", []*x509.Certificate{intermediate, leaf}},
+		{"leaf_only", []*x509.Certificate{leaf}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindLeaf(tt.chain, "example.com"); got != leaf {
+				t.Errorf("Expected leaf %q, got %q", leaf.Subject.CommonName, got.Subject.CommonName)
+			}
+		})
+	}
+
+	if FindLeaf(nil, "example.com") != nil {
+		t.Error("Expected nil leaf for empty chain")
+	}
+}
+
+func TestFindLeaf_MatchesDomain(t *testing.T) {
+	// Two unrelated self-signed certificates: the one valid for the domain wins
+	other, err := GenerateTestCertificate("other.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	target, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	if got := FindLeaf([]*x509.Certificate{other, target}, "example.com"); got != target {
+		t.Errorf("Expected certificate for example.com, got %q", got.Subject.CommonName)
+	}
+}
+
+func TestOrderChain(t *testing.T) {
+	chain, err := GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+
+	ordered := OrderChain([]*x509.Certificate{chain[1], chain[0]}, "example.com")
+	if len(ordered) != 2 || ordered[0] != chain[0] || ordered[1] != chain[1] {
+		t.Error("Expected reordered chain to start with the leaf followed by its issuer")
+	}
+}
@@ -0,0 +1,107 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Reasons returned by ClassifyDialError
+const (
+	DialReasonTimeout              = "timeout"
+	DialReasonDNS                  = "dns_failure"
+	DialReasonConnectionRefused    = "connection_refused"
+	DialReasonConnectionReset      = "connection_reset"
+	DialReasonTLSAlert             = "tls_alert"
+	DialReasonNotTLS               = "not_tls"
+	DialReasonUntrustedCertificate = "untrusted_certificate"
+	DialReasonHostnameMismatch     = "hostname_mismatch"
+	DialReasonInvalidCertificate   = "invalid_certificate"
+	DialReasonNoCertificates       = "no_certificates"
+	DialReasonUnknown              = "unknown"
+)
+
+// ClassifyDialError maps a certificate retrieval error to a machine-readable
+// reason and a short detail safe to return to clients: the detail is built from
+// fixed phrases (and, for TLS alerts, the alert name) so that addresses and
+// other internal information in the underlying error never leak
+func ClassifyDialError(err error) (reason, detail string) {
+	if err == nil {
+		return "", ""
+	}
+
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrNoCertificates):
+		return DialReasonNoCertificates, "upstream presented no certificates"
+	case errors.As(err, &unknownAuthority):
+		return DialReasonUntrustedCertificate, "certificate signed by unknown authority"
+	case errors.As(err, &hostnameErr):
+		return DialReasonHostnameMismatch, "certificate is not valid for the requested host"
+	case errors.As(err, &invalidErr):
+		if invalidErr.Reason == x509.Expired {
+			return DialReasonInvalidCertificate, "certificate has expired or is not yet valid"
+		}
+		return DialReasonInvalidCertificate, "certificate is invalid"
+	case errors.As(err, &recordErr):
+		return DialReasonNotTLS, "upstream did not respond with TLS"
+	case errors.As(err, &dnsErr):
+		return DialReasonDNS, "host name could not be resolved"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return DialReasonConnectionRefused, "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return DialReasonConnectionReset, "connection reset by upstream"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return DialReasonTimeout, "connection timed out"
+	}
+
+	// Fall back to the error text for errors that lost their type along the way
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "remote error: tls: "):
+		return DialReasonTLSAlert, "tls alert: " + alertName(msg[strings.Index(msg, "remote error: tls: ")+len("remote error: tls: "):])
+	case strings.Contains(msg, "first record does not look like a tls handshake"):
+		return DialReasonNotTLS, "upstream did not respond with TLS"
+	case strings.Contains(msg, "certificate signed by unknown authority"):
+		return DialReasonUntrustedCertificate, "certificate signed by unknown authority"
+	case strings.Contains(msg, "certificate is valid for"), strings.Contains(msg, "certificate is not valid for any names"):
+		return DialReasonHostnameMismatch, "certificate is not valid for the requested host"
+	case strings.Contains(msg, "certificate has expired or is not yet valid"):
+		return DialReasonInvalidCertificate, "certificate has expired or is not yet valid"
+	case strings.Contains(msg, "no such host"):
+		return DialReasonDNS, "host name could not be resolved"
+	case strings.Contains(msg, "connection refused"):
+		return DialReasonConnectionRefused, "connection refused"
+	case strings.Contains(msg, "connection reset"):
+		return DialReasonConnectionReset, "connection reset by upstream"
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "deadline exceeded"):
+		return DialReasonTimeout, "connection timed out"
+	case strings.Contains(msg, "tls: handshake failure"):
+		return DialReasonTLSAlert, "tls alert: handshake failure"
+	}
+
+	return DialReasonUnknown, ""
+}
+
+// alertName extracts the TLS alert name at the start of s, keeping only
+// lowercase letters and spaces (e.g. "handshake failure")
+func alertName(s string) string {
+	end := 0
+	for end < len(s) && (s[end] == ' ' || (s[end] >= 'a' && s[end] <= 'z')) {
+		end++
+	}
+	if name := strings.TrimSpace(s[:end]); name != "" {
+		return name
+	}
+	return "unknown"
+}
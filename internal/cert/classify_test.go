@@ -0,0 +1,119 @@
+package cert
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyDialError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedReason string
+		expectedDetail string
+	}{
+		{
+			name:           "tls_alert",
+			err:            errors.New("failed to connect to example.com:443: remote error: tls: handshake failure"),
+			expectedReason: DialReasonTLSAlert,
+			expectedDetail: "tls alert: handshake failure",
+		},
+		{
+			name:           "tls_alert_protocol_version",
+			err:            errors.New("remote error: tls: protocol version not supported"),
+			expectedReason: DialReasonTLSAlert,
+			expectedDetail: "tls alert: protocol version not supported",
+		},
+		{
+			name:           "not_tls",
+			err:            errors.New("tls: first record does not look like a TLS handshake"),
+			expectedReason: DialReasonNotTLS,
+			expectedDetail: "upstream did not respond with TLS",
+		},
+		{
+			name:           "unknown_authority",
+			err:            fmt.Errorf("failed to connect: %w", x509.UnknownAuthorityError{}),
+			expectedReason: DialReasonUntrustedCertificate,
+			expectedDetail: "certificate signed by unknown authority",
+		},
+		{
+			name:           "unknown_authority_string",
+			err:            errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority"),
+			expectedReason: DialReasonUntrustedCertificate,
+			expectedDetail: "certificate signed by unknown authority",
+		},
+		{
+			name:           "hostname_mismatch_string",
+			err:            errors.New("x509: certificate is valid for other.com, not example.com"),
+			expectedReason: DialReasonHostnameMismatch,
+			expectedDetail: "certificate is not valid for the requested host",
+		},
+		{
+			name:           "expired",
+			err:            fmt.Errorf("failed to connect: %w", x509.CertificateInvalidError{Reason: x509.Expired}),
+			expectedReason: DialReasonInvalidCertificate,
+			expectedDetail: "certificate has expired or is not yet valid",
+		},
+		{
+			name:           "dns",
+			err:            fmt.Errorf("failed to connect: %w", &net.DNSError{Err: "no such host", Name: "example.invalid"}),
+			expectedReason: DialReasonDNS,
+			expectedDetail: "host name could not be resolved",
+		},
+		{
+			name:           "connection_refused",
+			err:            fmt.Errorf("dial tcp 10.0.0.1:443: %w", syscall.ECONNREFUSED),
+			expectedReason: DialReasonConnectionRefused,
+			expectedDetail: "connection refused",
+		},
+		{
+			name:           "connection_reset_string",
+			err:            errors.New("read tcp 10.0.0.2:5555->10.0.0.1:443: read: connection reset by peer"),
+			expectedReason: DialReasonConnectionReset,
+			expectedDetail: "connection reset by upstream",
+		},
+		{
+			name:           "timeout",
+			err:            fmt.Errorf("failed to connect: %w", context.DeadlineExceeded),
+			expectedReason: DialReasonTimeout,
+			expectedDetail: "connection timed out",
+		},
+		{
+			name:           "no_certificates",
+			err:            fmt.Errorf("%w for domain: example.com", ErrNoCertificates),
+			expectedReason: DialReasonNoCertificates,
+			expectedDetail: "upstream presented no certificates",
+		},
+		{
+			name:           "unknown",
+			err:            errors.New("something unexpected at 10.0.0.1"),
+			expectedReason: DialReasonUnknown,
+			expectedDetail: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, detail := ClassifyDialError(tt.err)
+			if reason != tt.expectedReason {
+				t.Errorf("Expected reason %q, got %q", tt.expectedReason, reason)
+			}
+			if detail != tt.expectedDetail {
+				t.Errorf("Expected detail %q, got %q", tt.expectedDetail, detail)
+			}
+			if strings.Contains(detail, "10.0.0.") {
+				t.Errorf("Detail leaks an address: %q", detail)
+			}
+		})
+	}
+
+	if reason, detail := ClassifyDialError(nil); reason != "" || detail != "" {
+		t.Errorf("Expected empty classification for nil error, got %q/%q", reason, detail)
+	}
+}
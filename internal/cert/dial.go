@@ -0,0 +1,83 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Timeouts splits the certificate retrieval budget into phases
+// A zero phase timeout falls back to the retriever's dial timeout, which also
+// bounds the retrieval as a whole
+type Timeouts struct {
+	Resolve   time.Duration // DNS resolution
+	Connect   time.Duration // TCP connect (per resolved address)
+	Handshake time.Duration // TLS handshake
+}
+
+// hostResolver resolves host names to addresses (implemented by *net.Resolver)
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// SetTimeouts sets the per-phase timeouts used for certificate retrieval
+func (r *Retriever) SetTimeouts(timeouts Timeouts) {
+	r.timeouts = timeouts
+}
+
+// phaseTimeout returns the timeout for a phase, falling back to the dial timeout
+func (r *Retriever) phaseTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return r.dialTimeout
+}
+
+// withTimeout derives a context bounded by timeout (unbounded if timeout is 0)
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// dial resolves the domain and connects to the first reachable address,
+// applying the resolve and connect budgets within ctx
+func (r *Retriever) dial(ctx context.Context, domain string, port int) (net.Conn, error) {
+	resolveCtx, cancel := withTimeout(ctx, r.phaseTimeout(r.timeouts.Resolve))
+	addrs, err := r.resolver.LookupHost(resolveCtx, domain)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", domain, err)
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		connectCtx, cancel := withTimeout(ctx, r.phaseTimeout(r.timeouts.Connect))
+		conn, err := r.dialContext(connectCtx, "tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses found")
+	}
+	return nil, fmt.Errorf("failed to connect to %s: %w", domain, lastErr)
+}
+
+// handshake performs the TLS client handshake on conn within the handshake budget
+func (r *Retriever) handshake(ctx context.Context, conn net.Conn, domain string) (*tls.Conn, error) {
+	handshakeCtx, cancel := withTimeout(ctx, r.phaseTimeout(r.timeouts.Handshake))
+	defer cancel()
+
+	tlsConn := tls.Client(conn, r.tlsConfig(domain))
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", domain, err)
+	}
+	return tlsConn, nil
+}
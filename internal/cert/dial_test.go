@@ -0,0 +1,122 @@
+package cert
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingResolver never answers until the context is done
+type blockingResolver struct{}
+
+func (blockingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// blockingDial never connects until the context is done
+func blockingDial(ctx context.Context, network, address string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// newSilentServer accepts TCP connections but never speaks TLS
+func newSilentServer(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		// Hold connections open without responding until the listener closes
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return listener
+}
+
+func TestFetchCertificates_PhaseTimeouts(t *testing.T) {
+	silent := newSilentServer(t)
+	defer silent.Close()
+	silentPort := silent.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name        string
+		timeouts    Timeouts
+		setup       func(r *Retriever)
+		expectedErr string
+	}{
+		{
+			name:        "resolve",
+			timeouts:    Timeouts{Resolve: 50 * time.Millisecond},
+			setup:       func(r *Retriever) { r.resolver = blockingResolver{} },
+			expectedErr: "failed to resolve",
+		},
+		{
+			name:        "connect",
+			timeouts:    Timeouts{Connect: 50 * time.Millisecond},
+			setup:       func(r *Retriever) { r.dialContext = blockingDial },
+			expectedErr: "failed to connect",
+		},
+		{
+			name:        "handshake",
+			timeouts:    Timeouts{Handshake: 50 * time.Millisecond},
+			setup:       func(r *Retriever) {},
+			expectedErr: "TLS handshake",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The overall budget is far larger than the phase under test
+			r := NewRetriever(10*time.Second, 0)
+			r.SetTimeouts(tt.timeouts)
+			tt.setup(r)
+
+			start := time.Now()
+			_, err := r.GetCertificatesWithOptions("127.0.0.1", FetchOptions{Port: silentPort})
+			elapsed := time.Since(start)
+
+			if err == nil {
+				t.Fatal("Expected timeout error")
+			}
+			if !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expectedErr, err)
+			}
+			if elapsed > 2*time.Second {
+				t.Errorf("Expected %s phase to time out quickly, took %v", tt.name, elapsed)
+			}
+		})
+	}
+}
+
+func TestFetchCertificates_PhaseTimeoutFallback(t *testing.T) {
+	silent := newSilentServer(t)
+	defer silent.Close()
+
+	// Without phase timeouts the dial timeout bounds the handshake
+	r := NewRetriever(100*time.Millisecond, 0)
+
+	start := time.Now()
+	_, err := r.GetCertificatesWithOptions("127.0.0.1", FetchOptions{Port: silent.Addr().(*net.TCPAddr).Port})
+	if err == nil {
+		t.Fatal("Expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected dial timeout to apply, took %v", elapsed)
+	}
+}
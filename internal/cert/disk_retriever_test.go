@@ -0,0 +1,51 @@
+package cert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pinning-server/internal/crypto"
+)
+
+func TestDiskRetriever_Fixture(t *testing.T) {
+	r := NewDiskRetriever("testdata")
+
+	certs, err := r.GetCertificatesWithOptions("example.com", FetchOptions{Port: 8443})
+	if err != nil {
+		t.Fatalf("Failed to load certificates: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("Expected leaf and CA, got %d certificates", len(certs))
+	}
+	if certs[0].Subject.CommonName != "example.com" {
+		t.Errorf("Expected leaf first, got %q", certs[0].Subject.CommonName)
+	}
+
+	// Expected pins computed with:
+	// openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+	expected := []string{
+		"VJ2ncp7iFMWrZ6iWsNj5wr3W3EP7lh+4gqaVM5Q7K4A=",
+		"lrFLWbRCRV2m/i6FRX+UlV8mGU+iLnvHr9ZgzBrYUDQ=",
+	}
+	pins := crypto.GenerateSPKIHashes(certs)
+	for i := range expected {
+		if pins[i] != expected[i] {
+			t.Errorf("Pin %d = %q, want %q", i, pins[i], expected[i])
+		}
+	}
+}
+
+func TestDiskRetriever_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "empty.example.com.pem"), []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	r := NewDiskRetriever(dir)
+	for _, domain := range []string{"missing.example.com", "empty.example.com", "../example.com", "a/b", ""} {
+		if _, err := r.GetCertificates(domain); err == nil {
+			t.Errorf("Expected error for domain %q", domain)
+		}
+	}
+}
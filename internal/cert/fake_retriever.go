@@ -1,6 +1,7 @@
 package cert
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -8,19 +9,31 @@ import (
 	"crypto/x509/pkix"
 	"fmt"
 	"math/big"
+	"sync"
 	"time"
 )
 
 // FakeRetriever is a test double for CertRetriever
 type FakeRetriever struct {
-	certs map[string][]*x509.Certificate
-	err   error
+	certs       map[string][]*x509.Certificate
+	err         error
+	delay       time.Duration
+	lastOptions FetchOptions
+
+	// stop aborts delayed calls on Shutdown; pending tracks them and closing,
+	// guarded by mu, refuses new ones
+	stop     chan struct{}
+	stopOnce sync.Once
+	pending  sync.WaitGroup
+	mu       sync.Mutex
+	closing  bool
 }
 
 // NewFakeRetriever creates a fake retriever with default test certificates
 func NewFakeRetriever() *FakeRetriever {
 	return &FakeRetriever{
 		certs: make(map[string][]*x509.Certificate),
+		stop:  make(chan struct{}),
 	}
 }
 
@@ -34,8 +47,52 @@ func (f *FakeRetriever) SetError(err error) {
 	f.err = err
 }
 
+// SetDelay makes every GetCertificates call take d, like a slow upstream
+func (f *FakeRetriever) SetDelay(d time.Duration) {
+	f.delay = d
+}
+
+// Shutdown mirrors Retriever.Shutdown: it refuses new calls, waits for delayed
+// calls in flight until ctx is done and then aborts them with ErrShuttingDown
+func (f *FakeRetriever) Shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	f.closing = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		f.stopOnce.Do(func() { close(f.stop) })
+		<-done
+		return ctx.Err()
+	}
+}
+
 // GetCertificates implements CertRetriever interface
 func (f *FakeRetriever) GetCertificates(domain string) ([]*x509.Certificate, error) {
+	f.mu.Lock()
+	if f.closing {
+		f.mu.Unlock()
+		return nil, fmt.Errorf("%w: not fetching %s", ErrShuttingDown, domain)
+	}
+	f.pending.Add(1)
+	f.mu.Unlock()
+	defer f.pending.Done()
+
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-f.stop:
+			return nil, fmt.Errorf("%w: fetch of %s aborted", ErrShuttingDown, domain)
+		}
+	}
 	if f.err != nil {
 		return nil, f.err
 	}
@@ -48,14 +105,21 @@ func (f *FakeRetriever) GetCertificates(domain string) ([]*x509.Certificate, err
 	return certs, nil
 }
 
+// GetCertificatesWithOptions implements CertRetriever interface
+// The options are recorded and can be inspected with LastOptions
+func (f *FakeRetriever) GetCertificatesWithOptions(domain string, opts FetchOptions) ([]*x509.Certificate, error) {
+	f.lastOptions = opts
+	return f.GetCertificates(domain)
+}
+
+// LastOptions returns the options passed to the most recent GetCertificatesWithOptions call
+func (f *FakeRetriever) LastOptions() FetchOptions {
+	return f.lastOptions
+}
+
 // GenerateTestCertificate creates a self-signed certificate for testing
 func GenerateTestCertificate(commonName string) (*x509.Certificate, error) {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, err
-	}
-
-	template := x509.Certificate{
+	return GenerateTestCertificateWithTemplate(&x509.Certificate{
 		SerialNumber: big.NewInt(1),
 		Subject: pkix.Name{
 			Organization: []string{"Test Org"},
@@ -67,9 +131,18 @@ func GenerateTestCertificate(commonName string) (*x509.Certificate, error) {
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		DNSNames:              []string{commonName},
+	})
+}
+
+// GenerateTestCertificateWithTemplate creates a self-signed certificate from a custom template
+// A fresh ECDSA P-256 key is generated for every certificate
+func GenerateTestCertificateWithTemplate(template *x509.Certificate) (*x509.Certificate, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -98,3 +171,138 @@ func GenerateTestCertificateChain(commonName string) ([]*x509.Certificate, error
 
 	return []*x509.Certificate{leafCert, intermediateCert}, nil
 }
+
+// GenerateSignedTestCertificateChain creates a chain whose leaf is issued by the intermediate
+// Unlike GenerateTestCertificateChain, the leaf carries an authority key identifier
+// matching the intermediate's subject key identifier
+func GenerateSignedTestCertificateChain(commonName string) ([]*x509.Certificate, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Test Org"},
+			CommonName:   "Intermediate CA",
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			Organization: []string{"Test Org"},
+			CommonName:   commonName,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{commonName},
+	}
+
+	leafBytes, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	leafCert, err := x509.ParseCertificate(leafBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*x509.Certificate{leafCert, caCert}, nil
+}
+
+// GenerateCrossSignedTestChain creates a chain valid under two roots, as after a
+// CA cross-signs its successor: the leaf is issued by "New Root", and the chain
+// also carries "New Root" cross-signed by "Old Root". Both self-signed roots are
+// returned, new root first, for use as a verification pool.
+func GenerateCrossSignedTestChain(commonName string) (chain, roots []*x509.Certificate, err error) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caTemplate := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{Organization: []string{"Test Org"}, CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+	}
+
+	oldRoot, err := createTestCertificate(caTemplate(1, "Old Root"), nil, &oldKey.PublicKey, oldKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	newRoot, err := createTestCertificate(caTemplate(2, "New Root"), nil, &newKey.PublicKey, newKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	crossSigned, err := createTestCertificate(caTemplate(3, "New Root"), oldRoot, &newKey.PublicKey, oldKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	leaf, err := createTestCertificate(&x509.Certificate{
+		SerialNumber:          big.NewInt(4),
+		Subject:               pkix.Name{Organization: []string{"Test Org"}, CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{commonName},
+	}, newRoot, &leafKey.PublicKey, newKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []*x509.Certificate{leaf, crossSigned}, []*x509.Certificate{newRoot, oldRoot}, nil
+}
+
+// createTestCertificate signs template with parentKey; a nil parent self-signs
+func createTestCertificate(template, parent *x509.Certificate, pub *ecdsa.PublicKey, parentKey *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	if parent == nil {
+		parent = template
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certBytes)
+}
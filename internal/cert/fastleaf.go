@@ -0,0 +1,59 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// errLeafReceived aborts a fast-leaf handshake once the chain has been verified
+var errLeafReceived = errors.New("certificate chain received")
+
+// SetFastLeaf makes plain TLS and STARTTLS fetches abort the handshake as soon
+// as the server's Certificate message has been received and its chain verified
+// (CERT_FAST_LEAF). Under TLS 1.2 this saves the client's key exchange flight
+// and the round trip to the server's Finished; under TLS 1.3 it skips the
+// CertificateVerify check and the client's Finished. The server's possession of
+// the private key is then never proven, which pinning does not need since the
+// pins are hashes of the public key.
+func (r *Retriever) SetFastLeaf(enabled bool) {
+	r.fastLeaf = enabled
+}
+
+// fastLeafHandshake starts the TLS client handshake on conn and aborts it right
+// after the server's certificates are verified, returning them along with the
+// negotiated version, cipher suite and OCSP staple known at that point
+func (r *Retriever) fastLeafHandshake(ctx context.Context, conn net.Conn, domain string) ([]*x509.Certificate, *ConnectionInfo, error) {
+	handshakeCtx, cancel := withTimeout(ctx, r.phaseTimeout(r.timeouts.Handshake))
+	defer cancel()
+
+	var state tls.ConnectionState
+	config := r.tlsConfig(domain)
+	// A full handshake is needed to receive the chain at all
+	config.SessionTicketsDisabled = true
+	config.ClientSessionCache = nil
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		state = cs
+		return errLeafReceived
+	}
+
+	err := tls.Client(conn, config).HandshakeContext(handshakeCtx)
+	if !errors.Is(err, errLeafReceived) {
+		if err == nil {
+			err = errors.New("handshake completed without presenting certificates")
+		}
+		return nil, nil, fmt.Errorf("TLS handshake with %s failed: %w", domain, err)
+	}
+	if len(state.PeerCertificates) == 0 {
+		return nil, nil, fmt.Errorf("%w for domain: %s", ErrNoCertificates, domain)
+	}
+
+	return state.PeerCertificates, &ConnectionInfo{
+		Version:      state.Version,
+		CipherSuite:  state.CipherSuite,
+		OCSPResponse: state.OCSPResponse,
+	}, nil
+}
@@ -0,0 +1,132 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flightCountingConn counts the writes carrying TLS handshake records, i.e.
+// the handshake flights the client sends
+type flightCountingConn struct {
+	net.Conn
+	flights *atomic.Int64
+}
+
+func (c *flightCountingConn) Write(b []byte) (int, error) {
+	// 0x16 is the handshake record content type
+	if len(b) > 0 && b[0] == 0x16 {
+		c.flights.Add(1)
+	}
+	return c.Conn.Write(b)
+}
+
+// countFlights makes r count the handshake flights it sends in flights
+func countFlights(r *Retriever, flights *atomic.Int64) {
+	dial := r.dialContext
+	r.dialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &flightCountingConn{Conn: conn, flights: flights}, nil
+	}
+}
+
+func TestGetCertificates_FastLeafSavesRoundTrip(t *testing.T) {
+	server := NewTLS12MockTLSServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name            string
+		fastLeaf        bool
+		expectedFlights int64
+	}{
+		// ClientHello, then ClientKeyExchange/ChangeCipherSpec/Finished
+		{"full", false, 2},
+		// ClientHello only: the chain arrives in the server's first flight
+		{"fast_leaf", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flights atomic.Int64
+			r := newTestRetriever(server, 0)
+			r.SetFastLeaf(tt.fastLeaf)
+			countFlights(r, &flights)
+
+			certs, info, err := r.GetCertificatesWithInfo(server.Host(), FetchOptions{Port: server.Port()})
+			if err != nil {
+				t.Fatalf("Failed to retrieve certificates: %v", err)
+			}
+			if len(certs) == 0 || !certs[0].Equal(server.Certificate()) {
+				t.Fatal("Retrieved certificate does not match the server certificate")
+			}
+			if info == nil || info.Version != tls.VersionTLS12 {
+				t.Errorf("Expected TLS 1.2 connection info, got %+v", info)
+			}
+			if got := flights.Load(); got != tt.expectedFlights {
+				t.Errorf("Expected %d client handshake flights, got %d", tt.expectedFlights, got)
+			}
+		})
+	}
+}
+
+func TestGetCertificates_FastLeafTLS13(t *testing.T) {
+	server := NewMockTLSServer(t)
+	defer server.Close()
+
+	r := newTestRetriever(server, 0)
+	r.SetFastLeaf(true)
+
+	certs, info, err := r.GetCertificatesWithInfo(server.Host(), FetchOptions{Port: server.Port()})
+	if err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+	if len(certs) == 0 || !certs[0].Equal(server.Certificate()) {
+		t.Fatal("Retrieved certificate does not match the server certificate")
+	}
+	if info == nil || info.Version != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 connection info, got %+v", info)
+	}
+}
+
+func TestGetCertificates_FastLeafStillVerifiesChain(t *testing.T) {
+	server := NewMockTLSServer(t)
+	defer server.Close()
+
+	// The mock's self-signed certificate is not in the system roots
+	r := NewRetriever(5*time.Second, 0)
+	r.SetFastLeaf(true)
+
+	if _, err := r.GetCertificatesWithOptions(server.Host(), FetchOptions{Port: server.Port()}); err == nil {
+		t.Error("Expected an untrusted chain to fail even with the fast path")
+	}
+}
+
+// BenchmarkFetchCertificates_FastLeaf compares full and aborted handshakes
+// against a local TLS 1.2 mock server
+func BenchmarkFetchCertificates_FastLeaf(b *testing.B) {
+	server := NewTLS12MockTLSServer(b)
+	defer server.Close()
+
+	for _, fastLeaf := range []bool{false, true} {
+		name := "full"
+		if fastLeaf {
+			name = "fast_leaf"
+		}
+		b.Run(name, func(b *testing.B) {
+			r := newTestRetriever(server, 0)
+			r.SetFastLeaf(fastLeaf)
+			opts := FetchOptions{Port: server.Port()}
+			for i := 0; i < b.N; i++ {
+				if _, err := r.GetCertificatesWithOptions(server.Host(), opts); err != nil {
+					b.Fatalf("Failed to retrieve certificates: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -1,21 +1,27 @@
 package cert
 
 import (
+	"bufio"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"fmt"
 	"math/big"
 	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // MockTLSServer creates a local TLS server for testing
 type MockTLSServer struct {
-	listener net.Listener
-	cert     *x509.Certificate
-	address  string
+	listener    net.Listener
+	cert        *x509.Certificate
+	address     string
+	connections atomic.Int64
 }
 
 // TestingTB is a subset of testing.TB interface
@@ -28,6 +34,158 @@ type TestingTB interface {
 // NewMockTLSServer creates a new mock TLS server
 func NewMockTLSServer(t TestingTB) *MockTLSServer {
 	t.Helper()
+	return NewSlowMockTLSServer(t, 0)
+}
+
+// NewSlowMockTLSServer creates a mock TLS server that waits for delay
+// before completing each handshake
+func NewSlowMockTLSServer(t TestingTB, delay time.Duration) *MockTLSServer {
+	t.Helper()
+	return newMockTLSServer(t, mockServerOptions{delay: delay})
+}
+
+// NewStaplingMockTLSServer creates a mock TLS server that staples the given
+// OCSP response to every handshake
+func NewStaplingMockTLSServer(t TestingTB, staple []byte) *MockTLSServer {
+	t.Helper()
+	return newMockTLSServer(t, mockServerOptions{staple: staple})
+}
+
+// NewTLS12MockTLSServer creates a mock TLS server that negotiates at most TLS 1.2
+func NewTLS12MockTLSServer(t TestingTB) *MockTLSServer {
+	t.Helper()
+	return newMockTLSServer(t, mockServerOptions{maxVersion: tls.VersionTLS12})
+}
+
+// mockServerOptions tweak the behavior of a mock TLS server
+type mockServerOptions struct {
+	delay      time.Duration // Wait before each handshake
+	staple     []byte        // OCSP response stapled to every handshake
+	maxVersion uint16        // Highest TLS version negotiated (0 for the default)
+}
+
+// newMockTLSServer starts a mock TLS server that closes each connection after
+// the handshake
+func newMockTLSServer(t TestingTB, opts mockServerOptions) *MockTLSServer {
+	t.Helper()
+
+	tlsCert, cert := generateMockCertificate(t)
+	tlsCert.OCSPStaple = opts.staple
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   opts.maxVersion,
+	}
+
+	// Start listener
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &MockTLSServer{
+		listener: listener,
+		cert:     cert,
+		address:  listener.Addr().String(),
+	}
+
+	// Start accepting connections, completing the handshake before closing
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.connections.Add(1)
+			go func() {
+				defer conn.Close()
+				time.Sleep(opts.delay)
+				_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+				_ = conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	return server
+}
+
+// NewMockSTARTTLSServer creates a mock server that speaks the plaintext part of
+// the given STARTTLS protocol (smtp or imap) and then upgrades to TLS
+func NewMockSTARTTLSServer(t TestingTB, protocol string) *MockTLSServer {
+	t.Helper()
+
+	tlsCert, cert := generateMockCertificate(t)
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &MockTLSServer{
+		listener: listener,
+		cert:     cert,
+		address:  listener.Addr().String(),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSTARTTLS(conn, protocol, tlsConfig)
+		}
+	}()
+
+	return server
+}
+
+// serveSTARTTLS runs the server side of a STARTTLS exchange on a single connection
+func serveSTARTTLS(conn net.Conn, protocol string, tlsConfig *tls.Config) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	switch protocol {
+	case STARTTLSSMTP:
+		fmt.Fprint(conn, "220 mock.local ESMTP ready\r\n")
+		if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "EHLO") {
+			return
+		}
+		fmt.Fprint(conn, "250-mock.local\r\n250-PIPELINING\r\n250 STARTTLS\r\n")
+		if line, err := reader.ReadString('\n'); err != nil || strings.TrimSpace(line) != "STARTTLS" {
+			return
+		}
+		fmt.Fprint(conn, "220 Ready to start TLS\r\n")
+	case STARTTLSIMAP:
+		fmt.Fprint(conn, "* OK IMAP4rev1 mock ready\r\n")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != "STARTTLS" {
+			return
+		}
+		fmt.Fprintf(conn, "%s OK Begin TLS negotiation now\r\n", fields[0])
+	default:
+		return
+	}
+
+	tlsConn := tls.Server(conn, tlsConfig)
+	_ = tlsConn.Handshake()
+	tlsConn.Close()
+}
+
+// generateMockCertificate creates a self-signed certificate valid for localhost/127.0.0.1
+func generateMockCertificate(t TestingTB) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
 
 	// Generate RSA key
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -44,9 +202,10 @@ func NewMockTLSServer(t TestingTB) *MockTLSServer {
 		},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().Add(24 * time.Hour),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
+		IsCA:                  true,
 		DNSNames:              []string{"localhost", "127.0.0.1"},
 		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
 	}
@@ -62,41 +221,31 @@ func NewMockTLSServer(t TestingTB) *MockTLSServer {
 		t.Fatal(err)
 	}
 
-	// Create TLS config
 	tlsCert := tls.Certificate{
 		Certificate: [][]byte{certBytes},
 		PrivateKey:  privateKey,
 	}
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{tlsCert},
-		MinVersion:   tls.VersionTLS12,
-	}
-
-	// Start listener
-	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
-	if err != nil {
-		t.Fatal(err)
-	}
+	return tlsCert, cert
+}
 
-	server := &MockTLSServer{
-		listener: listener,
-		cert:     cert,
-		address:  listener.Addr().String(),
-	}
+// Connections returns the number of connections accepted so far
+func (m *MockTLSServer) Connections() int64 {
+	return m.connections.Load()
+}
 
-	// Start accepting connections
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				return
-			}
-			conn.Close()
-		}
-	}()
+// Port returns the port the server listens on
+func (m *MockTLSServer) Port() int {
+	_, portStr, _ := net.SplitHostPort(m.address)
+	port, _ := strconv.Atoi(portStr)
+	return port
+}
 
-	return server
+// RootCAs returns a pool trusting the server's self-signed certificate
+func (m *MockTLSServer) RootCAs() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(m.cert)
+	return pool
 }
 
 // Close shuts down the mock server
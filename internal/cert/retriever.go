@@ -1,11 +1,16 @@
 package cert
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,92 +18,436 @@ import (
 // This allows for easy testing with fake implementations
 type CertRetriever interface {
 	GetCertificates(domain string) ([]*x509.Certificate, error)
+	GetCertificatesWithOptions(domain string, opts FetchOptions) ([]*x509.Certificate, error)
 }
 
-// cacheEntry holds cached certificates with expiry
-type cacheEntry struct {
-	certs     []*x509.Certificate
-	expiresAt time.Time
+// ErrNoCertificates is returned when the connection succeeds but no certificate chain is presented
+var ErrNoCertificates = errors.New("no certificates found")
+
+// FetchOptions controls how certificates are retrieved for a domain
+type FetchOptions struct {
+	// Port to connect to (0 means the default port for the protocol)
+	Port int
+	// STARTTLS protocol to negotiate before the TLS handshake (empty for plain TLS)
+	STARTTLS string
+}
+
+// inflightFetch is a certificate fetch shared by concurrent callers
+type inflightFetch struct {
+	done  chan struct{}
+	certs []*x509.Certificate
+	info  *ConnectionInfo
+	err   error
+}
+
+// ConnectionInfo describes the TLS connection a chain was retrieved over
+type ConnectionInfo struct {
+	Version     uint16 // tls.VersionTLS12, tls.VersionTLS13, ...
+	CipherSuite uint16 // tls.TLS_AES_128_GCM_SHA256, ...
+	// OCSPResponse is the OCSP response stapled by the server (nil if none)
+	OCSPResponse []byte
+}
+
+// VersionName returns the name of the negotiated TLS version, e.g. "TLS 1.3"
+func (c *ConnectionInfo) VersionName() string {
+	return tls.VersionName(c.Version)
+}
+
+// CipherSuiteName returns the name of the negotiated cipher suite
+func (c *ConnectionInfo) CipherSuiteName() string {
+	return tls.CipherSuiteName(c.CipherSuite)
 }
 
 // Retriever retrieves TLS certificates for domains
 type Retriever struct {
 	dialTimeout time.Duration
 	cacheTTL    time.Duration
-	cache       map[string]*cacheEntry
+	cache       CertCache
 	mu          sync.RWMutex
+
+	// timeouts split the retrieval budget into resolve, connect and handshake phases
+	timeouts Timeouts
+
+	// resolver and dialContext perform DNS resolution and TCP connects
+	resolver    hostResolver
+	dialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// rootCAs overrides the system roots used to verify upstream chains (nil uses system roots)
+	rootCAs *x509.CertPool
+
+	// inflight coalesces concurrent fetches for the same cache key
+	inflight   map[string]*inflightFetch
+	inflightMu sync.Mutex
+
+	// ctx bounds every upstream fetch and is cancelled by Shutdown (via abort);
+	// fetches tracks the fetches in flight and closing, guarded by inflightMu,
+	// refuses new ones once Shutdown started
+	ctx     context.Context
+	abort   context.CancelFunc
+	fetches sync.WaitGroup
+	closing bool
+
+	// fallbackPorts are tried in order for plain TLS requests without a port
+	fallbackPorts []int
+
+	// cacheHits and cacheMisses count cache lookups (only made when the TTL is > 0)
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+
+	// sessionCache lets upstream handshakes resume sessions (nil forces full handshakes)
+	sessionCache tls.ClientSessionCache
+
+	// fastLeaf aborts upstream handshakes once the chain is verified (see SetFastLeaf)
+	fastLeaf bool
+
+	// domainTTLs overrides cacheTTL for individual lowercased domains (e.g. CDN-fronted
+	// domains rotating certificates often)
+	domainTTLs map[string]time.Duration
+
+	// sanDomains holds the exact whitelist entries that may be cached from another
+	// domain's leaf SANs (nil disables SAN-aware caching)
+	sanDomains map[string]bool
 }
 
 // NewRetriever creates a new certificate retriever
 func NewRetriever(dialTimeout time.Duration, cacheTTL time.Duration) *Retriever {
+	ctx, abort := context.WithCancel(context.Background())
 	return &Retriever{
+		ctx:         ctx,
+		abort:       abort,
 		dialTimeout: dialTimeout,
 		cacheTTL:    cacheTTL,
-		cache:       make(map[string]*cacheEntry),
+		cache:       NewMemoryCache(),
+		inflight:    make(map[string]*inflightFetch),
+		resolver:    net.DefaultResolver,
+		dialContext: (&net.Dialer{}).DialContext,
+	}
+}
+
+// EnableSANCache makes the retriever also cache a fetched chain under the leaf's
+// other SANs, for those that are exact (non-wildcard) entries of allowedDomains
+// Has no effect unless the cache TTL is > 0
+func (r *Retriever) EnableSANCache(allowedDomains []string) {
+	sanDomains := make(map[string]bool, len(allowedDomains))
+	for _, d := range allowedDomains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" && !strings.Contains(d, "*") {
+			sanDomains[d] = true
+		}
+	}
+
+	r.mu.Lock()
+	r.sanDomains = sanDomains
+	r.mu.Unlock()
+}
+
+// SetDomainCacheTTLs overrides the cache TTL for individual domains, keyed by
+// exact domain name (0 disables caching for that domain)
+func (r *Retriever) SetDomainCacheTTLs(ttls map[string]time.Duration) {
+	domainTTLs := make(map[string]time.Duration, len(ttls))
+	for d, ttl := range ttls {
+		domainTTLs[strings.ToLower(d)] = ttl
+	}
+
+	r.mu.Lock()
+	r.domainTTLs = domainTTLs
+	r.mu.Unlock()
+}
+
+// cacheTTLFor returns the cache TTL of domain, honoring per-domain overrides
+func (r *Retriever) cacheTTLFor(domain string) time.Duration {
+	r.mu.RLock()
+	ttl, found := r.domainTTLs[strings.ToLower(domain)]
+	r.mu.RUnlock()
+	if found {
+		return ttl
+	}
+	return r.cacheTTL
+}
+
+// SetCache replaces the in-memory certificate cache, e.g. with a store shared
+// between instances (has no effect on caching unless the cache TTL is > 0)
+func (r *Retriever) SetCache(cache CertCache) {
+	r.cache = cache
+}
+
+// CacheEntries returns the number of certificate cache entries, if the cache
+// can report it (the in-memory default can; external stores may not)
+func (r *Retriever) CacheEntries() (int, bool) {
+	if sized, ok := r.cache.(interface{ Len() int }); ok {
+		return sized.Len(), true
+	}
+	return 0, false
+}
+
+// fileCache is implemented by caches that can be persisted to a file (MemoryCache)
+type fileCache interface {
+	SaveFile(path string) error
+	LoadFile(path string) (int, error)
+}
+
+// SaveCacheFile writes the certificate cache to path (CERT_CACHE_FILE)
+func (r *Retriever) SaveCacheFile(path string) error {
+	cache, ok := r.cache.(fileCache)
+	if !ok {
+		return errors.New("certificate cache cannot be saved to a file")
+	}
+	return cache.SaveFile(path)
+}
+
+// LoadCacheFile loads the unexpired entries saved by SaveCacheFile into the
+// certificate cache and returns how many were loaded
+func (r *Retriever) LoadCacheFile(path string) (int, error) {
+	cache, ok := r.cache.(fileCache)
+	if !ok {
+		return 0, errors.New("certificate cache cannot be loaded from a file")
+	}
+	return cache.LoadFile(path)
+}
+
+// infoCache is implemented by caches that keep the connection each chain was
+// fetched over with the entry (MemoryCache), so cache hits can still report it
+type infoCache interface {
+	GetWithInfo(key string) ([]*x509.Certificate, *ConnectionInfo, bool)
+	SetWithInfo(key string, certs []*x509.Certificate, info *ConnectionInfo, ttl time.Duration)
+}
+
+// cacheGet looks up key, with its connection info if the cache keeps it
+func (r *Retriever) cacheGet(key string) ([]*x509.Certificate, *ConnectionInfo, bool) {
+	if cache, ok := r.cache.(infoCache); ok {
+		return cache.GetWithInfo(key)
+	}
+	certs, found := r.cache.Get(key)
+	return certs, nil, found
+}
+
+// cacheSet caches certs under key, with their connection info if the cache keeps it
+func (r *Retriever) cacheSet(key string, certs []*x509.Certificate, info *ConnectionInfo, ttl time.Duration) {
+	if cache, ok := r.cache.(infoCache); ok {
+		cache.SetWithInfo(key, certs, info, ttl)
+		return
+	}
+	r.cache.Set(key, certs, ttl)
+}
+
+// CacheStats returns the certificate cache hits and misses since start
+// Lookups are only made, and counted, when the cache TTL is > 0
+func (r *Retriever) CacheStats() (hits, misses uint64) {
+	return r.cacheHits.Load(), r.cacheMisses.Load()
+}
+
+// SetFallbackPorts sets the ordered list of ports tried for plain TLS requests
+// that do not specify a port (empty restores the default port 443)
+func (r *Retriever) SetFallbackPorts(ports []int) {
+	r.fallbackPorts = ports
+}
+
+// SetSessionResumption lets upstream handshakes resume TLS sessions. It is off
+// by default: full handshakes always present the complete chain, whereas a
+// resumed session may not.
+func (r *Retriever) SetSessionResumption(enabled bool) {
+	if enabled {
+		r.sessionCache = tls.NewLRUClientSessionCache(0)
+	} else {
+		r.sessionCache = nil
 	}
 }
 
 // GetCertificates retrieves the certificate chain for a domain
 // Uses cache if TTL > 0 and entry is still valid
 func (r *Retriever) GetCertificates(domain string) ([]*x509.Certificate, error) {
+	return r.GetCertificatesWithOptions(domain, FetchOptions{})
+}
+
+// GetCertificatesWithOptions retrieves the certificate chain for a domain using
+// a custom port and/or STARTTLS negotiation
+// Uses cache if TTL > 0 and entry is still valid
+func (r *Retriever) GetCertificatesWithOptions(domain string, opts FetchOptions) ([]*x509.Certificate, error) {
+	certs, _, err := r.GetCertificatesWithInfo(domain, opts)
+	return certs, err
+}
+
+// GetCertificatesWithInfo is GetCertificatesWithOptions that also reports the
+// TLS connection the chain was retrieved over (nil if unknown, e.g. for a
+// chain shared from another domain's SANs or an external cache)
+func (r *Retriever) GetCertificatesWithInfo(domain string, opts FetchOptions) ([]*x509.Certificate, *ConnectionInfo, error) {
+	ports := r.candidatePorts(opts)
+	key := cacheKey(domain, ports[0], opts.STARTTLS)
+	cacheTTL := r.cacheTTLFor(domain)
+
 	// Check cache if TTL is enabled (> 0)
-	if r.cacheTTL > 0 {
-		r.mu.RLock()
-		entry, found := r.cache[domain]
-		r.mu.RUnlock()
-
-		if found && time.Now().Before(entry.expiresAt) {
-			// Cache hit - return cached certificates
-			return entry.certs, nil
+	if cacheTTL > 0 {
+		for _, port := range ports {
+			portKey := cacheKey(domain, port, opts.STARTTLS)
+			if certs, info, found := r.cacheGet(portKey); found {
+				// Cache hit - return cached certificates
+				r.cacheHits.Add(1)
+				return certs, info, nil
+			}
 		}
+		r.cacheMisses.Add(1)
 	}
 
-	// Cache miss or expired - retrieve certificates
-	certs, err := r.fetchCertificates(domain)
-	if err != nil {
-		return nil, err
-	}
+	// Cache miss or expired - retrieve certificates, sharing the fetch with
+	// concurrent callers for the same key
+	return r.fetchCoalesced(key, func() ([]*x509.Certificate, *ConnectionInfo, error) {
+		fetchStart := time.Now()
+
+		// Try each candidate port in order and keep the first that succeeds
+		var certs []*x509.Certificate
+		var info *ConnectionInfo
+		var port int
+		var err error
+		for _, port = range ports {
+			if opts.STARTTLS != "" {
+				certs, info, err = r.fetchCertificatesSTARTTLS(domain, port, opts.STARTTLS)
+			} else {
+				certs, info, err = r.fetchCertificates(domain, port)
+			}
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, nil, r.shutdownError(err)
+		}
+		if len(certs) == 0 {
+			// Never cache an empty chain
+			return nil, nil, fmt.Errorf("%w for domain: %s", ErrNoCertificates, domain)
+		}
 
-	// Store in cache if TTL is enabled
-	if r.cacheTTL > 0 {
-		r.mu.Lock()
-		r.cache[domain] = &cacheEntry{
-			certs:     certs,
-			expiresAt: time.Now().Add(r.cacheTTL),
+		// Store in cache if TTL is enabled, keyed by the port that worked
+		if cacheTTL > 0 {
+			// Date the entry from the start of the fetch, so it never replaces
+			// one from a fetch that started later (e.g. via a SAN's own fetch)
+			elapsed := time.Since(fetchStart)
+			portKey := cacheKey(domain, port, opts.STARTTLS)
+			r.cacheSet(portKey, certs, info, cacheTTL-elapsed)
+
+			r.mu.RLock()
+			sanDomains := r.sanDomains
+			r.mu.RUnlock()
+
+			// Share the entry with whitelisted SANs served by the same certificate
+			for _, san := range certs[0].DNSNames {
+				san = strings.ToLower(san)
+				if sanDomains[san] && san != strings.ToLower(domain) {
+					if sanTTL := r.cacheTTLFor(san); sanTTL > 0 {
+						r.cache.Set(cacheKey(san, port, opts.STARTTLS), certs, sanTTL-elapsed)
+					}
+				}
+			}
 		}
-		r.mu.Unlock()
+
+		return certs, info, nil
+	})
+}
+
+// candidatePorts returns the ports to try, in order, for the given options
+// Plain TLS requests without an explicit port use the fallback ports if configured
+func (r *Retriever) candidatePorts(opts FetchOptions) []int {
+	if opts.Port != 0 {
+		return []int{opts.Port}
+	}
+	if opts.STARTTLS == "" && len(r.fallbackPorts) > 0 {
+		return r.fallbackPorts
+	}
+	return []int{DefaultPort(opts.STARTTLS)}
+}
+
+// fetchCoalesced runs fetch for key unless a fetch for the same key is already
+// in flight, in which case it waits for that fetch and shares its result
+// This applies regardless of the cache TTL. Once Shutdown started, new fetches
+// fail with ErrShuttingDown.
+func (r *Retriever) fetchCoalesced(key string, fetch func() ([]*x509.Certificate, *ConnectionInfo, error)) ([]*x509.Certificate, *ConnectionInfo, error) {
+	r.inflightMu.Lock()
+	if call, ok := r.inflight[key]; ok {
+		r.inflightMu.Unlock()
+		<-call.done
+		return call.certs, call.info, call.err
+	}
+	if r.closing {
+		r.inflightMu.Unlock()
+		return nil, nil, fmt.Errorf("%w: not fetching %s", ErrShuttingDown, key)
+	}
+	call := &inflightFetch{done: make(chan struct{})}
+	r.inflight[key] = call
+	r.fetches.Add(1)
+	r.inflightMu.Unlock()
+
+	call.certs, call.info, call.err = fetch()
+
+	r.inflightMu.Lock()
+	delete(r.inflight, key)
+	r.inflightMu.Unlock()
+	close(call.done)
+	r.fetches.Done()
+
+	return call.certs, call.info, call.err
+}
+
+// cacheKey builds the cache key for a domain/port/protocol combination
+// Plain TLS on the default port keeps using the bare domain as key
+func cacheKey(domain string, port int, starttls string) string {
+	if starttls == "" && port == DefaultTLSPort {
+		return domain
+	}
+	key := net.JoinHostPort(domain, strconv.Itoa(port))
+	if starttls != "" {
+		key += "/" + starttls
 	}
+	return key
+}
 
-	return certs, nil
+// tlsConfig returns the TLS client configuration used for upstream handshakes
+func (r *Retriever) tlsConfig(domain string) *tls.Config {
+	return &tls.Config{
+		ServerName:         domain,
+		InsecureSkipVerify: false, // We want to verify the cert chain
+		MinVersion:         tls.VersionTLS12,
+		RootCAs:            r.rootCAs,
+		// Perform a full handshake unless resumption was enabled: a resumed
+		// session may carry no peer certificates
+		SessionTicketsDisabled: r.sessionCache == nil,
+		ClientSessionCache:     r.sessionCache,
+	}
 }
 
 // fetchCertificates retrieves certificates from the domain via TLS connection
-func (r *Retriever) fetchCertificates(domain string) ([]*x509.Certificate, error) {
-	// Connect to the domain over TLS
-	dialer := &net.Dialer{
-		Timeout: r.dialTimeout,
-	}
-
-	conn, err := tls.DialWithDialer(
-		dialer,
-		"tcp",
-		domain+":443",
-		&tls.Config{
-			ServerName:         domain,
-			InsecureSkipVerify: false, // We want to verify the cert chain
-			MinVersion:         tls.VersionTLS12,
-		},
-	)
+// The dial timeout bounds the whole retrieval; each phase has its own budget
+func (r *Retriever) fetchCertificates(domain string, port int) ([]*x509.Certificate, *ConnectionInfo, error) {
+	ctx, cancel := withTimeout(r.ctx, r.dialTimeout)
+	defer cancel()
+
+	conn, err := r.dial(ctx, domain, port)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %w", domain, err)
+		return nil, nil, err
 	}
 	defer conn.Close()
 
-	// Get the peer certificates
-	certs := conn.ConnectionState().PeerCertificates
-	if len(certs) == 0 {
-		return nil, fmt.Errorf("no certificates found for domain: %s", domain)
+	if r.fastLeaf {
+		return r.fastLeafHandshake(ctx, conn, domain)
+	}
+
+	tlsConn, err := r.handshake(ctx, conn, domain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return peerCertificates(tlsConn, domain)
+}
+
+// peerCertificates returns the chain presented on an established connection
+// along with the negotiated protocol version, cipher suite and OCSP staple
+func peerCertificates(tlsConn *tls.Conn, domain string) ([]*x509.Certificate, *ConnectionInfo, error) {
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, nil, fmt.Errorf("%w for domain: %s", ErrNoCertificates, domain)
 	}
 
-	return certs, nil
+	return state.PeerCertificates, &ConnectionInfo{
+		Version:      state.Version,
+		CipherSuite:  state.CipherSuite,
+		OCSPResponse: state.OCSPResponse,
+	}, nil
 }
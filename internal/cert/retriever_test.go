@@ -0,0 +1,331 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestRetriever creates a retriever that trusts the given mock server
+func newTestRetriever(server *MockTLSServer, cacheTTL time.Duration) *Retriever {
+	r := NewRetriever(5*time.Second, cacheTTL)
+	r.rootCAs = server.RootCAs()
+	return r
+}
+
+func TestGetCertificatesWithOptions_STARTTLS(t *testing.T) {
+	for _, protocol := range []string{STARTTLSSMTP, STARTTLSIMAP} {
+		t.Run(protocol, func(t *testing.T) {
+			server := NewMockSTARTTLSServer(t, protocol)
+			defer server.Close()
+
+			r := newTestRetriever(server, 0)
+			certs, err := r.GetCertificatesWithOptions(server.Host(), FetchOptions{
+				Port:     server.Port(),
+				STARTTLS: protocol,
+			})
+			if err != nil {
+				t.Fatalf("Failed to retrieve certificates: %v", err)
+			}
+
+			if len(certs) == 0 {
+				t.Fatal("Expected at least one certificate")
+			}
+			if !certs[0].Equal(server.Certificate()) {
+				t.Error("Retrieved certificate does not match the server certificate")
+			}
+		})
+	}
+}
+
+func TestGetCertificatesWithOptions_STARTTLSWrongProtocol(t *testing.T) {
+	// An IMAP server does not answer like an SMTP server
+	server := NewMockSTARTTLSServer(t, STARTTLSIMAP)
+	defer server.Close()
+
+	r := newTestRetriever(server, 0)
+	_, err := r.GetCertificatesWithOptions(server.Host(), FetchOptions{
+		Port:     server.Port(),
+		STARTTLS: STARTTLSSMTP,
+	})
+	if err == nil {
+		t.Error("Expected error when negotiating SMTP with an IMAP server")
+	}
+}
+
+func TestGetCertificatesWithOptions_STARTTLSCached(t *testing.T) {
+	server := NewMockSTARTTLSServer(t, STARTTLSSMTP)
+
+	r := newTestRetriever(server, time.Minute)
+	opts := FetchOptions{Port: server.Port(), STARTTLS: STARTTLSSMTP}
+	if _, err := r.GetCertificatesWithOptions(server.Host(), opts); err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+
+	// Second call must be served from cache even though the server is gone
+	server.Close()
+	if _, err := r.GetCertificatesWithOptions(server.Host(), opts); err != nil {
+		t.Errorf("Expected cached certificates, got error: %v", err)
+	}
+}
+
+func TestDefaultPort(t *testing.T) {
+	tests := []struct {
+		protocol string
+		expected int
+	}{
+		{"", DefaultTLSPort},
+		{STARTTLSSMTP, DefaultSMTPPort},
+		{STARTTLSIMAP, DefaultIMAPPort},
+	}
+
+	for _, tt := range tests {
+		if got := DefaultPort(tt.protocol); got != tt.expected {
+			t.Errorf("DefaultPort(%q) = %d, expected %d", tt.protocol, got, tt.expected)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	if key := cacheKey("example.com", DefaultTLSPort, ""); key != "example.com" {
+		t.Errorf("Expected bare domain key for default TLS port, got %q", key)
+	}
+	if key := cacheKey("example.com", 587, STARTTLSSMTP); key != "example.com:587/smtp" {
+		t.Errorf("Unexpected key for STARTTLS: %q", key)
+	}
+}
+
+func TestGetCertificates_SANCache(t *testing.T) {
+	// The mock certificate carries the SANs localhost and 127.0.0.1
+	server := NewMockTLSServer(t)
+
+	r := newTestRetriever(server, time.Minute)
+	r.EnableSANCache([]string{"127.0.0.1", "localhost"})
+
+	opts := FetchOptions{Port: server.Port()}
+	if _, err := r.GetCertificatesWithOptions(server.Host(), opts); err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+
+	// The second domain must be a cache hit even though the server is gone
+	server.Close()
+	certs, err := r.GetCertificatesWithOptions("localhost", opts)
+	if err != nil {
+		t.Fatalf("Expected cache hit for SAN, got error: %v", err)
+	}
+	if !certs[0].Equal(server.Certificate()) {
+		t.Error("Cached certificate does not match the server certificate")
+	}
+}
+
+func TestGetCertificates_SANCacheOnlyExactEntries(t *testing.T) {
+	server := NewMockTLSServer(t)
+
+	r := newTestRetriever(server, time.Minute)
+	r.EnableSANCache([]string{"127.0.0.1", "*.localhost"})
+
+	opts := FetchOptions{Port: server.Port()}
+	if _, err := r.GetCertificatesWithOptions(server.Host(), opts); err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+
+	server.Close()
+	if _, err := r.GetCertificatesWithOptions("localhost", opts); err == nil {
+		t.Error("Expected cache miss for SAN that is not an exact whitelist entry")
+	}
+}
+
+func TestGetCertificates_CoalescesConcurrentFetches(t *testing.T) {
+	// Slow handshakes keep the first fetch in flight while the others arrive
+	server := NewSlowMockTLSServer(t, 200*time.Millisecond)
+	defer server.Close()
+
+	// Caching disabled: coalescing must still apply
+	r := newTestRetriever(server, 0)
+	opts := FetchOptions{Port: server.Port()}
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.GetCertificatesWithOptions(server.Host(), opts); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Failed to retrieve certificates: %v", err)
+	}
+	if got := server.Connections(); got != 1 {
+		t.Errorf("Expected exactly 1 upstream fetch, got %d", got)
+	}
+}
+
+// unusedPort returns a local port with nothing listening on it
+func unusedPort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestGetCertificates_FallbackPorts(t *testing.T) {
+	server := NewMockTLSServer(t)
+
+	r := newTestRetriever(server, time.Minute)
+	r.SetFallbackPorts([]int{unusedPort(t), server.Port(), unusedPort(t)})
+
+	certs, err := r.GetCertificates(server.Host())
+	if err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+	if !certs[0].Equal(server.Certificate()) {
+		t.Error("Retrieved certificate does not match the server certificate")
+	}
+
+	// Cached by the port that worked
+	server.Close()
+	if _, err := r.GetCertificates(server.Host()); err != nil {
+		t.Errorf("Expected cached certificates, got error: %v", err)
+	}
+	if _, err := r.GetCertificatesWithOptions(server.Host(), FetchOptions{Port: server.Port()}); err != nil {
+		t.Errorf("Expected cache entry for the working port, got error: %v", err)
+	}
+}
+
+func TestGetCertificates_FallbackPortsAllUnreachable(t *testing.T) {
+	r := NewRetriever(time.Second, 0)
+	r.SetFallbackPorts([]int{unusedPort(t), unusedPort(t)})
+
+	if _, err := r.GetCertificates("127.0.0.1"); err == nil {
+		t.Error("Expected error when no fallback port is reachable")
+	}
+}
+
+func TestGetCertificates_FallbackPortsIgnoredWithExplicitPort(t *testing.T) {
+	server := NewMockTLSServer(t)
+	defer server.Close()
+
+	r := newTestRetriever(server, 0)
+	r.SetFallbackPorts([]int{server.Port()})
+
+	if _, err := r.GetCertificatesWithOptions(server.Host(), FetchOptions{Port: unusedPort(t)}); err == nil {
+		t.Error("Expected explicit port to bypass the fallback ports")
+	}
+}
+
+func TestTLSConfig_DisablesSessionResumption(t *testing.T) {
+	r := NewRetriever(time.Second, 0)
+	if cfg := r.tlsConfig("example.com"); !cfg.SessionTicketsDisabled || cfg.ClientSessionCache != nil {
+		t.Error("Expected session resumption to be disabled so every handshake presents certificates")
+	}
+
+	r.SetSessionResumption(true)
+	if cfg := r.tlsConfig("example.com"); cfg.SessionTicketsDisabled || cfg.ClientSessionCache == nil {
+		t.Error("Expected session resumption to be enabled")
+	}
+
+	r.SetSessionResumption(false)
+	if cfg := r.tlsConfig("example.com"); !cfg.SessionTicketsDisabled || cfg.ClientSessionCache != nil {
+		t.Error("Expected session resumption to be disabled again")
+	}
+}
+
+func TestGetCertificates_FullChainWithoutResumption(t *testing.T) {
+	server := NewMockTLSServer(t)
+	defer server.Close()
+
+	// No certificate cache, so each call performs its own handshake
+	r := newTestRetriever(server, 0)
+	for i := range 2 {
+		certs, err := r.GetCertificatesWithOptions(server.Host(), FetchOptions{Port: server.Port()})
+		if err != nil {
+			t.Fatalf("Fetch %d: failed to retrieve certificates: %v", i+1, err)
+		}
+		if len(certs) == 0 || !certs[0].Equal(server.Certificate()) {
+			t.Fatalf("Fetch %d: expected the server's chain, got %d certificates", i+1, len(certs))
+		}
+	}
+
+	if got := server.Connections(); got != 2 {
+		t.Errorf("Expected 2 full handshakes, got %d connections", got)
+	}
+}
+
+func TestGetCertificatesWithInfo(t *testing.T) {
+	server := NewMockTLSServer(t)
+
+	r := newTestRetriever(server, time.Minute)
+	opts := FetchOptions{Port: server.Port()}
+	_, info, err := r.GetCertificatesWithInfo(server.Host(), opts)
+	if err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+	if info == nil {
+		t.Fatal("Expected connection info")
+	}
+
+	if info.Version < tls.VersionTLS12 {
+		t.Errorf("Expected TLS 1.2 or later, got %s", info.VersionName())
+	}
+	known := false
+	for _, suite := range tls.CipherSuites() {
+		if suite.ID == info.CipherSuite {
+			known = true
+		}
+	}
+	if !known {
+		t.Errorf("Expected a known secure cipher suite, got %s", info.CipherSuiteName())
+	}
+
+	// Cache hits report the connection the chain was fetched over
+	server.Close()
+	_, cachedInfo, err := r.GetCertificatesWithInfo(server.Host(), opts)
+	if err != nil {
+		t.Fatalf("Expected cached certificates, got error: %v", err)
+	}
+	if cachedInfo == nil || cachedInfo.Version != info.Version || cachedInfo.CipherSuite != info.CipherSuite {
+		t.Errorf("Expected cached connection info %+v, got %+v", info, cachedInfo)
+	}
+}
+
+func TestGetCertificatesWithInfo_OCSPStaple(t *testing.T) {
+	staple := []byte("mock ocsp response")
+	stapling := NewStaplingMockTLSServer(t, staple)
+	defer stapling.Close()
+
+	r := newTestRetriever(stapling, 0)
+	_, info, err := r.GetCertificatesWithInfo(stapling.Host(), FetchOptions{Port: stapling.Port()})
+	if err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+	if info == nil || !bytes.Equal(info.OCSPResponse, staple) {
+		t.Errorf("Expected stapled OCSP response %q, got %+v", staple, info)
+	}
+
+	plain := NewMockTLSServer(t)
+	defer plain.Close()
+
+	r = newTestRetriever(plain, 0)
+	_, info, err = r.GetCertificatesWithInfo(plain.Host(), FetchOptions{Port: plain.Port()})
+	if err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+	if info == nil || info.OCSPResponse != nil {
+		t.Errorf("Expected no OCSP response without a staple, got %+v", info)
+	}
+}
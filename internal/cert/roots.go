@@ -0,0 +1,45 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+)
+
+// VerifiedRoots verifies chain[0] using the rest of chain as intermediates and
+// returns every distinct root of the valid chains found, e.g. both roots of a
+// cross-signed hierarchy. roots nil uses the system certificate pool.
+func VerifiedRoots(chain []*x509.Certificate, roots *x509.CertPool) ([]*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+
+	verified, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify certificate chain: %w", err)
+	}
+
+	var found []*x509.Certificate
+	for _, path := range verified {
+		root := path[len(path)-1]
+		duplicate := false
+		for _, seen := range found {
+			if bytes.Equal(seen.Raw, root.Raw) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			found = append(found, root)
+		}
+	}
+	return found, nil
+}
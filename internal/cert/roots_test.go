@@ -0,0 +1,63 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+)
+
+func TestVerifiedRoots_CrossSigned(t *testing.T) {
+	chain, roots, err := GenerateCrossSignedTestChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate cross-signed chain: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+
+	found, err := VerifiedRoots(chain, pool)
+	if err != nil {
+		t.Fatalf("Expected chain to verify, got: %v", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("Expected both roots, got %d", len(found))
+	}
+	for _, root := range roots {
+		present := false
+		for _, f := range found {
+			if bytes.Equal(f.Raw, root.Raw) {
+				present = true
+			}
+		}
+		if !present {
+			t.Errorf("Expected root %q among verified roots", root.Subject.CommonName)
+		}
+	}
+
+	// Only one root trusted: a single root is found
+	onlyOld := x509.NewCertPool()
+	onlyOld.AddCert(roots[1])
+	found, err = VerifiedRoots(chain, onlyOld)
+	if err != nil {
+		t.Fatalf("Expected chain to verify through the cross-signed certificate, got: %v", err)
+	}
+	if len(found) != 1 || found[0].Subject.CommonName != "Old Root" {
+		t.Errorf("Expected only the old root, got %v", found)
+	}
+}
+
+func TestVerifiedRoots_Untrusted(t *testing.T) {
+	chain, _, err := GenerateCrossSignedTestChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate cross-signed chain: %v", err)
+	}
+
+	if _, err := VerifiedRoots(chain, x509.NewCertPool()); err == nil {
+		t.Error("Expected error for a chain without a trusted root")
+	}
+	if _, err := VerifiedRoots(nil, nil); err == nil {
+		t.Error("Expected error for an empty chain")
+	}
+}
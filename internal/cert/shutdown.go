@@ -0,0 +1,44 @@
+package cert
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrShuttingDown is returned for fetches refused or aborted by Shutdown
+var ErrShuttingDown = errors.New("certificate retriever is shutting down")
+
+// Shutdown stops the retriever from starting new upstream fetches and waits for
+// those in flight to finish and commit their cache entries. Fetches still
+// running when ctx is done are aborted and fail with ErrShuttingDown without
+// touching the cache. Shutdown returns once no fetch is left, with ctx's error
+// if any had to be aborted, so the cache can be saved in a consistent state.
+func (r *Retriever) Shutdown(ctx context.Context) error {
+	r.inflightMu.Lock()
+	r.closing = true
+	r.inflightMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.fetches.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		r.abort()
+		return nil
+	case <-ctx.Done():
+		r.abort()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// shutdownError marks err as caused by Shutdown aborting the fetch, if it was
+func (r *Retriever) shutdownError(err error) error {
+	if err == nil || r.ctx.Err() == nil || errors.Is(err, ErrShuttingDown) {
+		return err
+	}
+	return errors.Join(ErrShuttingDown, err)
+}
@@ -0,0 +1,117 @@
+package cert
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForConnections waits until server has accepted n connections
+func waitForConnections(t *testing.T, server *MockTLSServer, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Connections() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d connections", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestShutdown_AbortsInFlightFetch(t *testing.T) {
+	fast := NewMockTLSServer(t)
+	defer fast.Close()
+	slow := NewSlowMockTLSServer(t, 5*time.Second)
+	defer slow.Close()
+
+	r := newTestRetriever(fast, time.Hour)
+	r.rootCAs.AddCert(slow.Certificate())
+
+	// One completed fetch is cached before shutdown
+	if _, err := r.GetCertificatesWithOptions(fast.Host(), FetchOptions{Port: fast.Port()}); err != nil {
+		t.Fatalf("Failed to retrieve certificates: %v", err)
+	}
+
+	fetchErr := make(chan error, 1)
+	go func() {
+		_, err := r.GetCertificatesWithOptions(slow.Host(), FetchOptions{Port: slow.Port()})
+		fetchErr <- err
+	}()
+	waitForConnections(t, slow, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Shutdown to report the aborted fetch, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Shutdown took %v, expected the slow fetch to be aborted", elapsed)
+	}
+
+	// Shutdown only returns once the aborted fetch has
+	select {
+	case err := <-fetchErr:
+		if !errors.Is(err, ErrShuttingDown) {
+			t.Errorf("Expected ErrShuttingDown, got %v", err)
+		}
+	default:
+		t.Fatal("Shutdown returned before the in-flight fetch")
+	}
+
+	if _, found := r.cache.Get(cacheKey(slow.Host(), slow.Port(), "")); found {
+		t.Error("Aborted fetch must not be cached")
+	}
+	if _, found := r.cache.Get(cacheKey(fast.Host(), fast.Port(), "")); !found {
+		t.Error("Completed fetch should stay cached")
+	}
+
+	// The saved cache holds exactly the completed fetch
+	path := filepath.Join(t.TempDir(), "cert-cache.json")
+	if err := r.SaveCacheFile(path); err != nil {
+		t.Fatalf("Failed to save cache: %v", err)
+	}
+	loaded, err := NewRetriever(time.Second, time.Hour).LoadCacheFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load saved cache: %v", err)
+	}
+	if loaded != 1 {
+		t.Errorf("Expected 1 cache entry, got %d", loaded)
+	}
+
+	// New fetches are refused without dialing
+	if _, err := r.GetCertificatesWithOptions(slow.Host(), FetchOptions{Port: slow.Port()}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Expected ErrShuttingDown after Shutdown, got %v", err)
+	}
+	if got := slow.Connections(); got != 1 {
+		t.Errorf("Expected no new connection after Shutdown, got %d", got)
+	}
+}
+
+func TestShutdown_WaitsForInFlightFetch(t *testing.T) {
+	server := NewSlowMockTLSServer(t, 100*time.Millisecond)
+	defer server.Close()
+
+	r := newTestRetriever(server, time.Hour)
+
+	fetchErr := make(chan error, 1)
+	go func() {
+		_, err := r.GetCertificatesWithOptions(server.Host(), FetchOptions{Port: server.Port()})
+		fetchErr <- err
+	}()
+	waitForConnections(t, server, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Errorf("Expected the fetch to be drained, got %v", err)
+	}
+	if err := <-fetchErr; err != nil {
+		t.Errorf("Expected the in-flight fetch to complete, got %v", err)
+	}
+	if _, found := r.cache.Get(cacheKey(server.Host(), server.Port(), "")); !found {
+		t.Error("Drained fetch should be cached")
+	}
+}
@@ -0,0 +1,166 @@
+package cert
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Supported STARTTLS protocols
+const (
+	STARTTLSSMTP = "smtp"
+	STARTTLSIMAP = "imap"
+)
+
+// Default ports used when no explicit port is requested
+const (
+	DefaultTLSPort  = 443
+	DefaultSMTPPort = 25
+	DefaultIMAPPort = 143
+)
+
+// imapTag is the command tag used for the IMAP STARTTLS command
+const imapTag = "a001"
+
+// IsSupportedSTARTTLS reports whether the given STARTTLS protocol is supported
+func IsSupportedSTARTTLS(protocol string) bool {
+	switch protocol {
+	case STARTTLSSMTP, STARTTLSIMAP:
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultPort returns the default port for a STARTTLS protocol
+// (443 for plain TLS)
+func DefaultPort(protocol string) int {
+	switch protocol {
+	case STARTTLSSMTP:
+		return DefaultSMTPPort
+	case STARTTLSIMAP:
+		return DefaultIMAPPort
+	default:
+		return DefaultTLSPort
+	}
+}
+
+// fetchCertificatesSTARTTLS connects in plaintext, negotiates STARTTLS using
+// the given protocol and then performs the TLS handshake to retrieve certificates
+func (r *Retriever) fetchCertificatesSTARTTLS(domain string, port int, protocol string) ([]*x509.Certificate, *ConnectionInfo, error) {
+	ctx, cancel := withTimeout(r.ctx, r.dialTimeout)
+	defer cancel()
+
+	conn, err := r.dial(ctx, domain, port)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	// Bound the whole negotiation and handshake by the dial timeout
+	if r.dialTimeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(r.dialTimeout)); err != nil {
+			return nil, nil, fmt.Errorf("failed to set deadline for %s: %w", domain, err)
+		}
+	}
+
+	switch protocol {
+	case STARTTLSSMTP:
+		err = negotiateSMTP(conn)
+	case STARTTLSIMAP:
+		err = negotiateIMAP(conn)
+	default:
+		err = fmt.Errorf("unsupported STARTTLS protocol: %s", protocol)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("STARTTLS negotiation with %s failed: %w", domain, err)
+	}
+
+	if r.fastLeaf {
+		return r.fastLeafHandshake(ctx, conn, domain)
+	}
+
+	tlsConn, err := r.handshake(ctx, conn, domain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return peerCertificates(tlsConn, domain)
+}
+
+// negotiateSMTP performs the SMTP greeting, EHLO and STARTTLS exchange (RFC 3207)
+func negotiateSMTP(conn net.Conn) error {
+	text := textproto.NewConn(conn)
+
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return fmt.Errorf("unexpected greeting: %w", err)
+	}
+
+	if err := text.PrintfLine("EHLO dynapins"); err != nil {
+		return err
+	}
+	_, msg, err := text.ReadResponse(250)
+	if err != nil {
+		return fmt.Errorf("EHLO rejected: %w", err)
+	}
+	if !hasSMTPExtension(msg, "STARTTLS") {
+		return fmt.Errorf("server does not advertise STARTTLS")
+	}
+
+	if err := text.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return fmt.Errorf("STARTTLS rejected: %w", err)
+	}
+
+	return nil
+}
+
+// hasSMTPExtension reports whether an EHLO response advertises the given extension
+func hasSMTPExtension(ehloResponse, extension string) bool {
+	// The first line is the greeting, the rest are extensions
+	for _, line := range strings.Split(ehloResponse, "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.EqualFold(fields[0], extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateIMAP performs the IMAP greeting and STARTTLS exchange (RFC 3501)
+func negotiateIMAP(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+
+	greeting, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected greeting: %s", strings.TrimSpace(greeting))
+	}
+
+	if _, err := fmt.Fprintf(conn, "%s STARTTLS\r\n", imapTag); err != nil {
+		return err
+	}
+
+	// Skip untagged responses until the tagged completion arrives
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read STARTTLS response: %w", err)
+		}
+		if !strings.HasPrefix(line, imapTag+" ") {
+			continue
+		}
+		if !strings.HasPrefix(line, imapTag+" OK") {
+			return fmt.Errorf("STARTTLS rejected: %s", strings.TrimSpace(line))
+		}
+		return nil
+	}
+}
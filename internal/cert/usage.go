@@ -0,0 +1,39 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+)
+
+// ErrMissingServerAuth is returned when a leaf certificate is not valid for TLS server authentication
+var ErrMissingServerAuth = errors.New("certificate does not permit TLS server authentication (missing serverAuth extended key usage)")
+
+// ErrSelfSigned is returned when the upstream presents only a self-signed leaf certificate
+var ErrSelfSigned = errors.New("self-signed certificate")
+
+// ValidateServerAuth checks that the certificate carries the serverAuth extended key usage
+// (or anyExtendedKeyUsage) so that only genuine TLS server certificates get pinned
+func ValidateServerAuth(cert *x509.Certificate) error {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth || usage == x509.ExtKeyUsageAny {
+			return nil
+		}
+	}
+	return ErrMissingServerAuth
+}
+
+// ValidateNotSelfSigned rejects a chain consisting of a single certificate whose
+// issuer equals its subject, which in production usually indicates interception
+func ValidateNotSelfSigned(chain []*x509.Certificate) error {
+	if len(chain) == 1 && bytes.Equal(chain[0].RawIssuer, chain[0].RawSubject) {
+		return ErrSelfSigned
+	}
+	return nil
+}
+
+// IsSelfIssuedRoot reports whether the certificate looks like a root CA: a CA
+// certificate whose issuer equals its subject
+func IsSelfIssuedRoot(cert *x509.Certificate) bool {
+	return cert.BasicConstraintsValid && cert.IsCA && bytes.Equal(cert.RawIssuer, cert.RawSubject)
+}
@@ -0,0 +1,111 @@
+package cert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestValidateServerAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		extKeyUsage []x509.ExtKeyUsage
+		expectError bool
+	}{
+		{
+			name:        "server_auth",
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			expectError: false,
+		},
+		{
+			name:        "server_and_client_auth",
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+			expectError: false,
+		},
+		{
+			name:        "any_usage",
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			expectError: false,
+		},
+		{
+			name:        "client_auth_only",
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			expectError: true,
+		},
+		{
+			name:        "no_ext_key_usage",
+			extKeyUsage: nil,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := GenerateTestCertificateWithTemplate(&x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "example.com"},
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  tt.extKeyUsage,
+			})
+			if err != nil {
+				t.Fatalf("Failed to generate certificate: %v", err)
+			}
+
+			err = ValidateServerAuth(cert)
+			if tt.expectError && !errors.Is(err, ErrMissingServerAuth) {
+				t.Errorf("Expected ErrMissingServerAuth, got %v", err)
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateNotSelfSigned(t *testing.T) {
+	selfSigned, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	chain, err := GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+
+	if err := ValidateNotSelfSigned([]*x509.Certificate{selfSigned}); !errors.Is(err, ErrSelfSigned) {
+		t.Errorf("Expected ErrSelfSigned for a lone self-signed leaf, got %v", err)
+	}
+	if err := ValidateNotSelfSigned(chain); err != nil {
+		t.Errorf("Expected CA-issued chain to pass, got %v", err)
+	}
+	if err := ValidateNotSelfSigned(chain[:1]); err != nil {
+		t.Errorf("Expected CA-issued leaf to pass, got %v", err)
+	}
+}
+
+func TestIsSelfIssuedRoot(t *testing.T) {
+	chain, err := GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate chain: %v", err)
+	}
+	if IsSelfIssuedRoot(chain[0]) {
+		t.Error("Expected leaf not to be a root")
+	}
+	if !IsSelfIssuedRoot(chain[1]) {
+		t.Error("Expected self-issued CA to be a root")
+	}
+
+	// Self-signed but not a CA
+	selfSigned, err := GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	if IsSelfIssuedRoot(selfSigned) {
+		t.Error("Expected non-CA self-signed certificate not to be a root")
+	}
+}
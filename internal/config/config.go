@@ -1,15 +1,26 @@
 package config
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"pinning-server/internal/domain"
 )
 
 // Config holds the application configuration
@@ -22,22 +33,188 @@ type Config struct {
 	ShutdownTimeout   time.Duration
 	ReadHeaderTimeout time.Duration
 	MaxHeaderBytes    int
+	GRPCPort          int
+	// BatchConcurrency bounds the concurrent upstream fetches of one batch request
+	BatchConcurrency int
+	// MaxInflightRequests bounds concurrent non-probe requests (0 disables)
+	MaxInflightRequests int
+	// HealthCanaryDomain is fetched by GET /health?deep=true (empty keeps deep checks shallow)
+	HealthCanaryDomain string
+	// HealthTimeout bounds the canary fetch of a deep health check
+	HealthTimeout time.Duration
+
+	// Direct TLS serving configuration (HTTPS is served when both files are set)
+	TLSCertFile      string
+	TLSKeyFile       string
+	ServerAllowedSNI []string
 
 	// Domain and security configuration
 	AllowedDomains    []string
 	SignatureLifetime time.Duration
-	PrivateKey        *ecdsa.PrivateKey
-	PublicKey         *ecdsa.PublicKey
+	// SignatureLifetimeMin and SignatureLifetimeMax bound the ttl query parameter
+	SignatureLifetimeMin time.Duration
+	SignatureLifetimeMax time.Duration
+	// MinTTL is the floor of every token lifetime, whatever its source (0 disables)
+	MinTTL time.Duration
+	// TTLStrict rejects out-of-bounds ttl parameters instead of clamping them
+	TTLStrict         bool
+	PrivateKey        crypto.Signer    // *ecdsa.PrivateKey, or *rsa.PrivateKey with AllowRSASigning
+	PublicKey         crypto.PublicKey // Public half of PrivateKey
+	AllowRSASigning   bool             // Accept RSA private keys, signing with RS256 (larger tokens)
+	DevMode           bool             // Generate an ephemeral signing key when PRIVATE_KEY_PEM is unset (ENVIRONMENT=dev only)
+	EphemeralKey      bool             // PrivateKey was generated at startup and is discarded on exit
 	AllowIPLiterals   bool
+	StrictWhitelist   bool
+	HideWhitelist     bool // Answer 404 instead of 403 for domains not in the whitelist
+	WhitelistBySAN    bool // Also allow domains whose fetched leaf has a whitelisted SAN
+	RejectMixedScript bool // Reject domains whose labels mix Unicode scripts (homographs)
+
+	// WhitelistWarnings lists duplicate or overlapping ALLOWED_DOMAINS entries
+	// found at load time, so they can be logged once the logger is configured
+	WhitelistWarnings []string
 
 	// Certificate retrieval configuration
 	CertDialTimeout time.Duration
-	CertCacheTTL    time.Duration
+	// Per-phase retrieval budgets (0 falls back to CertDialTimeout)
+	CertResolveTimeout   time.Duration
+	CertConnectTimeout   time.Duration
+	CertHandshakeTimeout time.Duration
+	CertCacheTTL         time.Duration
+	// CertCacheTTLOverrides replaces CertCacheTTL for individual lowercased domains
+	CertCacheTTLOverrides map[string]time.Duration
+	SPKICacheSize         int
+	// CacheHighWaterMark reports readiness as degraded above this many cache entries (0 disables)
+	CacheHighWaterMark int
+	CertSANCache       bool
+	// CertCacheCompress caches DER bytes instead of parsed certificates (less memory, more CPU)
+	CertCacheCompress bool
+	// CertCacheFile persists the certificate cache across restarts (empty disables)
+	CertCacheFile string
+	// CertDisableResumption forces full upstream TLS handshakes so the complete chain is always presented
+	CertDisableResumption bool
+
+	// CertFastLeaf aborts upstream handshakes as soon as the verified chain is received
+	CertFastLeaf bool
+	// CertFailureStatus is the HTTP status of certificate retrieval failures (422, 502 or 503)
+	CertFailureStatus int
+	// ClassifyCertErrors answers 503 for transient upstream failures and 422 for certificate problems
+	ClassifyCertErrors bool
+	// CertFallbackPorts are tried in order for plain TLS requests without a port
+	CertFallbackPorts []int
+	// AllowedCertPorts restricts the port query parameter (empty allows any port)
+	AllowedCertPorts []int
+	// CertSource selects where certificates come from (CertSourceNetwork or CertSourceDisk)
+	CertSource string
+	// CertDir holds <domain>.pem chains when CertSource is CertSourceDisk
+	CertDir string
+
+	// Warmup configuration
+	WarmupDomains []string
+	// WarmupBlock warms WarmupDomains before the listener is opened
+	WarmupBlock bool
+	// WarmupAbortOnFailure fails startup if a blocking warmup fails
+	WarmupAbortOnFailure bool
+	// ValidateDomainsOnStart resolves exact ALLOWED_DOMAINS entries at startup, warning about failures
+	ValidateDomainsOnStart bool
+
+	// Certificate validation configuration
+	RequireServerAuthEKU bool
+	AllowSelfSigned      bool
+	IdentifyLeaf         bool
+	// ForceLeafOnly pins only the leaf, ignoring requests for backup and root pins
+	ForceLeafOnly bool
+	// ExcludeRootFromBackup keeps self-issued root CAs out of the backup pins
+	ExcludeRootFromBackup bool
+	// BackupRequireIntermediate rejects backup pin requests for chains without an intermediate
+	BackupRequireIntermediate bool
+	// BackupMaxCerts rejects backup pin requests for chains longer than this (0 disables)
+	BackupMaxCerts int
+	// CertMinRemainingValidity rejects leaves expiring sooner than this (0 disables)
+	CertMinRemainingValidity time.Duration
+	// BlockedIssuers rejects chains issued under these CAs (issuer common names or base64 SPKI hashes)
+	BlockedIssuers []string
+	// ExpectedPins maps lowercased domains to the base64 SPKI hashes their leaf is expected to have
+	ExpectedPins map[string][]string
+	// FailOnPinDrift rejects leaves whose SPKI hash is not in ExpectedPins instead of only warning
+	FailOnPinDrift bool
+	// BackupPins maps lowercased domains to extra base64 SPKI hashes (e.g. of a rotation's future key) appended to their pins
+	BackupPins map[string][]string
+
+	// Response configuration
+	ServerTimeClaim      bool
+	IncludeHumanTimes    bool // Add RFC 3339 iat_str and exp_str claims
+	DefaultIncludeBackup bool
+	JWSResponseKey       string
+	ErrorFormat          string // ErrorFormatJSON or ErrorFormatProblemJSON
+	SecurityHeaders      bool
+	// JWSType is the protected typ header of issued tokens (e.g. pins+jwt)
+	JWSType string
+	// PinSort orders the pins in tokens (PinSortChain or PinSortLeafFirst)
+	PinSort string
+	// PinCacheControl sets private Cache-Control max-age and Expires on pin responses to the token lifetime
+	PinCacheControl bool
+	// MatchedRuleClaim adds the matched whitelist entry as a matched_rule claim (debugging)
+	MatchedRuleClaim bool
+	// LogNormalizedDomain logs the normalized (lowercase, punycode) domain next to the raw one
+	LogNormalizedDomain bool
+	// CanonicalDomainClaim signs the normalized (lowercase, punycode) domain instead of the raw one
+	CanonicalDomainClaim bool
+	// TLSInfoClaim adds the upstream TLS version and cipher suite as claims (debugging)
+	TLSInfoClaim bool
+	// VerifyAfterSign re-verifies every issued token against PublicKey before returning it
+	VerifyAfterSign bool
+	// Environment names the deployment (e.g. staging) in the env claim and X-Environment header
+	Environment string
+	// ClientSkewTolerance is advertised as a skew_tolerance_seconds claim (0 omits it)
+	ClientSkewTolerance time.Duration
+
+	// Profiling configuration
+	EnablePprof  bool
+	PprofAddr    string // Separate admin listener for pprof (empty uses the main listener)
+	EnableExpvar bool   // Serve cache, token and in-flight request metrics at /debug/vars
+
+	// Admin configuration
+	EnableAdminConfig bool   // Serve the redacted effective config at /v1/admin/config
+	AdminToken        string // Bearer token required by admin endpoints
+
+	// Abuse protection configuration
+	MaxDistinctDomainsPerClient int           // Distinct domains one client IP may query per window (0 disables)
+	DistinctDomainsWindow       time.Duration // Window over which distinct domains are counted
+
+	// Alerting configuration
+	AlertWebhookURL       string // POSTed an alert when a domain's fetches keep failing (empty disables)
+	AlertFailureThreshold int    // Consecutive failures that trigger an alert
+
+	// Pin failure reporting configuration
+	ReportSinkURL   string // Pin failure reports are forwarded here (empty only logs them)
+	ReportRateLimit int    // Reports accepted per client IP per minute (0 disables the limit)
 
 	// Logging configuration
 	LogLevel string
+	// LogRequestHeaders lists request headers whose values are attached to request logs
+	LogRequestHeaders []string
+	// MaxLoggedSANs caps the SANs listed in certificate logs (0 logs them all)
+	MaxLoggedSANs int
 }
 
+// Supported values of CERT_SOURCE
+const (
+	CertSourceNetwork = "network" // Dial each domain
+	CertSourceDisk    = "disk"    // Read <domain>.pem files from CERT_DIR
+)
+
+// Supported values of ERROR_FORMAT
+const (
+	ErrorFormatJSON        = "json"         // {"error","code"}
+	ErrorFormatProblemJSON = "problem-json" // RFC 7807 application/problem+json
+)
+
+// Supported values of PIN_SORT
+const (
+	PinSortChain     = "chain"      // Keep certificate chain order
+	PinSortLeafFirst = "leaf-first" // Leaf pin first, the rest sorted lexicographically
+)
+
 // Load reads configuration from environment variables
 func Load() (*Config, error) {
 	cfg := &Config{}
@@ -69,6 +246,15 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
 	}
 
+	cfg.HealthCanaryDomain = getEnvString("HEALTH_CANARY_DOMAIN", "")
+	cfg.HealthTimeout, err = getEnvDuration("HEALTH_TIMEOUT", 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEALTH_TIMEOUT: %w", err)
+	}
+	if cfg.HealthTimeout <= 0 {
+		return nil, fmt.Errorf("invalid HEALTH_TIMEOUT: must be positive, got %s", cfg.HealthTimeout)
+	}
+
 	cfg.ReadHeaderTimeout, err = getEnvDuration("READ_HEADER_TIMEOUT", 5*time.Second)
 	if err != nil {
 		return nil, fmt.Errorf("invalid READ_HEADER_TIMEOUT: %w", err)
@@ -79,14 +265,65 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid MAX_HEADER_BYTES: %w", err)
 	}
 
+	cfg.GRPCPort, err = getEnvInt("GRPC_PORT", 0) // 0 disables the gRPC server
+	if err != nil {
+		return nil, fmt.Errorf("invalid GRPC_PORT: %w", err)
+	}
+
+	cfg.MaxInflightRequests, err = getEnvInt("MAX_INFLIGHT_REQUESTS", 0) // 0 disables the limit
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAX_INFLIGHT_REQUESTS: %w", err)
+	}
+
+	cfg.BatchConcurrency, err = getEnvInt("BATCH_CONCURRENCY", 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BATCH_CONCURRENCY: %w", err)
+	}
+	if cfg.BatchConcurrency < 1 {
+		return nil, fmt.Errorf("invalid BATCH_CONCURRENCY: must be at least 1, got %d", cfg.BatchConcurrency)
+	}
+
+	cfg.TLSCertFile = getEnvString("TLS_CERT_FILE", "")
+	cfg.TLSKeyFile = getEnvString("TLS_KEY_FILE", "")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if sniStr := os.Getenv("SERVER_ALLOWED_SNI"); sniStr != "" {
+		for _, name := range strings.Split(sniStr, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.ServerAllowedSNI = append(cfg.ServerAllowedSNI, name)
+			}
+		}
+	}
+	if len(cfg.ServerAllowedSNI) > 0 && cfg.TLSCertFile == "" {
+		return nil, errors.New("SERVER_ALLOWED_SNI requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
 	// Domain and security configuration
 	allowedDomainsStr := os.Getenv("ALLOWED_DOMAINS")
 	if allowedDomainsStr == "" {
 		return nil, errors.New("ALLOWED_DOMAINS environment variable is required")
 	}
-	cfg.AllowedDomains = strings.Split(allowedDomainsStr, ",")
-	for i := range cfg.AllowedDomains {
-		cfg.AllowedDomains[i] = strings.TrimSpace(cfg.AllowedDomains[i])
+	for _, entry := range strings.Split(allowedDomainsStr, ",") {
+		// Skip empty entries such as those produced by "a.com,,b.com" or a trailing comma
+		if entry = strings.TrimSpace(entry); entry != "" {
+			cfg.AllowedDomains = append(cfg.AllowedDomains, entry)
+		}
+	}
+	if len(cfg.AllowedDomains) == 0 {
+		return nil, fmt.Errorf("ALLOWED_DOMAINS contains no domains (got %q)", allowedDomainsStr)
+	}
+
+	// Detect duplicate and overlapping whitelist entries
+	cfg.StrictWhitelist = getEnvBool("STRICT_WHITELIST", false)
+	cfg.HideWhitelist = getEnvBool("HIDE_WHITELIST", false)
+	cfg.WhitelistBySAN = getEnvBool("WHITELIST_BY_SAN", false)
+	cfg.RejectMixedScript = getEnvBool("REJECT_MIXED_SCRIPT", false)
+	cfg.AllowedDomains, cfg.WhitelistWarnings = checkWhitelist(cfg.AllowedDomains)
+	if cfg.StrictWhitelist && len(cfg.WhitelistWarnings) > 0 {
+		return nil, fmt.Errorf("invalid ALLOWED_DOMAINS (STRICT_WHITELIST enabled): %s",
+			strings.Join(cfg.WhitelistWarnings, "; "))
 	}
 
 	cfg.SignatureLifetime, err = getEnvDuration("SIGNATURE_LIFETIME", 1*time.Hour)
@@ -94,17 +331,68 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid SIGNATURE_LIFETIME: %w", err)
 	}
 
-	privateKeyPEM := os.Getenv("PRIVATE_KEY_PEM")
-	if privateKeyPEM == "" {
-		return nil, errors.New("PRIVATE_KEY_PEM environment variable is required")
+	cfg.SignatureLifetimeMin, err = getEnvDuration("SIGNATURE_LIFETIME_MIN", time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SIGNATURE_LIFETIME_MIN: %w", err)
 	}
 
-	privateKey, err := parsePrivateKey(privateKeyPEM)
+	cfg.SignatureLifetimeMax, err = getEnvDuration("SIGNATURE_LIFETIME_MAX", cfg.SignatureLifetime)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse PRIVATE_KEY_PEM: %w", err)
+		return nil, fmt.Errorf("invalid SIGNATURE_LIFETIME_MAX: %w", err)
+	}
+	// A defaulted bound gives way to an explicit one (or to a short
+	// SIGNATURE_LIFETIME); only two explicit bounds can conflict
+	if cfg.SignatureLifetimeMin > cfg.SignatureLifetimeMax {
+		minSet := os.Getenv("SIGNATURE_LIFETIME_MIN") != ""
+		maxSet := os.Getenv("SIGNATURE_LIFETIME_MAX") != ""
+		switch {
+		case minSet && maxSet:
+			return nil, fmt.Errorf("SIGNATURE_LIFETIME_MIN (%s) exceeds SIGNATURE_LIFETIME_MAX (%s)",
+				cfg.SignatureLifetimeMin, cfg.SignatureLifetimeMax)
+		case minSet:
+			cfg.SignatureLifetimeMax = cfg.SignatureLifetimeMin
+		default:
+			cfg.SignatureLifetimeMin = cfg.SignatureLifetimeMax
+		}
+	}
+
+	cfg.MinTTL, err = getEnvDuration("MIN_TTL", time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MIN_TTL: %w", err)
+	}
+	if cfg.MinTTL < 0 {
+		return nil, fmt.Errorf("invalid MIN_TTL: must not be negative, got %s", cfg.MinTTL)
+	}
+
+	cfg.TTLStrict = getEnvBool("TTL_STRICT", false)
+
+	// DEV_MODE is refused outside ENVIRONMENT=dev so a stray flag cannot make a
+	// production server sign with a throwaway key
+	cfg.DevMode = getEnvBool("DEV_MODE", false)
+	if cfg.DevMode && os.Getenv("ENVIRONMENT") != "dev" {
+		return nil, errors.New("DEV_MODE requires ENVIRONMENT=dev")
+	}
+
+	cfg.AllowRSASigning = getEnvBool("ALLOW_RSA_SIGNING", false)
+	privateKeyPEM := os.Getenv("PRIVATE_KEY_PEM")
+	switch {
+	case privateKeyPEM != "":
+		privateKey, err := parsePrivateKey(privateKeyPEM, cfg.AllowRSASigning)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PRIVATE_KEY_PEM: %w", err)
+		}
+		cfg.PrivateKey = privateKey
+	case cfg.DevMode:
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+		}
+		cfg.PrivateKey = privateKey
+		cfg.EphemeralKey = true
+	default:
+		return nil, errors.New("PRIVATE_KEY_PEM environment variable is required")
 	}
-	cfg.PrivateKey = privateKey
-	cfg.PublicKey = &privateKey.PublicKey
+	cfg.PublicKey = cfg.PrivateKey.Public()
 
 	cfg.AllowIPLiterals = getEnvBool("ALLOW_IP_LITERALS", false)
 
@@ -114,17 +402,404 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid CERT_DIAL_TIMEOUT: %w", err)
 	}
 
+	cfg.CertResolveTimeout, err = getEnvDuration("CERT_RESOLVE_TIMEOUT", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CERT_RESOLVE_TIMEOUT: %w", err)
+	}
+
+	cfg.CertConnectTimeout, err = getEnvDuration("CERT_CONNECT_TIMEOUT", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CERT_CONNECT_TIMEOUT: %w", err)
+	}
+
+	cfg.CertHandshakeTimeout, err = getEnvDuration("CERT_HANDSHAKE_TIMEOUT", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CERT_HANDSHAKE_TIMEOUT: %w", err)
+	}
+
 	cfg.CertCacheTTL, err = getEnvDuration("CERT_CACHE_TTL", 5*time.Minute)
 	if err != nil {
 		return nil, fmt.Errorf("invalid CERT_CACHE_TTL: %w", err)
 	}
 
+	cfg.CertCacheTTLOverrides, err = getEnvDurationMap("CERT_CACHE_TTL_OVERRIDES")
+	if err != nil {
+		return nil, fmt.Errorf("invalid CERT_CACHE_TTL_OVERRIDES: %w", err)
+	}
+
+	cfg.CertSANCache = getEnvBool("CERT_SAN_CACHE", false)
+	cfg.CertCacheCompress = getEnvBool("CERT_CACHE_COMPRESS", false)
+	cfg.CertCacheFile = getEnvString("CERT_CACHE_FILE", "")
+	cfg.CertDisableResumption = getEnvBool("CERT_DISABLE_RESUMPTION", true)
+	cfg.CertFastLeaf = getEnvBool("CERT_FAST_LEAF", false)
+
+	cfg.CertFailureStatus, err = getEnvInt("CERT_FAILURE_STATUS", http.StatusUnprocessableEntity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CERT_FAILURE_STATUS: %w", err)
+	}
+	switch cfg.CertFailureStatus {
+	case http.StatusUnprocessableEntity, http.StatusBadGateway, http.StatusServiceUnavailable:
+	default:
+		return nil, fmt.Errorf("invalid CERT_FAILURE_STATUS %d (supported: 422, 502, 503)", cfg.CertFailureStatus)
+	}
+	cfg.ClassifyCertErrors = getEnvBool("CLASSIFY_CERT_ERRORS", false)
+
+	cfg.CertFallbackPorts, err = getEnvPorts("CERT_FALLBACK_PORTS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid CERT_FALLBACK_PORTS: %w", err)
+	}
+
+	cfg.AllowedCertPorts, err = getEnvPorts("ALLOWED_CERT_PORTS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALLOWED_CERT_PORTS: %w", err)
+	}
+	if cfg.AllowedCertPorts == nil {
+		cfg.AllowedCertPorts = []int{443}
+	}
+
+	cfg.CertSource = strings.ToLower(getEnvString("CERT_SOURCE", CertSourceNetwork))
+	cfg.CertDir = getEnvString("CERT_DIR", "")
+	switch cfg.CertSource {
+	case CertSourceNetwork:
+	case CertSourceDisk:
+		if cfg.CertDir == "" {
+			return nil, errors.New("CERT_SOURCE=disk requires CERT_DIR")
+		}
+	default:
+		return nil, fmt.Errorf("invalid CERT_SOURCE %q (supported: network, disk)", cfg.CertSource)
+	}
+
+	cfg.CacheHighWaterMark, err = getEnvInt("CACHE_HIGH_WATER_MARK", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CACHE_HIGH_WATER_MARK: %w", err)
+	}
+
+	cfg.SPKICacheSize, err = getEnvInt("SPKI_CACHE_SIZE", 1024)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPKI_CACHE_SIZE: %w", err)
+	}
+
+	// Warmup configuration
+	if warmupStr := os.Getenv("WARMUP_DOMAINS"); warmupStr != "" {
+		for _, name := range strings.Split(warmupStr, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.WarmupDomains = append(cfg.WarmupDomains, name)
+			}
+		}
+	}
+	cfg.WarmupBlock = getEnvBool("WARMUP_BLOCK", false)
+	cfg.WarmupAbortOnFailure = getEnvBool("WARMUP_ABORT_ON_FAILURE", false)
+	cfg.ValidateDomainsOnStart = getEnvBool("VALIDATE_DOMAINS_ON_START", false)
+
+	// Certificate validation configuration
+	cfg.RequireServerAuthEKU = getEnvBool("REQUIRE_SERVER_AUTH_EKU", true)
+	cfg.AllowSelfSigned = getEnvBool("ALLOW_SELF_SIGNED", false)
+	cfg.IdentifyLeaf = getEnvBool("IDENTIFY_LEAF", false)
+	cfg.ForceLeafOnly = getEnvBool("FORCE_LEAF_ONLY", false)
+	cfg.ExcludeRootFromBackup = getEnvBool("EXCLUDE_ROOT_FROM_BACKUP", false)
+	cfg.BackupRequireIntermediate = getEnvBool("BACKUP_REQUIRE_INTERMEDIATE", false)
+
+	cfg.BackupMaxCerts, err = getEnvInt("BACKUP_MAX_CERTS", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BACKUP_MAX_CERTS: %w", err)
+	}
+
+	cfg.CertMinRemainingValidity, err = getEnvDuration("CERT_MIN_REMAINING_VALIDITY", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CERT_MIN_REMAINING_VALIDITY: %w", err)
+	}
+
+	if issuersStr := os.Getenv("BLOCKED_ISSUERS"); issuersStr != "" {
+		for _, issuer := range strings.Split(issuersStr, ",") {
+			if issuer = strings.TrimSpace(issuer); issuer != "" {
+				cfg.BlockedIssuers = append(cfg.BlockedIssuers, issuer)
+			}
+		}
+	}
+
+	cfg.ExpectedPins, err = getEnvPinMap("EXPECTED_PINS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXPECTED_PINS: %w", err)
+	}
+	cfg.FailOnPinDrift = getEnvBool("FAIL_ON_PIN_DRIFT", false)
+	cfg.BackupPins, err = getEnvPinMap("BACKUP_PINS")
+	if err != nil {
+		return nil, fmt.Errorf("invalid BACKUP_PINS: %w", err)
+	}
+
+	// Response configuration
+	cfg.ServerTimeClaim = getEnvBool("SERVER_TIME_CLAIM", false)
+	cfg.IncludeHumanTimes = getEnvBool("INCLUDE_HUMAN_TIMES", false)
+	cfg.DefaultIncludeBackup = getEnvBool("DEFAULT_INCLUDE_BACKUP", false)
+	cfg.PinSort = strings.ToLower(getEnvString("PIN_SORT", PinSortChain))
+	if cfg.PinSort != PinSortChain && cfg.PinSort != PinSortLeafFirst {
+		return nil, fmt.Errorf("invalid PIN_SORT %q (supported: chain, leaf-first)", cfg.PinSort)
+	}
+	cfg.JWSResponseKey = getEnvString("JWS_RESPONSE_KEY", "jws")
+	cfg.JWSType = getEnvString("JWS_TYP", "JWT")
+	cfg.ErrorFormat = strings.ToLower(getEnvString("ERROR_FORMAT", ErrorFormatJSON))
+	if cfg.ErrorFormat != ErrorFormatJSON && cfg.ErrorFormat != ErrorFormatProblemJSON {
+		return nil, fmt.Errorf("invalid ERROR_FORMAT %q (supported: json, problem-json)", cfg.ErrorFormat)
+	}
+	cfg.SecurityHeaders = getEnvBool("SECURITY_HEADERS", true)
+	cfg.PinCacheControl = getEnvBool("PIN_CACHE_CONTROL", true)
+	cfg.MatchedRuleClaim = getEnvBool("MATCHED_RULE_CLAIM", false)
+	cfg.LogNormalizedDomain = getEnvBool("LOG_NORMALIZED_DOMAIN", false)
+	cfg.CanonicalDomainClaim = getEnvBool("CANONICAL_DOMAIN_CLAIM", true)
+	cfg.TLSInfoClaim = getEnvBool("TLS_INFO_CLAIM", false)
+	cfg.VerifyAfterSign = getEnvBool("VERIFY_AFTER_SIGN", false)
+	cfg.Environment = getEnvString("ENVIRONMENT", "")
+	cfg.ClientSkewTolerance, err = getEnvDuration("CLIENT_SKEW_TOLERANCE", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CLIENT_SKEW_TOLERANCE: %w", err)
+	}
+	if cfg.ClientSkewTolerance < 0 {
+		return nil, fmt.Errorf("invalid CLIENT_SKEW_TOLERANCE: must not be negative, got %s", cfg.ClientSkewTolerance)
+	}
+
+	// Profiling configuration
+	cfg.EnablePprof = getEnvBool("ENABLE_PPROF", false)
+	cfg.PprofAddr = getEnvString("PPROF_ADDR", "")
+	cfg.EnableExpvar = getEnvBool("ENABLE_EXPVAR", false)
+
+	// Admin configuration
+	cfg.EnableAdminConfig = getEnvBool("ENABLE_ADMIN_CONFIG", false)
+	cfg.AdminToken = getEnvString("ADMIN_TOKEN", "")
+	if cfg.EnableAdminConfig && cfg.AdminToken == "" {
+		return nil, errors.New("ENABLE_ADMIN_CONFIG requires ADMIN_TOKEN")
+	}
+
+	// Abuse protection configuration
+	cfg.MaxDistinctDomainsPerClient, err = getEnvInt("MAX_DISTINCT_DOMAINS_PER_CLIENT", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAX_DISTINCT_DOMAINS_PER_CLIENT: %w", err)
+	}
+	cfg.DistinctDomainsWindow, err = getEnvDuration("DISTINCT_DOMAINS_WINDOW", time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DISTINCT_DOMAINS_WINDOW: %w", err)
+	}
+	if cfg.DistinctDomainsWindow <= 0 {
+		return nil, fmt.Errorf("invalid DISTINCT_DOMAINS_WINDOW: must be positive, got %s", cfg.DistinctDomainsWindow)
+	}
+
+	// Alerting configuration
+	cfg.AlertWebhookURL = getEnvString("ALERT_WEBHOOK_URL", "")
+	if cfg.AlertWebhookURL != "" {
+		if u, err := url.Parse(cfg.AlertWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("invalid ALERT_WEBHOOK_URL: must be an http or https URL")
+		}
+	}
+	cfg.AlertFailureThreshold, err = getEnvInt("ALERT_FAILURE_THRESHOLD", 5)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALERT_FAILURE_THRESHOLD: %w", err)
+	}
+	if cfg.AlertFailureThreshold < 1 {
+		return nil, fmt.Errorf("invalid ALERT_FAILURE_THRESHOLD: must be at least 1, got %d", cfg.AlertFailureThreshold)
+	}
+
+	// Pin failure reporting configuration
+	cfg.ReportSinkURL = getEnvString("REPORT_SINK_URL", "")
+	if cfg.ReportSinkURL != "" {
+		if u, err := url.Parse(cfg.ReportSinkURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("invalid REPORT_SINK_URL: must be an http or https URL")
+		}
+	}
+	cfg.ReportRateLimit, err = getEnvInt("REPORT_RATE_LIMIT", 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REPORT_RATE_LIMIT: %w", err)
+	}
+	if cfg.ReportRateLimit < 0 {
+		return nil, fmt.Errorf("invalid REPORT_RATE_LIMIT: must not be negative, got %d", cfg.ReportRateLimit)
+	}
+
 	// Logging configuration
 	cfg.LogLevel = getEnvString("LOG_LEVEL", "info")
 
+	if headersStr := os.Getenv("LOG_REQUEST_HEADERS"); headersStr != "" {
+		for _, name := range strings.Split(headersStr, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.LogRequestHeaders = append(cfg.LogRequestHeaders, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	cfg.MaxLoggedSANs, err = getEnvInt("MAX_LOGGED_SANS", 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAX_LOGGED_SANS: %w", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
+// ErrKeyMismatch is returned when PublicKey is not the public half of PrivateKey
+var ErrKeyMismatch = errors.New("public key does not match private key")
+
+// Validate checks invariants of a Config that may have been built without Load
+// The public key must be derived from the private key, otherwise the key ID
+// advertised in tokens would not identify the signing key, and every fallback
+// port must be an allowed port
+func (c *Config) Validate() error {
+	if c.PrivateKey == nil {
+		return errors.New("private key is required")
+	}
+	if c.PublicKey == nil {
+		return errors.New("public key is required")
+	}
+	public, ok := c.PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(c.PublicKey) {
+		return ErrKeyMismatch
+	}
+	// Requests without a port dial the fallback ports, so ALLOWED_CERT_PORTS
+	// must not be bypassed by them
+	if len(c.AllowedCertPorts) > 0 {
+		for _, port := range c.CertFallbackPorts {
+			if !slices.Contains(c.AllowedCertPorts, port) {
+				return fmt.Errorf("CERT_FALLBACK_PORTS port %d is not in ALLOWED_CERT_PORTS", port)
+			}
+		}
+	}
+	return nil
+}
+
+// Redacted returns the effective configuration without secrets, as logged at
+// startup and served by /v1/admin/config. Keys, ADMIN_TOKEN, ALERT_WEBHOOK_URL
+// and the allowed domains themselves are omitted.
+func (c *Config) Redacted() map[string]interface{} {
+	return map[string]interface{}{
+		"port":                            c.Port,
+		"allowed_domains_count":           len(c.AllowedDomains),
+		"signature_lifetime":              c.SignatureLifetime.String(),
+		"signature_lifetime_min":          c.SignatureLifetimeMin.String(),
+		"signature_lifetime_max":          c.SignatureLifetimeMax.String(),
+		"min_ttl":                         c.MinTTL.String(),
+		"ttl_strict":                      c.TTLStrict,
+		"allow_rsa_signing":               c.AllowRSASigning,
+		"dev_mode":                        c.DevMode,
+		"ephemeral_key":                   c.EphemeralKey,
+		"log_level":                       c.LogLevel,
+		"read_timeout":                    c.ReadTimeout.String(),
+		"write_timeout":                   c.WriteTimeout.String(),
+		"idle_timeout":                    c.IdleTimeout.String(),
+		"shutdown_timeout":                c.ShutdownTimeout.String(),
+		"health_canary_domain":            c.HealthCanaryDomain,
+		"health_timeout":                  c.HealthTimeout.String(),
+		"read_header_timeout":             c.ReadHeaderTimeout.String(),
+		"max_header_bytes":                c.MaxHeaderBytes,
+		"grpc_port":                       c.GRPCPort,
+		"max_inflight_requests":           c.MaxInflightRequests,
+		"batch_concurrency":               c.BatchConcurrency,
+		"tls_enabled":                     c.TLSCertFile != "",
+		"server_allowed_sni":              c.ServerAllowedSNI,
+		"cert_dial_timeout":               c.CertDialTimeout.String(),
+		"cert_resolve_timeout":            c.CertResolveTimeout.String(),
+		"cert_connect_timeout":            c.CertConnectTimeout.String(),
+		"cert_handshake_timeout":          c.CertHandshakeTimeout.String(),
+		"cert_cache_ttl":                  c.CertCacheTTL.String(),
+		"cert_cache_ttl_overrides":        len(c.CertCacheTTLOverrides),
+		"cert_san_cache":                  c.CertSANCache,
+		"cert_cache_compress":             c.CertCacheCompress,
+		"cert_cache_file":                 c.CertCacheFile,
+		"cert_disable_resumption":         c.CertDisableResumption,
+		"cert_fast_leaf":                  c.CertFastLeaf,
+		"cert_failure_status":             c.CertFailureStatus,
+		"classify_cert_errors":            c.ClassifyCertErrors,
+		"cert_fallback_ports":             c.CertFallbackPorts,
+		"allowed_cert_ports":              c.AllowedCertPorts,
+		"cache_high_water_mark":           c.CacheHighWaterMark,
+		"cert_source":                     c.CertSource,
+		"cert_dir":                        c.CertDir,
+		"spki_cache_size":                 c.SPKICacheSize,
+		"allow_ip_literals":               c.AllowIPLiterals,
+		"strict_whitelist":                c.StrictWhitelist,
+		"hide_whitelist":                  c.HideWhitelist,
+		"whitelist_by_san":                c.WhitelistBySAN,
+		"reject_mixed_script":             c.RejectMixedScript,
+		"warmup_domains_count":            len(c.WarmupDomains),
+		"warmup_block":                    c.WarmupBlock,
+		"warmup_abort_on_failure":         c.WarmupAbortOnFailure,
+		"validate_domains_on_start":       c.ValidateDomainsOnStart,
+		"require_server_auth_eku":         c.RequireServerAuthEKU,
+		"allow_self_signed":               c.AllowSelfSigned,
+		"identify_leaf":                   c.IdentifyLeaf,
+		"force_leaf_only":                 c.ForceLeafOnly,
+		"exclude_root_from_backup":        c.ExcludeRootFromBackup,
+		"backup_require_intermediate":     c.BackupRequireIntermediate,
+		"backup_max_certs":                c.BackupMaxCerts,
+		"cert_min_remaining_validity":     c.CertMinRemainingValidity.String(),
+		"blocked_issuers":                 c.BlockedIssuers,
+		"expected_pins":                   len(c.ExpectedPins),
+		"fail_on_pin_drift":               c.FailOnPinDrift,
+		"backup_pins":                     len(c.BackupPins),
+		"server_time_claim":               c.ServerTimeClaim,
+		"include_human_times":             c.IncludeHumanTimes,
+		"default_include_backup":          c.DefaultIncludeBackup,
+		"pin_sort":                        c.PinSort,
+		"jws_response_key":                c.JWSResponseKey,
+		"jws_typ":                         c.JWSType,
+		"error_format":                    c.ErrorFormat,
+		"security_headers":                c.SecurityHeaders,
+		"pin_cache_control":               c.PinCacheControl,
+		"matched_rule_claim":              c.MatchedRuleClaim,
+		"log_normalized_domain":           c.LogNormalizedDomain,
+		"canonical_domain_claim":          c.CanonicalDomainClaim,
+		"tls_info_claim":                  c.TLSInfoClaim,
+		"verify_after_sign":               c.VerifyAfterSign,
+		"environment":                     c.Environment,
+		"client_skew_tolerance":           c.ClientSkewTolerance.String(),
+		"enable_pprof":                    c.EnablePprof,
+		"pprof_addr":                      c.PprofAddr,
+		"enable_expvar":                   c.EnableExpvar,
+		"enable_admin_config":             c.EnableAdminConfig,
+		"max_distinct_domains_per_client": c.MaxDistinctDomainsPerClient,
+		"distinct_domains_window":         c.DistinctDomainsWindow.String(),
+		"alert_webhook_enabled":           c.AlertWebhookURL != "",
+		"alert_failure_threshold":         c.AlertFailureThreshold,
+		"report_sink_enabled":             c.ReportSinkURL != "",
+		"report_rate_limit":               c.ReportRateLimit,
+		"log_request_headers":             c.LogRequestHeaders,
+		"max_logged_sans":                 c.MaxLoggedSANs,
+	}
+}
+
+// checkWhitelist removes duplicate whitelist entries and reports duplicates and
+// exact entries that are already covered by a wildcard entry
+// Note that "*.example.com" does not cover "example.com" itself
+func checkWhitelist(entries []string) ([]string, []string) {
+	var warnings []string
+
+	seen := make(map[string]bool, len(entries))
+	unique := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		key := strings.ToLower(entry)
+		if seen[key] {
+			warnings = append(warnings, fmt.Sprintf("duplicate entry %q", entry))
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, entry)
+	}
+
+	var wildcards []string
+	for _, entry := range unique {
+		if strings.HasPrefix(entry, "*.") {
+			wildcards = append(wildcards, entry)
+		}
+	}
+
+	validator := domain.NewValidator(wildcards)
+	for _, entry := range unique {
+		if !strings.HasPrefix(entry, "*.") && validator.IsAllowed(entry) {
+			warnings = append(warnings, fmt.Sprintf("entry %q is already covered by a wildcard entry", entry))
+		}
+	}
+
+	return unique, warnings
+}
+
 // getEnvString retrieves a string environment variable with a default value
 func getEnvString(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -147,6 +822,83 @@ func getEnvInt(key string, defaultValue int) (int, error) {
 	return value, nil
 }
 
+// getEnvPorts retrieves a comma-separated list of ports (nil if unset)
+func getEnvPorts(key string) ([]int, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil, nil
+	}
+	var ports []int
+	for _, part := range strings.Split(valueStr, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("port %d out of range", port)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// getEnvDurationMap retrieves a comma-separated list of domain=duration pairs
+// keyed by lowercased domain (nil if unset)
+func getEnvDurationMap(key string) (map[string]time.Duration, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil, nil
+	}
+	durations := make(map[string]time.Duration)
+	for _, part := range strings.Split(valueStr, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, durationStr, found := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !found || name == "" {
+			return nil, fmt.Errorf("expected domain=duration, got %q", part)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(durationStr))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if duration < 0 {
+			return nil, fmt.Errorf("%s: negative duration %s", name, duration)
+		}
+		durations[name] = duration
+	}
+	return durations, nil
+}
+
+// getEnvPinMap parses a comma-separated list of domain=pin pairs, where pin is a
+// base64 SHA-256 hash. Domains are lowercased and may be repeated to expect any
+// of several pins (e.g. during a rotation).
+func getEnvPinMap(key string) (map[string][]string, error) {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return nil, nil
+	}
+	pins := make(map[string][]string)
+	for _, part := range strings.Split(valueStr, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		// Split on the first "=" only: base64 hashes end with "=" padding
+		name, pin, found := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		pin = strings.TrimSpace(pin)
+		if !found || name == "" {
+			return nil, fmt.Errorf("expected domain=pin, got %q", part)
+		}
+		if hash, err := base64.StdEncoding.DecodeString(pin); err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%s: %q is not a base64 SHA-256 hash", name, pin)
+		}
+		pins[name] = append(pins[name], pin)
+	}
+	return pins, nil
+}
+
 // getEnvDuration retrieves a duration environment variable with a default value
 func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
 	valueStr := os.Getenv(key)
@@ -178,8 +930,13 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 }
 
-// parsePrivateKey parses an ECDSA P-256 private key from PEM format
-func parsePrivateKey(pemData string) (*ecdsa.PrivateKey, error) {
+// acceptedKeyTypes lists the signing keys parsePrivateKey accepts, for its errors
+const acceptedKeyTypes = "expected ECDSA P-256, P-384 or P-521, or RSA with ALLOW_RSA_SIGNING=true"
+
+// parsePrivateKey parses an ECDSA, or with allowRSA an RSA, private key from PEM format
+// Keys on curves other than P-256, P-384 and P-521 and RSA keys shorter than
+// 2048 bits are rejected
+func parsePrivateKey(pemData string, allowRSA bool) (crypto.Signer, error) {
 	block, _ := pem.Decode([]byte(pemData))
 	if block == nil {
 		return nil, errors.New("failed to decode PEM block")
@@ -188,17 +945,49 @@ func parsePrivateKey(pemData string) (*ecdsa.PrivateKey, error) {
 	// Try parsing as PKCS8 (preferred format)
 	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err == nil {
-		if ecdsaKey, ok := key.(*ecdsa.PrivateKey); ok {
-			return ecdsaKey, nil
+		switch key := key.(type) {
+		case *ecdsa.PrivateKey:
+			return key, validateCurve(key)
+		case *rsa.PrivateKey:
+			return validateRSA(key, allowRSA)
 		}
-		return nil, errors.New("private key is not ECDSA")
+		return nil, fmt.Errorf("unsupported private key type %T (%s)", key, acceptedKeyTypes)
 	}
 
 	// Try parsing as SEC1 EC private key
 	ecKey, err := x509.ParseECPrivateKey(block.Bytes)
 	if err == nil {
-		return ecKey, nil
+		return ecKey, validateCurve(ecKey)
+	}
+
+	// Try parsing as PKCS1 RSA private key
+	if rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(block.Bytes); rsaErr == nil {
+		return validateRSA(rsaKey, allowRSA)
 	}
 
-	return nil, fmt.Errorf("unsupported private key format (expected ECDSA P-256): %w", err)
+	return nil, fmt.Errorf("unsupported private key format (%s): %w", acceptedKeyTypes, err)
+}
+
+// minRSAKeyBits is the shortest RSA signing key accepted
+const minRSAKeyBits = 2048
+
+// validateRSA accepts an RSA key only when RSA signing is enabled and the key is long enough
+func validateRSA(key *rsa.PrivateKey, allowRSA bool) (crypto.Signer, error) {
+	if !allowRSA {
+		return nil, errors.New("RSA private keys require ALLOW_RSA_SIGNING=true")
+	}
+	if bits := key.N.BitLen(); bits < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key too short: %d bits (minimum %d)", bits, minRSAKeyBits)
+	}
+	return key, nil
+}
+
+// validateCurve rejects keys on curves that JWS clients cannot verify
+func validateCurve(key *ecdsa.PrivateKey) error {
+	switch key.Curve {
+	case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		return nil
+	default:
+		return fmt.Errorf("unsupported elliptic curve %s (supported: P-256, P-384, P-521)", key.Curve.Params().Name)
+	}
 }
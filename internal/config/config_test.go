@@ -2,32 +2,42 @@ package config
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
 
-func TestLoad_Success(t *testing.T) {
-	// Generate a test ECDSA P-256 key pair
+// generateTestKeyPEM returns a PKCS8 PEM encoded ECDSA P-256 private key
+func generateTestKeyPEM(t *testing.T) []byte {
+	t.Helper()
+
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		t.Fatalf("Failed to generate key: %v", err)
 	}
 
-	// Marshal the private key to PKCS8 format
 	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
 	if err != nil {
 		t.Fatalf("Failed to marshal private key: %v", err)
 	}
 
-	privateKeyPEM := pem.EncodeToMemory(&pem.Block{
+	return pem.EncodeToMemory(&pem.Block{
 		Type:  "PRIVATE KEY",
 		Bytes: privateKeyBytes,
 	})
+}
+
+func TestLoad_Success(t *testing.T) {
+	privateKeyPEM := generateTestKeyPEM(t)
 
 	// Set up environment variables
 	os.Setenv("PORT", "9090")
@@ -118,6 +128,92 @@ func TestLoad_MissingPrivateKey(t *testing.T) {
 	}
 }
 
+func TestLoad_SignatureLifetimeBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		lifetime string
+		min      string
+		max      string
+		wantMin  time.Duration
+		wantMax  time.Duration
+		wantErr  bool
+	}{
+		{name: "defaults", lifetime: "1h", wantMin: time.Minute, wantMax: time.Hour},
+		{name: "short lifetime caps default min", lifetime: "30s", wantMin: 30 * time.Second, wantMax: 30 * time.Second},
+		{name: "explicit max caps default min", lifetime: "1h", max: "20s", wantMin: 20 * time.Second, wantMax: 20 * time.Second},
+		{name: "explicit min raises default max", lifetime: "30s", min: "45s", wantMin: 45 * time.Second, wantMax: 45 * time.Second},
+		{name: "explicit bounds conflict", lifetime: "1h", min: "10m", max: "5m", wantErr: true},
+	}
+
+	keyPEM := generateTestKeyPEM(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOWED_DOMAINS", "example.com")
+			t.Setenv("PRIVATE_KEY_PEM", string(keyPEM))
+			t.Setenv("SIGNATURE_LIFETIME", tt.lifetime)
+			t.Setenv("SIGNATURE_LIFETIME_MIN", tt.min)
+			t.Setenv("SIGNATURE_LIFETIME_MAX", tt.max)
+
+			cfg, err := Load()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected error for SIGNATURE_LIFETIME_MIN above SIGNATURE_LIFETIME_MAX")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if cfg.SignatureLifetimeMin != tt.wantMin || cfg.SignatureLifetimeMax != tt.wantMax {
+				t.Errorf("Expected ttl bounds [%s, %s], got [%s, %s]",
+					tt.wantMin, tt.wantMax, cfg.SignatureLifetimeMin, cfg.SignatureLifetimeMax)
+			}
+		})
+	}
+}
+
+func TestLoad_DevModeEphemeralKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+		wantErr     bool
+	}{
+		{"dev", "dev", false},
+		{"prod", "prod", true},
+		{"unset", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("ALLOWED_DOMAINS", "example.com")
+			os.Setenv("DEV_MODE", "true")
+			os.Setenv("ENVIRONMENT", tt.environment)
+			defer func() {
+				os.Unsetenv("ALLOWED_DOMAINS")
+				os.Unsetenv("DEV_MODE")
+				os.Unsetenv("ENVIRONMENT")
+			}()
+
+			cfg, err := Load()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "ENVIRONMENT=dev") {
+					t.Fatalf("Expected DEV_MODE to be refused, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected dev mode to boot without a key, got %v", err)
+			}
+			if !cfg.EphemeralKey {
+				t.Error("Expected EphemeralKey to be set")
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("Expected ephemeral key pair to validate, got %v", err)
+			}
+		})
+	}
+}
+
 func TestLoad_InvalidPort(t *testing.T) {
 	os.Setenv("PORT", "invalid")
 	os.Setenv("ALLOWED_DOMAINS", "example.com")
@@ -172,4 +268,710 @@ func TestLoad_Defaults(t *testing.T) {
 	if cfg.SignatureLifetime != 1*time.Hour {
 		t.Errorf("Expected default signature lifetime 1h, got %v", cfg.SignatureLifetime)
 	}
+
+	if cfg.GRPCPort != 0 {
+		t.Errorf("Expected gRPC server to be disabled by default, got port %d", cfg.GRPCPort)
+	}
+
+	if cfg.SPKICacheSize != 1024 {
+		t.Errorf("Expected default SPKI cache size 1024, got %d", cfg.SPKICacheSize)
+	}
+
+	if !cfg.RequireServerAuthEKU {
+		t.Error("Expected serverAuth EKU to be required by default")
+	}
+
+	if cfg.JWSResponseKey != "jws" {
+		t.Errorf("Expected default JWS response key 'jws', got %q", cfg.JWSResponseKey)
+	}
+
+	if cfg.JWSType != "JWT" {
+		t.Errorf("Expected default JWS typ header 'JWT', got %q", cfg.JWSType)
+	}
+
+	if !cfg.SecurityHeaders {
+		t.Error("Expected security headers to be enabled by default")
+	}
+
+	if cfg.MinTTL != time.Minute {
+		t.Errorf("Expected default MIN_TTL 1m, got %v", cfg.MinTTL)
+	}
+
+	if cfg.PinSort != PinSortChain {
+		t.Errorf("Expected default PIN_SORT %q, got %q", PinSortChain, cfg.PinSort)
+	}
+}
+
+func TestLoad_WhitelistDuplicates(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com, api.example.org, *.example.org, Example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := []string{"example.com", "api.example.org", "*.example.org"}
+	if len(cfg.AllowedDomains) != len(expected) {
+		t.Fatalf("Expected deduplicated domains %v, got %v", expected, cfg.AllowedDomains)
+	}
+	for i, d := range expected {
+		if cfg.AllowedDomains[i] != d {
+			t.Errorf("Expected domain %q at %d, got %q", d, i, cfg.AllowedDomains[i])
+		}
+	}
+
+	// One duplicate and one entry covered by a wildcard
+	if len(cfg.WhitelistWarnings) != 2 {
+		t.Errorf("Expected 2 whitelist warnings, got %v", cfg.WhitelistWarnings)
+	}
+}
+
+func TestLoad_WhitelistApexAndWildcard(t *testing.T) {
+	// The wildcard does not match the apex domain, so this is not an overlap
+	os.Setenv("ALLOWED_DOMAINS", "example.com,*.example.com")
+	os.Setenv("STRICT_WHITELIST", "true")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("STRICT_WHITELIST")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.WhitelistWarnings) != 0 {
+		t.Errorf("Expected no whitelist warnings, got %v", cfg.WhitelistWarnings)
+	}
+}
+
+func TestLoad_StrictWhitelist(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com,example.com")
+	os.Setenv("STRICT_WHITELIST", "true")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("STRICT_WHITELIST")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+	}()
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Expected error for duplicate entries with STRICT_WHITELIST")
+	}
+	if !strings.Contains(err.Error(), "duplicate entry") {
+		t.Errorf("Expected error to mention the duplicate entry, got: %v", err)
+	}
+}
+
+func TestLoad_RSAKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	shortKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	pkcs8PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+	pkcs1PEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	shortPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(shortKey)})
+
+	tests := []struct {
+		name     string
+		keyPEM   []byte
+		allowRSA string
+		wantErr  string
+	}{
+		{"pkcs8_without_flag", pkcs8PEM, "", "ALLOW_RSA_SIGNING"},
+		{"pkcs1_without_flag", pkcs1PEM, "false", "ALLOW_RSA_SIGNING"},
+		{"pkcs8", pkcs8PEM, "true", ""},
+		{"pkcs1", pkcs1PEM, "true", ""},
+		{"too_short", shortPEM, "true", "RSA key too short"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("ALLOWED_DOMAINS", "example.com")
+			os.Setenv("PRIVATE_KEY_PEM", string(tt.keyPEM))
+			os.Setenv("ALLOW_RSA_SIGNING", tt.allowRSA)
+			defer func() {
+				os.Unsetenv("ALLOWED_DOMAINS")
+				os.Unsetenv("PRIVATE_KEY_PEM")
+				os.Unsetenv("ALLOW_RSA_SIGNING")
+			}()
+
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if _, ok := cfg.PublicKey.(*rsa.PublicKey); !ok {
+				t.Errorf("Expected an RSA public key, got %T", cfg.PublicKey)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("Expected RSA config to validate, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoad_UnsupportedCurve(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: privateKeyBytes,
+	})
+
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(privateKeyPEM))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+	}()
+
+	_, err = Load()
+	if err == nil {
+		t.Fatal("Expected error for P-224 private key")
+	}
+	if !strings.Contains(err.Error(), "unsupported elliptic curve P-224") {
+		t.Errorf("Expected error naming the P-224 curve, got: %v", err)
+	}
+}
+
+func TestParsePrivateKey_UnsupportedKeyType(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	edKeyBytes, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		block *pem.Block
+	}{
+		{"ed25519", &pem.Block{Type: "PRIVATE KEY", Bytes: edKeyBytes}},
+		{"garbage", &pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not a key")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePrivateKey(string(pem.EncodeToMemory(tt.block)), false)
+			if err == nil {
+				t.Fatal("Expected error for unsupported private key")
+			}
+			// The error lists every accepted key type
+			for _, accepted := range []string{"P-256", "P-384", "P-521", "RSA with ALLOW_RSA_SIGNING"} {
+				if !strings.Contains(err.Error(), accepted) {
+					t.Errorf("Expected error to mention %q, got: %v", accepted, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_CertFallbackPorts(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("CERT_FALLBACK_PORTS")
+	}()
+
+	t.Setenv("ALLOWED_CERT_PORTS", "443,8443")
+	os.Setenv("CERT_FALLBACK_PORTS", "443, 8443")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.CertFallbackPorts) != 2 || cfg.CertFallbackPorts[0] != 443 || cfg.CertFallbackPorts[1] != 8443 {
+		t.Errorf("Expected fallback ports [443 8443], got %v", cfg.CertFallbackPorts)
+	}
+
+	for _, invalid := range []string{"443,abc", "0", "70000"} {
+		os.Setenv("CERT_FALLBACK_PORTS", invalid)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for CERT_FALLBACK_PORTS=%q", invalid)
+		}
+	}
+}
+
+func TestLoad_CertFailureStatus(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("CERT_FAILURE_STATUS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.CertFailureStatus != 422 || cfg.ClassifyCertErrors {
+		t.Errorf("Expected status 422 without classification by default, got %d / %v", cfg.CertFailureStatus, cfg.ClassifyCertErrors)
+	}
+
+	os.Setenv("CERT_FAILURE_STATUS", "503")
+	if cfg, err := Load(); err != nil || cfg.CertFailureStatus != 503 {
+		t.Errorf("Expected CERT_FAILURE_STATUS 503 to be accepted, got %v", err)
+	}
+
+	for _, invalid := range []string{"500", "abc"} {
+		os.Setenv("CERT_FAILURE_STATUS", invalid)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for CERT_FAILURE_STATUS=%q", invalid)
+		}
+	}
+}
+
+func TestLoad_BlockedIssuers(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("BLOCKED_ISSUERS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.BlockedIssuers) != 0 {
+		t.Errorf("Expected no blocked issuers by default, got %v", cfg.BlockedIssuers)
+	}
+
+	os.Setenv("BLOCKED_ISSUERS", " Distrusted CA , ,47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := []string{"Distrusted CA", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}
+	if len(cfg.BlockedIssuers) != len(expected) || cfg.BlockedIssuers[0] != expected[0] || cfg.BlockedIssuers[1] != expected[1] {
+		t.Errorf("Expected blocked issuers %v, got %v", expected, cfg.BlockedIssuers)
+	}
+}
+
+func TestLoad_AllowedCertPorts(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("ALLOWED_CERT_PORTS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.AllowedCertPorts) != 1 || cfg.AllowedCertPorts[0] != 443 {
+		t.Errorf("Expected default allowed ports [443], got %v", cfg.AllowedCertPorts)
+	}
+
+	os.Setenv("ALLOWED_CERT_PORTS", "443,587")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.AllowedCertPorts) != 2 || cfg.AllowedCertPorts[1] != 587 {
+		t.Errorf("Expected allowed ports [443 587], got %v", cfg.AllowedCertPorts)
+	}
+
+	os.Setenv("ALLOWED_CERT_PORTS", "443,abc")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for invalid ALLOWED_CERT_PORTS")
+	}
+
+	// Fallback ports are dialed without a port parameter and must be allowed too
+	t.Setenv("CERT_FALLBACK_PORTS", "443,8443")
+	os.Setenv("ALLOWED_CERT_PORTS", "443")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "8443") {
+		t.Errorf("Expected error for fallback port 8443 not in ALLOWED_CERT_PORTS, got: %v", err)
+	}
+	os.Setenv("ALLOWED_CERT_PORTS", "443,8443")
+	if _, err := Load(); err != nil {
+		t.Errorf("Expected allowed fallback ports to load, got: %v", err)
+	}
+}
+
+func TestLoad_CertCacheTTLOverrides(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("CERT_CACHE_TTL_OVERRIDES")
+	}()
+
+	os.Setenv("CERT_CACHE_TTL_OVERRIDES", "CDN.example.com=30s, static.example.com = 1h,")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.CertCacheTTLOverrides) != 2 ||
+		cfg.CertCacheTTLOverrides["cdn.example.com"] != 30*time.Second ||
+		cfg.CertCacheTTLOverrides["static.example.com"] != time.Hour {
+		t.Errorf("Unexpected overrides: %v", cfg.CertCacheTTLOverrides)
+	}
+
+	for _, invalid := range []string{"cdn.example.com", "=30s", "cdn.example.com=soon", "cdn.example.com=-1s"} {
+		os.Setenv("CERT_CACHE_TTL_OVERRIDES", invalid)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for CERT_CACHE_TTL_OVERRIDES %q", invalid)
+		}
+	}
+}
+
+func TestLoad_ExpectedPins(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("EXPECTED_PINS")
+	}()
+
+	pinA := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	pinB := "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	os.Setenv("EXPECTED_PINS", "API.example.com="+pinA+", api.example.com = "+pinB+",login.example.com="+pinB)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.ExpectedPins) != 2 ||
+		len(cfg.ExpectedPins["api.example.com"]) != 2 ||
+		cfg.ExpectedPins["api.example.com"][0] != pinA ||
+		cfg.ExpectedPins["login.example.com"][0] != pinB {
+		t.Errorf("Unexpected expected pins: %v", cfg.ExpectedPins)
+	}
+
+	for _, invalid := range []string{"api.example.com", "=" + pinA, "api.example.com=notbase64!", "api.example.com=YWJj"} {
+		os.Setenv("EXPECTED_PINS", invalid)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for EXPECTED_PINS %q", invalid)
+		}
+	}
+}
+
+func TestLoad_BackupPins(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("BACKUP_PINS")
+	}()
+
+	pin := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	os.Setenv("BACKUP_PINS", "Example.com="+pin)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.BackupPins["example.com"]) != 1 || cfg.BackupPins["example.com"][0] != pin {
+		t.Errorf("Unexpected backup pins: %v", cfg.BackupPins)
+	}
+
+	os.Setenv("BACKUP_PINS", "example.com=YWJj")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for a BACKUP_PINS entry that is not a SHA-256 hash")
+	}
+}
+
+func TestLoad_CertSource(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("CERT_SOURCE")
+		os.Unsetenv("CERT_DIR")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.CertSource != CertSourceNetwork {
+		t.Errorf("Expected default cert source %q, got %q", CertSourceNetwork, cfg.CertSource)
+	}
+
+	os.Setenv("CERT_SOURCE", "disk")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for CERT_SOURCE=disk without CERT_DIR")
+	}
+
+	os.Setenv("CERT_DIR", "/etc/pinning/certs")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.CertSource != CertSourceDisk || cfg.CertDir != "/etc/pinning/certs" {
+		t.Errorf("Expected disk source in /etc/pinning/certs, got %q in %q", cfg.CertSource, cfg.CertDir)
+	}
+
+	os.Setenv("CERT_SOURCE", "ftp")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for unknown CERT_SOURCE")
+	}
+}
+
+func TestLoad_AllowedDomainsEmptyEntries(t *testing.T) {
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+	}()
+
+	for _, value := range []string{",", " , ", ",,  ,"} {
+		os.Setenv("ALLOWED_DOMAINS", value)
+		_, err := Load()
+		if err == nil {
+			t.Errorf("Expected error for ALLOWED_DOMAINS=%q", value)
+			continue
+		}
+		if !strings.Contains(err.Error(), "ALLOWED_DOMAINS contains no domains") {
+			t.Errorf("Expected empty whitelist error for %q, got: %v", value, err)
+		}
+	}
+
+	os.Setenv("ALLOWED_DOMAINS", " example.com,, *.example.org , ")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := []string{"example.com", "*.example.org"}
+	if len(cfg.AllowedDomains) != len(expected) {
+		t.Fatalf("Expected domains %v, got %v", expected, cfg.AllowedDomains)
+	}
+	for i, d := range expected {
+		if cfg.AllowedDomains[i] != d {
+			t.Errorf("Expected domain %q at %d, got %q", d, i, cfg.AllowedDomains[i])
+		}
+	}
+}
+
+func TestLoad_ServerTLS(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("TLS_CERT_FILE")
+		os.Unsetenv("TLS_KEY_FILE")
+		os.Unsetenv("SERVER_ALLOWED_SNI")
+	}()
+
+	// SNI allowlist only applies when serving TLS directly
+	os.Setenv("SERVER_ALLOWED_SNI", "pins.example.com")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for SERVER_ALLOWED_SNI without TLS")
+	}
+
+	// Certificate and key must be set together
+	os.Setenv("TLS_CERT_FILE", "/etc/dynapins/tls.crt")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for TLS_CERT_FILE without TLS_KEY_FILE")
+	}
+
+	os.Setenv("TLS_KEY_FILE", "/etc/dynapins/tls.key")
+	os.Setenv("SERVER_ALLOWED_SNI", "pins.example.com, api.example.com")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.ServerAllowedSNI) != 2 || cfg.ServerAllowedSNI[1] != "api.example.com" {
+		t.Errorf("Expected 2 allowed SNI names, got %v", cfg.ServerAllowedSNI)
+	}
+}
+
+func TestValidate_KeyPair(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	// A copy of the matching public key is accepted
+	publicKey := privateKey.PublicKey
+	cfg := &Config{PrivateKey: privateKey, PublicKey: &publicKey}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected matching key pair to validate, got: %v", err)
+	}
+
+	cfg.PublicKey = &otherKey.PublicKey
+	if err := cfg.Validate(); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch, got: %v", err)
+	}
+
+	cfg.PublicKey = nil
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for missing public key")
+	}
+}
+
+func TestLoad_AdminConfigRequiresToken(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	os.Setenv("ENABLE_ADMIN_CONFIG", "true")
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("ENABLE_ADMIN_CONFIG")
+		os.Unsetenv("ADMIN_TOKEN")
+	}()
+
+	if _, err := Load(); err == nil {
+		t.Error("Expected error when ENABLE_ADMIN_CONFIG is set without ADMIN_TOKEN")
+	}
+
+	os.Setenv("ADMIN_TOKEN", "admin-secret")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !cfg.EnableAdminConfig || cfg.AdminToken != "admin-secret" {
+		t.Errorf("Expected admin config enabled with token, got %v / %q", cfg.EnableAdminConfig, cfg.AdminToken)
+	}
+}
+
+func TestLoad_AlertWebhook(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("ALERT_WEBHOOK_URL")
+		os.Unsetenv("ALERT_FAILURE_THRESHOLD")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.AlertWebhookURL != "" || cfg.AlertFailureThreshold != 5 {
+		t.Errorf("Expected alerts disabled with threshold 5 by default, got %q / %d", cfg.AlertWebhookURL, cfg.AlertFailureThreshold)
+	}
+
+	for _, invalid := range []string{"not a url", "ftp://alerts.example.com", "https://"} {
+		os.Setenv("ALERT_WEBHOOK_URL", invalid)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected error for ALERT_WEBHOOK_URL %q", invalid)
+		}
+	}
+
+	os.Setenv("ALERT_WEBHOOK_URL", "https://alerts.example.com/hook")
+	os.Setenv("ALERT_FAILURE_THRESHOLD", "0")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for ALERT_FAILURE_THRESHOLD 0")
+	}
+}
+
+func TestLoad_ReportSink(t *testing.T) {
+	os.Setenv("ALLOWED_DOMAINS", "example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(generateTestKeyPEM(t)))
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("REPORT_SINK_URL")
+		os.Unsetenv("REPORT_RATE_LIMIT")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.ReportSinkURL != "" || cfg.ReportRateLimit != 10 {
+		t.Errorf("Expected no sink and a limit of 10 by default, got %q / %d", cfg.ReportSinkURL, cfg.ReportRateLimit)
+	}
+
+	os.Setenv("REPORT_SINK_URL", "ftp://reports.example.com")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for a non-HTTP REPORT_SINK_URL")
+	}
+
+	os.Setenv("REPORT_SINK_URL", "https://reports.example.com/ingest")
+	os.Setenv("REPORT_RATE_LIMIT", "-1")
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for a negative REPORT_RATE_LIMIT")
+	}
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	keyPEM := generateTestKeyPEM(t)
+	os.Setenv("ALLOWED_DOMAINS", "example.com,api.example.com")
+	os.Setenv("PRIVATE_KEY_PEM", string(keyPEM))
+	os.Setenv("SIGNATURE_LIFETIME", "30m")
+	os.Setenv("HIDE_WHITELIST", "true")
+	os.Setenv("ENABLE_ADMIN_CONFIG", "true")
+	os.Setenv("ADMIN_TOKEN", "admin-secret")
+	defer func() {
+		os.Unsetenv("ALLOWED_DOMAINS")
+		os.Unsetenv("PRIVATE_KEY_PEM")
+		os.Unsetenv("SIGNATURE_LIFETIME")
+		os.Unsetenv("HIDE_WHITELIST")
+		os.Unsetenv("ENABLE_ADMIN_CONFIG")
+		os.Unsetenv("ADMIN_TOKEN")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	redacted := cfg.Redacted()
+
+	if redacted["allowed_domains_count"] != 2 {
+		t.Errorf("Expected allowed_domains_count 2, got %v", redacted["allowed_domains_count"])
+	}
+	if redacted["signature_lifetime"] != "30m0s" {
+		t.Errorf("Expected signature_lifetime 30m0s, got %v", redacted["signature_lifetime"])
+	}
+	if redacted["hide_whitelist"] != true || redacted["enable_admin_config"] != true {
+		t.Errorf("Expected flags to match the loaded config, got %v / %v", redacted["hide_whitelist"], redacted["enable_admin_config"])
+	}
+	if redacted["cert_cache_ttl"] != cfg.CertCacheTTL.String() {
+		t.Errorf("Expected applied default cert_cache_ttl %s, got %v", cfg.CertCacheTTL, redacted["cert_cache_ttl"])
+	}
+
+	for key, value := range redacted {
+		text := strings.ToLower(key + "=" + strings.TrimSpace(fmt.Sprint(value)))
+		if strings.Contains(text, "admin-secret") || strings.Contains(text, "private") || strings.Contains(text, "example.com") {
+			t.Errorf("Redacted config leaks a secret or domain: %s", text)
+		}
+	}
 }
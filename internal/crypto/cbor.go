@@ -0,0 +1,290 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// This file implements the subset of CBOR (RFC 8949) needed for COSE tokens:
+// integers, floats, byte and text strings, arrays, maps, tags and the simple
+// values false, true and null. Maps are encoded with their keys in the
+// deterministic (bytewise lexicographic) order of RFC 8949 section 4.2.1.
+
+// CBOR major types
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTagged   = 6
+	cborSimple   = 7
+)
+
+// maxCBORDepth bounds the nesting of decoded items
+const maxCBORDepth = 16
+
+// cborTag is a tagged CBOR item
+type cborTag struct {
+	number  uint64
+	content interface{}
+}
+
+// errCBORTruncated is returned when an item runs past the end of its input
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// marshalCBOR encodes v as CBOR
+func marshalCBOR(v interface{}) ([]byte, error) {
+	return appendCBOR(nil, v)
+}
+
+// appendCBORHead appends the initial byte and argument of an item
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), n)
+	}
+}
+
+// appendCBORInt appends a signed integer
+func appendCBORInt(b []byte, n int64) []byte {
+	if n < 0 {
+		return appendCBORHead(b, cborNegative, uint64(-(n + 1)))
+	}
+	return appendCBORHead(b, cborUnsigned, uint64(n))
+}
+
+// appendCBOR appends the encoding of v
+func appendCBOR(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, cborSimple<<5|22), nil
+	case bool:
+		if v {
+			return append(b, cborSimple<<5|21), nil
+		}
+		return append(b, cborSimple<<5|20), nil
+	case int:
+		return appendCBORInt(b, int64(v)), nil
+	case int64:
+		return appendCBORInt(b, v), nil
+	case uint64:
+		return appendCBORHead(b, cborUnsigned, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, cborSimple<<5|27), math.Float64bits(v)), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return appendCBORInt(b, n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("cbor: invalid number %q", v)
+		}
+		return appendCBOR(b, f)
+	case string:
+		return append(appendCBORHead(b, cborText, uint64(len(v))), v...), nil
+	case []byte:
+		return append(appendCBORHead(b, cborBytes, uint64(len(v))), v...), nil
+	case []string:
+		b = appendCBORHead(b, cborArray, uint64(len(v)))
+		for _, s := range v {
+			b = append(appendCBORHead(b, cborText, uint64(len(s))), s...)
+		}
+		return b, nil
+	case []interface{}:
+		b = appendCBORHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = appendCBOR(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		entries := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			entries[key] = value
+		}
+		return appendCBORMap(b, entries)
+	case map[int]interface{}:
+		entries := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			entries[key] = value
+		}
+		return appendCBORMap(b, entries)
+	case cborTag:
+		return appendCBOR(appendCBORHead(b, cborTagged, v.number), v.content)
+	default:
+		return nil, fmt.Errorf("cbor: unsupported type %T", v)
+	}
+}
+
+// appendCBORMap appends a map with its keys in deterministic order
+func appendCBORMap(b []byte, entries map[interface{}]interface{}) ([]byte, error) {
+	type encodedEntry struct {
+		key   []byte
+		value interface{}
+	}
+	encoded := make([]encodedEntry, 0, len(entries))
+	for key, value := range entries {
+		k, err := marshalCBOR(key)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, encodedEntry{key: k, value: value})
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i].key, encoded[j].key) < 0
+	})
+
+	b = appendCBORHead(b, cborMap, uint64(len(encoded)))
+	for _, entry := range encoded {
+		var err error
+		b = append(b, entry.key...)
+		if b, err = appendCBOR(b, entry.value); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// unmarshalCBOR decodes a single CBOR item that must span all of data. Unsigned
+// and negative integers decode to int64 (or uint64 above math.MaxInt64), maps to
+// map[interface{}]interface{}, arrays to []interface{} and tags to cborTag.
+func unmarshalCBOR(data []byte) (interface{}, error) {
+	v, rest, err := decodeCBOR(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("cbor: trailing data after item")
+	}
+	return v, nil
+}
+
+// decodeCBORHead reads the initial byte and argument of an item
+func decodeCBORHead(data []byte) (major byte, info byte, n uint64, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, nil, errCBORTruncated
+	}
+	major, info = data[0]>>5, data[0]&0x1f
+	data = data[1:]
+	switch {
+	case info < 24:
+		return major, info, uint64(info), data, nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < size {
+			return 0, 0, 0, nil, errCBORTruncated
+		}
+		for _, c := range data[:size] {
+			n = n<<8 | uint64(c)
+		}
+		return major, info, n, data[size:], nil
+	default:
+		return 0, 0, 0, nil, fmt.Errorf("cbor: unsupported additional information %d", info)
+	}
+}
+
+// decodeCBOR decodes the item at the start of data and returns the remainder
+func decodeCBOR(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: nesting too deep")
+	}
+	major, info, n, rest, err := decodeCBORHead(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		if n > math.MaxInt64 {
+			return n, rest, nil
+		}
+		return int64(n), rest, nil
+	case cborNegative:
+		if n > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: negative integer out of range")
+		}
+		return -1 - int64(n), rest, nil
+	case cborBytes, cborText:
+		if n > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		if major == cborText {
+			return string(rest[:n]), rest[n:], nil
+		}
+		return append([]byte{}, rest[:n]...), rest[n:], nil
+	case cborArray:
+		// Every item takes at least one byte
+		if n > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			if item, rest, err = decodeCBOR(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case cborMap:
+		if n > uint64(len(rest))/2 {
+			return nil, nil, errCBORTruncated
+		}
+		entries := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var key, value interface{}
+			if key, rest, err = decodeCBOR(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, uint64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+			if value, rest, err = decodeCBOR(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, rest, nil
+	case cborTagged:
+		content, rest, err := decodeCBOR(rest, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cborTag{number: n, content: content}, rest, nil
+	default:
+		switch info {
+		case 20:
+			return false, rest, nil
+		case 21:
+			return true, rest, nil
+		case 22:
+			return nil, rest, nil
+		case 26:
+			return float64(math.Float32frombits(uint32(n))), rest, nil
+		case 27:
+			return math.Float64frombits(n), rest, nil
+		default:
+			return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package crypto
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	_ "crypto/sha256" // SHA-256 for ES256
+	_ "crypto/sha512" // SHA-384 and SHA-512 for ES384 and ES512
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// COSE (RFC 9052) constants used for COSE_Sign1 tokens
+const (
+	coseSign1Tag     = 18  // CBOR tag of a COSE_Sign1 structure
+	coseHeaderAlg    = 1   // alg header parameter
+	coseHeaderKeyID  = 4   // kid header parameter
+	coseAlgES256     = -7  // ECDSA with SHA-256 on P-256
+	coseAlgES384     = -35 // ECDSA with SHA-384 on P-384
+	coseAlgES512     = -36 // ECDSA with SHA-512 on P-521
+	coseSign1Context = "Signature1"
+)
+
+// coseAlgorithm describes the COSE ECDSA algorithm used for keys on one curve
+type coseAlgorithm struct {
+	id             int
+	name           string
+	hash           stdcrypto.Hash
+	coordinateSize int // size of r and s in the raw signature
+}
+
+// coseAlgorithmFor returns the COSE algorithm matching the JWS algorithm that
+// SignatureAlgorithm picks for publicKey. Only ECDSA keys are supported.
+func coseAlgorithmFor(publicKey stdcrypto.PublicKey) (coseAlgorithm, error) {
+	ecKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return coseAlgorithm{}, fmt.Errorf("unsupported COSE key type %T (ECDSA required)", publicKey)
+	}
+	switch ecKey.Curve {
+	case elliptic.P256():
+		return coseAlgorithm{id: coseAlgES256, name: "ES256", hash: stdcrypto.SHA256, coordinateSize: 32}, nil
+	case elliptic.P384():
+		return coseAlgorithm{id: coseAlgES384, name: "ES384", hash: stdcrypto.SHA384, coordinateSize: 48}, nil
+	case elliptic.P521():
+		return coseAlgorithm{id: coseAlgES512, name: "ES512", hash: stdcrypto.SHA512, coordinateSize: 66}, nil
+	default:
+		return coseAlgorithm{}, fmt.Errorf("unsupported elliptic curve %s", ecKey.Curve.Params().Name)
+	}
+}
+
+// digest hashes data with the algorithm's hash function
+func (a coseAlgorithm) digest(data []byte) []byte {
+	h := a.hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// CreateCOSE creates a COSE_Sign1 token carrying the same claims as CreateJWS,
+// for clients that prefer CBOR. It is signed with ES256, ES384 or ES512 for
+// ECDSA keys on P-256, P-384 or P-521; RSA keys are not supported.
+func CreateCOSE(privateKey stdcrypto.Signer, keyID string, domain string, pins []string, ttl time.Duration) ([]byte, error) {
+	return CreateCOSEWithClaims(privateKey, keyID, domain, pins, ttl, nil)
+}
+
+// CreateCOSEWithClaims creates a COSE_Sign1 token like CreateCOSE and adds the
+// given extra claims to the payload. Extra claims cannot override the standard claims.
+func CreateCOSEWithClaims(privateKey stdcrypto.Signer, keyID string, domain string, pins []string, ttl time.Duration, extraClaims map[string]interface{}) ([]byte, error) {
+	alg, err := coseAlgorithmFor(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := buildToken(domain, pins, ttl, extraClaims)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := coseClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := marshalCBOR(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode claims: %w", err)
+	}
+
+	protected, err := marshalCBOR(map[int]interface{}{
+		coseHeaderAlg:   alg.id,
+		coseHeaderKeyID: []byte(keyID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode protected header: %w", err)
+	}
+
+	toBeSigned, err := coseSigStructure(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+	der, err := privateKey.Sign(rand.Reader, alg.digest(toBeSigned), alg.hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+	signature, err := rawECDSASignature(der, alg.coordinateSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	signed, err := marshalCBOR(cborTag{number: coseSign1Tag, content: []interface{}{
+		protected,
+		map[int]interface{}{},
+		payload,
+		signature,
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode token: %w", err)
+	}
+	return signed, nil
+}
+
+// VerifyCOSE checks the signature of a COSE_Sign1 token created by CreateCOSE
+// against publicKey and returns its claims
+func VerifyCOSE(publicKey stdcrypto.PublicKey, signed []byte) (map[string]interface{}, error) {
+	alg, err := coseAlgorithmFor(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	ecKey := publicKey.(*ecdsa.PublicKey)
+
+	decoded, err := unmarshalCBOR(signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token: %w", err)
+	}
+	// The COSE_Sign1 tag is optional when the type is known from context
+	if tag, ok := decoded.(cborTag); ok {
+		if tag.number != coseSign1Tag {
+			return nil, fmt.Errorf("unexpected CBOR tag %d (expected COSE_Sign1)", tag.number)
+		}
+		decoded = tag.content
+	}
+	parts, ok := decoded.([]interface{})
+	if !ok || len(parts) != 4 {
+		return nil, errors.New("malformed COSE_Sign1 structure")
+	}
+	protected, ok1 := parts[0].([]byte)
+	payload, ok2 := parts[2].([]byte)
+	signature, ok3 := parts[3].([]byte)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("malformed COSE_Sign1 structure")
+	}
+
+	headers, err := unmarshalCBOR(protected)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode protected header: %w", err)
+	}
+	headerMap, ok := headers.(map[interface{}]interface{})
+	if !ok || headerMap[int64(coseHeaderAlg)] != int64(alg.id) {
+		return nil, fmt.Errorf("unsupported COSE algorithm (expected %s)", alg.name)
+	}
+
+	if len(signature) != 2*alg.coordinateSize {
+		return nil, errors.New("failed to verify token: malformed signature")
+	}
+	toBeSigned, err := coseSigStructure(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+	r := new(big.Int).SetBytes(signature[:alg.coordinateSize])
+	s := new(big.Int).SetBytes(signature[alg.coordinateSize:])
+	if !ecdsa.Verify(ecKey, alg.digest(toBeSigned), r, s) {
+		return nil, errors.New("failed to verify token: invalid signature")
+	}
+
+	decodedClaims, err := unmarshalCBOR(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %w", err)
+	}
+	claimMap, ok := decodedClaims.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("malformed claims")
+	}
+	claims := make(map[string]interface{}, len(claimMap))
+	for key, value := range claimMap {
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected claim key %v", key)
+		}
+		claims[name] = value
+	}
+	return claims, nil
+}
+
+// coseClaims converts the JWT claims to plain values for CBOR encoding, going
+// through their JSON form so both serializations carry identical claims
+func coseClaims(token interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal token: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var claims map[string]interface{}
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to marshal token: %w", err)
+	}
+	return claims, nil
+}
+
+// coseSigStructure encodes the Sig_structure signed by a COSE_Sign1 token,
+// without external additional authenticated data
+func coseSigStructure(protected, payload []byte) ([]byte, error) {
+	encoded, err := marshalCBOR([]interface{}{coseSign1Context, protected, []byte{}, payload})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Sig_structure: %w", err)
+	}
+	return encoded, nil
+}
+
+// rawECDSASignature converts an ASN.1 DER ECDSA signature to the fixed-size
+// r || s form used by COSE, with coordinateSize bytes per value
+func rawECDSASignature(der []byte, coordinateSize int) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed ECDSA signature")
+	}
+	raw := make([]byte, 2*coordinateSize)
+	sig.R.FillBytes(raw[:coordinateSize])
+	sig.S.FillBytes(raw[coordinateSize:])
+	return raw, nil
+}
@@ -43,6 +43,28 @@ func BenchmarkGenerateSPKIHashesMultipleCerts(b *testing.B) {
 	}
 }
 
+// BenchmarkGenerateSPKIHashCached benchmarks SPKI hashing served from the cache
+func BenchmarkGenerateSPKIHashCached(b *testing.B) {
+	ConfigureSPKICache(DefaultSPKICacheSize)
+	certs := generateTestCerts(b, 1)
+	GenerateSPKIHash(certs[0])
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GenerateSPKIHash(certs[0])
+	}
+}
+
+// BenchmarkGenerateSPKIHashUncached benchmarks SPKI hashing without the cache
+func BenchmarkGenerateSPKIHashUncached(b *testing.B) {
+	certs := generateTestCerts(b, 1)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		computeSPKIHash(certs[0])
+	}
+}
+
 // BenchmarkCreateJWS benchmarks JWS token creation with ECDSA P-256
 func BenchmarkCreateJWS(b *testing.B) {
 	// Generate ECDSA P-256 key pair
@@ -5,13 +5,23 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
 )
 
 func TestGenerateKeyID(t *testing.T) {
@@ -101,6 +111,194 @@ func TestGenerateSPKIHashes_EmptyInput(t *testing.T) {
 	}
 }
 
+func TestGenerateSPKIHash_CachedMatchesUncached(t *testing.T) {
+	ConfigureSPKICache(DefaultSPKICacheSize)
+	cert := createTestCertificate(t)
+
+	uncached := computeSPKIHash(cert)
+	first := GenerateSPKIHash(cert)  // populates the cache
+	second := GenerateSPKIHash(cert) // served from the cache
+
+	if first != uncached || second != uncached {
+		t.Errorf("Cached hashes %q/%q do not match uncached hash %q", first, second, uncached)
+	}
+
+	if _, ok := spkiCache.get(sha256.Sum256(cert.Raw)); !ok {
+		t.Error("Expected SPKI hash to be cached")
+	}
+}
+
+func TestSPKICache_Bounded(t *testing.T) {
+	ConfigureSPKICache(2)
+	t.Cleanup(func() { ConfigureSPKICache(DefaultSPKICacheSize) })
+
+	var last *x509.Certificate
+	for i := 0; i < 5; i++ {
+		last = createTestCertificate(t)
+		GenerateSPKIHash(last)
+	}
+
+	if size := spkiCache.size.Load(); size > 2 {
+		t.Errorf("Expected at most 2 cached entries, got %d", size)
+	}
+	// A full cache evicts single entries instead of being cleared
+	if _, ok := spkiCache.get(sha256.Sum256(last.Raw)); !ok {
+		t.Error("Expected the latest SPKI hash to be cached")
+	}
+}
+
+func TestSPKICache_Disabled(t *testing.T) {
+	ConfigureSPKICache(0)
+	t.Cleanup(func() { ConfigureSPKICache(DefaultSPKICacheSize) })
+
+	cert := createTestCertificate(t)
+	if hash := GenerateSPKIHash(cert); hash != computeSPKIHash(cert) {
+		t.Errorf("Expected uncached hash %q, got %q", computeSPKIHash(cert), hash)
+	}
+
+	if _, ok := spkiCache.get(sha256.Sum256(cert.Raw)); ok {
+		t.Error("Expected nothing to be cached when the cache is disabled")
+	}
+}
+
+func TestGenerateAKI(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte{0xde, 0xad, 0xbe, 0xef},
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create leaf certificate: %v", err)
+	}
+	leafCert, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatalf("Failed to parse leaf certificate: %v", err)
+	}
+
+	expected := base64.StdEncoding.EncodeToString([]byte{0xde, 0xad, 0xbe, 0xef})
+
+	// Leaf AKI is populated from the issuer's SKI
+	aki, err := GenerateAKI([]*x509.Certificate{leafCert, caCert})
+	if err != nil {
+		t.Fatalf("GenerateAKI failed: %v", err)
+	}
+	if aki != expected {
+		t.Errorf("Expected AKI %q, got %q", expected, aki)
+	}
+
+	// Without a leaf AKI the intermediate's SKI is used
+	leafWithoutAKI := *leafCert
+	leafWithoutAKI.AuthorityKeyId = nil
+	aki, err = GenerateAKI([]*x509.Certificate{&leafWithoutAKI, caCert})
+	if err != nil {
+		t.Fatalf("GenerateAKI failed: %v", err)
+	}
+	if aki != expected {
+		t.Errorf("Expected intermediate SKI %q, got %q", expected, aki)
+	}
+
+	// Neither AKI nor intermediate available
+	if _, err := GenerateAKI([]*x509.Certificate{&leafWithoutAKI}); !errors.Is(err, ErrNoAuthorityKeyID) {
+		t.Errorf("Expected ErrNoAuthorityKeyID, got %v", err)
+	}
+}
+
+// fingerprintTestCertPEM is a fixed self-signed certificate whose SHA-256
+// fingerprint was computed with `openssl x509 -noout -fingerprint -sha256`
+const fingerprintTestCertPEM = `-----BEGIN CERTIFICATE-----
+MIIBmTCCAT+gAwIBAgIUIjxRl1JuXd3SLhPegV+aB5/PZfUwCgYIKoZIzj0EAwIw
+IjEgMB4GA1UEAwwXZmluZ2VycHJpbnQuZXhhbXBsZS5jb20wHhcNMjYxMDE2MDEy
+OTE1WhcNMzYxMDEzMDEyOTE1WjAiMSAwHgYDVQQDDBdmaW5nZXJwcmludC5leGFt
+cGxlLmNvbTBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABPYWsFFCnZhPyzKBExbu
+cfg6t6GUPYNn0ne6w+oGlCqC9XIKB8qD5rtaHiqf43wHoHs2rdQjsTuSq7YUUG5H
+3ymjUzBRMB0GA1UdDgQWBBTdoAy3DniOofT4DnMUP/RWRNO5bTAfBgNVHSMEGDAW
+gBTdoAy3DniOofT4DnMUP/RWRNO5bTAPBgNVHRMBAf8EBTADAQH/MAoGCCqGSM49
+BAMCA0gAMEUCIQCq1gpy293N9GCDknYUGxbrOOrKHvomfrGBy6f+NOjoCgIgNGRW
+C4oslVC99Gp41Ja/+jUaasA+dkVr4gNphNnK/zI=
+-----END CERTIFICATE-----`
+
+func TestCertificateFingerprint(t *testing.T) {
+	block, _ := pem.Decode([]byte(fingerprintTestCertPEM))
+	if block == nil {
+		t.Fatal("Failed to decode certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	expected := "E3:B6:59:0D:F1:42:EA:07:29:1A:33:8F:1D:92:AD:9E:97:2E:70:CE:C0:51:A3:E7:3E:B4:71:0B:94:18:7B:50"
+	if got := CertificateFingerprint(cert); got != expected {
+		t.Errorf("CertificateFingerprint() = %q, want %q", got, expected)
+	}
+
+	// The fingerprint covers the whole certificate, not just the key
+	if got := CertificateFingerprint(cert); got == GenerateSPKIHash(cert) {
+		t.Error("Expected fingerprint to differ from the SPKI hash")
+	}
+}
+
+func TestCreateJWSWithClaims(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	jwsToken, err := CreateJWSWithClaims(privateKey, "kid", DefaultTokenType, "example.com", []string{"abc123"}, time.Hour, map[string]interface{}{
+		"pin_type": "aki",
+		"domain":   "evil.com", // standard claims cannot be overridden
+	})
+	if err != nil {
+		t.Fatalf("Failed to create JWS: %v", err)
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(splitJWS(jwsToken)[1])
+	if err != nil {
+		t.Fatalf("Failed to decode JWS payload: %v", err)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		t.Fatalf("Failed to parse JWS payload: %v", err)
+	}
+
+	if payload["pin_type"] != "aki" {
+		t.Errorf("Expected pin_type 'aki', got '%v'", payload["pin_type"])
+	}
+	if payload["domain"] != "example.com" {
+		t.Errorf("Expected domain 'example.com', got '%v'", payload["domain"])
+	}
+}
+
 func TestCreateJWS(t *testing.T) {
 	// Generate a test ECDSA P-256 key pair
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -186,6 +384,367 @@ func TestCreateJWS(t *testing.T) {
 	}
 }
 
+func TestCreateJWSJSONWithClaims(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	signed, err := CreateJWSJSONWithClaims(privateKey, "kid", DefaultTokenType, "example.com", []string{"abc123"}, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Failed to create JWS: %v", err)
+	}
+
+	var flattened map[string]string
+	if err := json.Unmarshal(signed, &flattened); err != nil {
+		t.Fatalf("Failed to parse flattened JWS: %v", err)
+	}
+	for _, member := range []string{"protected", "payload", "signature"} {
+		if flattened[member] == "" {
+			t.Errorf("Expected %q member in flattened JWS, got %s", member, signed)
+		}
+	}
+
+	// The flattened form must verify with the public key
+	payloadJSON, err := jws.Verify(signed, jws.WithKey(jwa.ES256, &privateKey.PublicKey))
+	if err != nil {
+		t.Fatalf("Failed to verify flattened JWS: %v", err)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		t.Fatalf("Failed to parse JWS payload: %v", err)
+	}
+	if payload["domain"] != "example.com" {
+		t.Errorf("Expected domain 'example.com', got '%v'", payload["domain"])
+	}
+}
+
+func TestVerifyJWS(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	compact, err := CreateJWS(privateKey, "kid", "example.com", []string{"abc123"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create JWS: %v", err)
+	}
+	flattened, err := CreateJWSJSONWithClaims(privateKey, "kid", DefaultTokenType, "example.com", []string{"abc123"}, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Failed to create JWS: %v", err)
+	}
+
+	for name, signed := range map[string][]byte{"compact": []byte(compact), "json": flattened} {
+		if err := VerifyJWS(&privateKey.PublicKey, signed); err != nil {
+			t.Errorf("%s: expected token to verify, got %v", name, err)
+		}
+		if err := VerifyJWS(&otherKey.PublicKey, signed); err == nil {
+			t.Errorf("%s: expected verification with another key to fail", name)
+		}
+	}
+}
+
+func TestCreateDetachedJWS(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	signed, payload, err := CreateDetachedJWS(privateKey, "kid", DefaultTokenType, "example.com", []string{"abc123", "def456"}, time.Hour, map[string]interface{}{"nonce": "n1"})
+	if err != nil {
+		t.Fatalf("Failed to create detached JWS: %v", err)
+	}
+
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		t.Fatalf("Expected an empty payload segment, got %q", signed)
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatalf("Failed to decode header: %v", err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		t.Fatalf("Failed to parse header: %v", err)
+	}
+	if header["b64"] != false || fmt.Sprint(header["crit"]) != "[b64]" || header["kid"] != "kid" {
+		t.Errorf("Expected unencoded payload headers, got %v", header)
+	}
+
+	// A client rebuilds the payload from the claims it already knows
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("Failed to parse payload: %v", err)
+	}
+	if claims["domain"] != "example.com" || claims["nonce"] != "n1" {
+		t.Errorf("Unexpected payload claims %v", claims)
+	}
+	reconstructed, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to reconstruct payload: %v", err)
+	}
+	if err := VerifyDetachedJWS(&privateKey.PublicKey, []byte(signed), reconstructed); err != nil {
+		t.Errorf("Expected reconstructed payload to verify, got %v", err)
+	}
+
+	// The signing input is the encoded header and the raw payload (RFC 7797)
+	digest := sha256.Sum256([]byte(parts[0] + "." + string(reconstructed)))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("Expected a 64-byte ES256 signature, got %d bytes (%v)", len(sig), err)
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&privateKey.PublicKey, digest[:], r, s) {
+		t.Error("Expected the signature to cover the unencoded payload")
+	}
+
+	claims["pins"] = []string{"abc123"}
+	tampered, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to encode tampered payload: %v", err)
+	}
+	if err := VerifyDetachedJWS(&privateKey.PublicKey, []byte(signed), tampered); err == nil {
+		t.Error("Expected a different payload to fail verification")
+	}
+}
+
+func TestCreateCOSE(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	pins := []string{"abc123", "def456"}
+	signed, err := CreateCOSEWithClaims(privateKey, "kid", "example.com", pins, time.Hour, map[string]interface{}{"nonce": "n1"})
+	if err != nil {
+		t.Fatalf("Failed to create COSE token: %v", err)
+	}
+
+	claims, err := VerifyCOSE(&privateKey.PublicKey, signed)
+	if err != nil {
+		t.Fatalf("Expected token to verify, got %v", err)
+	}
+	if claims["domain"] != "example.com" || claims["nonce"] != "n1" || claims["ttl_seconds"] != int64(3600) {
+		t.Errorf("Unexpected claims: %v", claims)
+	}
+	if got, ok := claims["pins"].([]interface{}); !ok || len(got) != 2 || got[0] != "abc123" || got[1] != "def456" {
+		t.Errorf("Expected pins %v, got %v", pins, claims["pins"])
+	}
+	iat, _ := claims["iat"].(int64)
+	exp, _ := claims["exp"].(int64)
+	if exp-iat != 3600 {
+		t.Errorf("Expected exp one hour after iat, got iat=%v exp=%v", claims["iat"], claims["exp"])
+	}
+
+	if _, err := VerifyCOSE(&otherKey.PublicKey, signed); err == nil {
+		t.Error("Expected verification with another key to fail")
+	}
+	tampered := append([]byte{}, signed...)
+	tampered[len(tampered)-70] ^= 0x01
+	if _, err := VerifyCOSE(&privateKey.PublicKey, tampered); err == nil {
+		t.Error("Expected verification of a tampered token to fail")
+	}
+	if _, err := VerifyCOSE(&privateKey.PublicKey, signed[:len(signed)-1]); err == nil {
+		t.Error("Expected verification of a truncated token to fail")
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if _, err := CreateCOSE(rsaKey, "kid", "example.com", pins, time.Hour); err == nil {
+		t.Error("Expected an error for an RSA key")
+	}
+}
+
+func TestCBOR_Encoding(t *testing.T) {
+	// Examples from RFC 8949 Appendix A
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{int64(1000000000000), "1b000000e8d4a51000"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{false, "f4"},
+		{nil, "f6"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]interface{}{1, []interface{}{2, 3}}, "8201820203"},
+		{map[string]interface{}{"b": []interface{}{2, 3}, "a": 1}, "a26161016162820203"},
+		{map[int]interface{}{10: 1, -1: 2, 100: 3}, "a30a011864032002"},
+		{cborTag{number: 1, content: 1363896240}, "c11a514b67b0"},
+	}
+
+	for _, tt := range tests {
+		encoded, err := marshalCBOR(tt.value)
+		if err != nil {
+			t.Errorf("marshalCBOR(%v) failed: %v", tt.value, err)
+			continue
+		}
+		if got := hex.EncodeToString(encoded); got != tt.expected {
+			t.Errorf("marshalCBOR(%v) = %s, want %s", tt.value, got, tt.expected)
+		}
+		if _, err := unmarshalCBOR(encoded); err != nil {
+			t.Errorf("unmarshalCBOR(%s) failed: %v", tt.expected, err)
+		}
+	}
+
+	for _, malformed := range []string{"", "19", "5f", "64494554", "8201", "a1", "c1"} {
+		data, _ := hex.DecodeString(malformed)
+		if _, err := unmarshalCBOR(data); err == nil {
+			t.Errorf("Expected error decoding %q", malformed)
+		}
+	}
+}
+
+// TestSigning_Curves tests that every serialization signs and verifies with the
+// algorithm matching the key's curve
+func TestSigning_Curves(t *testing.T) {
+	tests := []struct {
+		curve  elliptic.Curve
+		alg    jwa.SignatureAlgorithm
+		coseID int64
+	}{
+		{elliptic.P256(), jwa.ES256, -7},
+		{elliptic.P384(), jwa.ES384, -35},
+		{elliptic.P521(), jwa.ES512, -36},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.curve.Params().Name, func(t *testing.T) {
+			privateKey, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			publicKey := &privateKey.PublicKey
+			keyID := GenerateKeyID(publicKey)
+			pins := []string{"abc123"}
+
+			compact, err := CreateJWS(privateKey, keyID, "example.com", pins, time.Hour)
+			if err != nil {
+				t.Fatalf("Failed to create JWS: %v", err)
+			}
+			msg, err := jws.Parse([]byte(compact))
+			if err != nil {
+				t.Fatalf("Failed to parse JWS: %v", err)
+			}
+			if alg := msg.Signatures()[0].ProtectedHeaders().Algorithm(); alg != tt.alg {
+				t.Errorf("Expected alg %s, got %s", tt.alg, alg)
+			}
+			flattened, err := CreateJWSJSONWithClaims(privateKey, keyID, DefaultTokenType, "example.com", pins, time.Hour, nil)
+			if err != nil {
+				t.Fatalf("Failed to create JWS: %v", err)
+			}
+			for name, signed := range map[string][]byte{"compact": []byte(compact), "json": flattened} {
+				if err := VerifyJWS(publicKey, signed); err != nil {
+					t.Errorf("%s: expected token to verify, got %v", name, err)
+				}
+			}
+
+			detached, payload, err := CreateDetachedJWS(privateKey, keyID, DefaultTokenType, "example.com", pins, time.Hour, nil)
+			if err != nil {
+				t.Fatalf("Failed to create detached JWS: %v", err)
+			}
+			if err := VerifyDetachedJWS(publicKey, []byte(detached), payload); err != nil {
+				t.Errorf("Expected detached token to verify, got %v", err)
+			}
+
+			raw, err := PublicJWKS(publicKey, keyID)
+			if err != nil {
+				t.Fatalf("Failed to create JWKS: %v", err)
+			}
+			set, err := jwk.Parse(raw)
+			if err != nil {
+				t.Fatalf("Failed to parse JWKS: %v", err)
+			}
+			key, _ := set.Key(0)
+			if key.Algorithm() != tt.alg {
+				t.Errorf("Expected JWKS alg %s, got %v", tt.alg, key.Algorithm())
+			}
+			if _, err := jws.Verify([]byte(compact), jws.WithKeySet(set)); err != nil {
+				t.Errorf("Failed to verify JWS with JWKS: %v", err)
+			}
+
+			coseToken, err := CreateCOSE(privateKey, keyID, "example.com", pins, time.Hour)
+			if err != nil {
+				t.Fatalf("Failed to create COSE token: %v", err)
+			}
+			if _, err := VerifyCOSE(publicKey, coseToken); err != nil {
+				t.Errorf("Expected COSE token to verify, got %v", err)
+			}
+			decoded, err := unmarshalCBOR(coseToken)
+			if err != nil {
+				t.Fatalf("Failed to decode COSE token: %v", err)
+			}
+			protected, err := unmarshalCBOR(decoded.(cborTag).content.([]interface{})[0].([]byte))
+			if err != nil {
+				t.Fatalf("Failed to decode protected header: %v", err)
+			}
+			if got := protected.(map[interface{}]interface{})[int64(1)]; got != tt.coseID {
+				t.Errorf("Expected COSE alg %d, got %v", tt.coseID, got)
+			}
+		})
+	}
+}
+
+func TestCreateJWS_RSA(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	keyID := GenerateKeyID(&privateKey.PublicKey)
+	if len(keyID) != 8 {
+		t.Fatalf("Expected an 8 character key ID for an RSA key, got %q", keyID)
+	}
+
+	compact, err := CreateJWS(privateKey, keyID, "example.com", []string{"abc123"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create JWS: %v", err)
+	}
+	flattened, err := CreateJWSJSONWithClaims(privateKey, keyID, DefaultTokenType, "example.com", []string{"abc123"}, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Failed to create JWS: %v", err)
+	}
+
+	msg, err := jws.Parse([]byte(compact))
+	if err != nil {
+		t.Fatalf("Failed to parse JWS: %v", err)
+	}
+	if alg := msg.Signatures()[0].ProtectedHeaders().Algorithm(); alg != jwa.RS256 {
+		t.Errorf("Expected alg RS256, got %s", alg)
+	}
+
+	for name, signed := range map[string][]byte{"compact": []byte(compact), "json": flattened} {
+		if err := VerifyJWS(&privateKey.PublicKey, signed); err != nil {
+			t.Errorf("%s: expected token to verify, got %v", name, err)
+		}
+		if err := VerifyJWS(&otherKey.PublicKey, signed); err == nil {
+			t.Errorf("%s: expected verification with another key to fail", name)
+		}
+	}
+}
+
 func TestCreateJWS_WithDifferentInputs(t *testing.T) {
 	// Generate a test ECDSA P-256 key pair
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -264,6 +823,97 @@ func TestCreateJWS_KidHeader(t *testing.T) {
 	}
 }
 
+func TestPublicJWKS(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	publicKey := &privateKey.PublicKey
+	keyID := GenerateKeyID(publicKey)
+
+	raw, err := PublicJWKS(publicKey, keyID)
+	if err != nil {
+		t.Fatalf("Failed to create JWKS: %v", err)
+	}
+
+	set, err := jwk.Parse(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse JWKS: %v", err)
+	}
+	if set.Len() != 1 {
+		t.Fatalf("Expected 1 key in JWKS, got %d", set.Len())
+	}
+	key, _ := set.Key(0)
+	if key.KeyID() != keyID {
+		t.Errorf("Expected kid %q, got %q", keyID, key.KeyID())
+	}
+	if key.Algorithm() != jwa.ES256 {
+		t.Errorf("Expected alg ES256, got %v", key.Algorithm())
+	}
+	if strings.Contains(string(raw), `"d"`) {
+		t.Error("JWKS must not contain private key material")
+	}
+
+	// Tokens must verify against the set alone
+	jwsToken, err := CreateJWS(privateKey, keyID, "example.com", []string{"abc123"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create JWS: %v", err)
+	}
+	if _, err := jws.Verify([]byte(jwsToken), jws.WithKeySet(set)); err != nil {
+		t.Errorf("Failed to verify JWS with JWKS: %v", err)
+	}
+}
+
+func TestCreateJWS_TypHeader(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		typ      string
+		expected string
+	}{
+		{"default", "", DefaultTokenType},
+		{"jwt", "JWT", "JWT"},
+		{"custom", "pins+jwt", "pins+jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compact, err := CreateJWSWithClaims(privateKey, "kid", tt.typ, "example.com", []string{"abc123"}, time.Hour, nil)
+			if err != nil {
+				t.Fatalf("Failed to create JWS: %v", err)
+			}
+			flattened, err := CreateJWSJSONWithClaims(privateKey, "kid", tt.typ, "example.com", []string{"abc123"}, time.Hour, nil)
+			if err != nil {
+				t.Fatalf("Failed to create JWS: %v", err)
+			}
+
+			var member map[string]string
+			if err := json.Unmarshal(flattened, &member); err != nil {
+				t.Fatalf("Failed to parse flattened JWS: %v", err)
+			}
+
+			for name, protected := range map[string]string{"compact": splitJWS(compact)[0], "json": member["protected"]} {
+				headerJSON, err := base64.RawURLEncoding.DecodeString(protected)
+				if err != nil {
+					t.Fatalf("%s: failed to decode JWS header: %v", name, err)
+				}
+				var header map[string]interface{}
+				if err := json.Unmarshal(headerJSON, &header); err != nil {
+					t.Fatalf("%s: failed to parse JWS header: %v", name, err)
+				}
+
+				if header["typ"] != tt.expected {
+					t.Errorf("%s: expected typ %q, got %v", name, tt.expected, header["typ"])
+				}
+			}
+		})
+	}
+}
+
 func TestGetPublicKeyFromPrivate(t *testing.T) {
 	// Generate a test ECDSA P-256 key pair
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
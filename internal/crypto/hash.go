@@ -4,12 +4,92 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// DefaultSPKICacheSize is the default maximum number of cached SPKI hashes
+const DefaultSPKICacheSize = 1024
+
+// spkiCache caches SPKI hashes keyed by the certificate's SHA-256 fingerprint,
+// so chains served repeatedly are hashed only once
+var spkiCache = newHashCache(DefaultSPKICacheSize)
+
+// hashCache maps certificate fingerprints to base64-encoded SPKI hashes
+// It is bounded by maxEntries: when full, one arbitrary entry is evicted
+// before inserting. Concurrent inserts may briefly overshoot the bound.
+type hashCache struct {
+	entries    sync.Map // [32]byte -> string
+	size       atomic.Int64
+	maxEntries atomic.Int64
+}
+
+func newHashCache(maxEntries int) *hashCache {
+	c := &hashCache{}
+	c.maxEntries.Store(int64(maxEntries))
+	return c
+}
+
+func (c *hashCache) get(fingerprint [32]byte) (string, bool) {
+	hash, ok := c.entries.Load(fingerprint)
+	if !ok {
+		return "", false
+	}
+	return hash.(string), true
+}
+
+func (c *hashCache) set(fingerprint [32]byte, hash string) {
+	maxEntries := c.maxEntries.Load()
+	if maxEntries <= 0 {
+		return
+	}
+	if c.size.Load() >= maxEntries {
+		c.entries.Range(func(key, _ any) bool {
+			c.delete(key)
+			return false
+		})
+	}
+	if _, loaded := c.entries.LoadOrStore(fingerprint, hash); !loaded {
+		c.size.Add(1)
+	}
+}
+
+func (c *hashCache) delete(key any) {
+	if _, loaded := c.entries.LoadAndDelete(key); loaded {
+		c.size.Add(-1)
+	}
+}
+
+// ConfigureSPKICache sets the maximum number of cached SPKI hashes
+// and clears the cache. A size of 0 disables caching.
+func ConfigureSPKICache(maxEntries int) {
+	spkiCache.maxEntries.Store(int64(maxEntries))
+	spkiCache.entries.Range(func(key, _ any) bool {
+		spkiCache.delete(key)
+		return true
+	})
+}
+
 // GenerateSPKIHash generates a base64-encoded SHA-256 hash of a certificate's SPKI
 // This matches TrustKit's pin format: base64(SHA256(SPKI))
 // SPKI (SubjectPublicKeyInfo) includes both the algorithm identifier and the public key
+// Results are cached by certificate fingerprint (see ConfigureSPKICache)
 func GenerateSPKIHash(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	if hash, ok := spkiCache.get(fingerprint); ok {
+		return hash
+	}
+
+	hash := computeSPKIHash(cert)
+	spkiCache.set(fingerprint, hash)
+	return hash
+}
+
+// computeSPKIHash hashes the certificate's SPKI without consulting the cache
+func computeSPKIHash(cert *x509.Certificate) string {
 	// Use the certificate's RawSubjectPublicKeyInfo (SPKI in DER format)
 	// This works for all key types (EC, RSA, etc.)
 	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
@@ -25,3 +105,44 @@ func GenerateSPKIHashes(certs []*x509.Certificate) []string {
 	}
 	return hashes
 }
+
+// ErrNoAuthorityKeyID is returned when no authority key identifier can be determined for a chain
+var ErrNoAuthorityKeyID = errors.New("certificate chain has no authority key identifier")
+
+// GenerateAKI returns the base64-encoded authority key identifier of the leaf certificate
+// If the leaf carries no AKI extension, the subject key identifier of the
+// intermediate (the issuing authority) is used instead
+func GenerateAKI(chain []*x509.Certificate) (string, error) {
+	if len(chain) == 0 {
+		return "", ErrNoAuthorityKeyID
+	}
+
+	if aki := chain[0].AuthorityKeyId; len(aki) > 0 {
+		return base64.StdEncoding.EncodeToString(aki), nil
+	}
+
+	if len(chain) > 1 && len(chain[1].SubjectKeyId) > 0 {
+		return base64.StdEncoding.EncodeToString(chain[1].SubjectKeyId), nil
+	}
+
+	return "", ErrNoAuthorityKeyID
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of the full DER
+// certificate as colon-separated uppercase hex (the format used by openssl and
+// CT log search), for correlating pinned certificates in forensic analysis
+// Unlike the SPKI hash used for pins, it changes whenever the certificate is reissued
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	encoded := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	var b strings.Builder
+	b.Grow(len(encoded) + len(sum) - 1)
+	for i := 0; i < len(encoded); i += 2 {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		b.WriteString(encoded[i : i+2])
+	}
+	return b.String()
+}
@@ -1,19 +1,25 @@
 package crypto
 
 import (
+	stdcrypto "crypto"
 	"crypto/ecdsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
 )
 
 // GenerateKeyID generates a unique identifier for a public key
 // by hashing the public key bytes and taking the first 8 characters
-func GenerateKeyID(publicKey *ecdsa.PublicKey) string {
+// ECDSA and RSA public keys are supported
+func GenerateKeyID(publicKey stdcrypto.PublicKey) string {
 	// Marshal the public key to DER format (SPKI)
 	pubKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
 	if err != nil {
-		// This should never fail for a valid ECDSA public key
+		// This should never fail for a valid ECDSA or RSA public key
 		// Return empty string to indicate error
 		return ""
 	}
@@ -25,3 +31,33 @@ func GenerateKeyID(publicKey *ecdsa.PublicKey) string {
 func GetPublicKeyFromPrivate(privateKey *ecdsa.PrivateKey) *ecdsa.PublicKey {
 	return &privateKey.PublicKey
 }
+
+// PublicJWKS returns publicKey as a JSON Web Key Set (RFC 7517) holding a single
+// signing key with the given key ID and the algorithm tokens are signed with,
+// so clients can verify tokens without out-of-band key distribution
+func PublicJWKS(publicKey stdcrypto.PublicKey, keyID string) (json.RawMessage, error) {
+	alg, err := SignatureAlgorithm(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwk.FromRaw(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWK: %w", err)
+	}
+	for name, value := range map[string]interface{}{
+		jwk.KeyIDKey:     keyID,
+		jwk.AlgorithmKey: alg,
+		jwk.KeyUsageKey:  jwk.ForSignature,
+	} {
+		if err := key.Set(name, value); err != nil {
+			return nil, fmt.Errorf("failed to set JWK %s: %w", name, err)
+		}
+	}
+
+	set := jwk.NewSet()
+	if err := set.AddKey(key); err != nil {
+		return nil, fmt.Errorf("failed to build JWKS: %w", err)
+	}
+	return json.Marshal(set)
+}
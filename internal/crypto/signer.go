@@ -1,7 +1,11 @@
 package crypto
 
 import (
+	stdcrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,45 +14,211 @@ import (
 	"github.com/lestrrat-go/jwx/v2/jwt"
 )
 
-// CreateJWS creates a JWS token with the given parameters using ECDSA P-256 (ES256)
-func CreateJWS(privateKey *ecdsa.PrivateKey, keyID string, domain string, pins []string, ttl time.Duration) (string, error) {
+// DefaultTokenType is the typ header set on issued tokens unless configured
+const DefaultTokenType = "JWT"
+
+// CreateJWS creates a JWS token with the given parameters, signed with the
+// algorithm SignatureAlgorithm picks for the key
+func CreateJWS(privateKey stdcrypto.Signer, keyID string, domain string, pins []string, ttl time.Duration) (string, error) {
+	return CreateJWSWithClaims(privateKey, keyID, DefaultTokenType, domain, pins, ttl, nil)
+}
+
+// CreateJWSWithClaims creates a JWS token like CreateJWS with the given typ header
+// (DefaultTokenType when empty) and adds the given extra claims to the payload.
+// Extra claims cannot override the standard claims.
+func CreateJWSWithClaims(privateKey stdcrypto.Signer, keyID string, typ string, domain string, pins []string, ttl time.Duration, extraClaims map[string]interface{}) (string, error) {
+	alg, err := SignatureAlgorithm(privateKey.Public())
+	if err != nil {
+		return "", err
+	}
+
+	token, err := buildToken(domain, pins, ttl, extraClaims)
+	if err != nil {
+		return "", err
+	}
+
+	headers, err := buildHeaders(alg, keyID, typ)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := jwt.Sign(token, jwt.WithKey(alg, privateKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return string(signed), nil
+}
+
+// CreateJWSJSONWithClaims creates the same token as CreateJWSWithClaims but returns it
+// in the flattened JSON JWS serialization ({"protected":...,"payload":...,"signature":...})
+func CreateJWSJSONWithClaims(privateKey stdcrypto.Signer, keyID string, typ string, domain string, pins []string, ttl time.Duration, extraClaims map[string]interface{}) ([]byte, error) {
+	alg, err := SignatureAlgorithm(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := buildToken(domain, pins, ttl, extraClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := buildHeaders(alg, keyID, typ)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	// A single signature is serialized in the flattened form
+	signed, err := jws.Sign(payload, jws.WithJSON(), jws.WithKey(alg, privateKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signed, nil
+}
+
+// CreateDetachedJWS creates the same token as CreateJWSWithClaims with a detached,
+// unencoded payload (RFC 7797: "b64": false): the compact serialization has an
+// empty middle segment and is returned with the payload bytes it signs, which a
+// client that already knows the claims must reconstruct byte for byte to verify it
+func CreateDetachedJWS(privateKey stdcrypto.Signer, keyID string, typ string, domain string, pins []string, ttl time.Duration, extraClaims map[string]interface{}) (string, []byte, error) {
+	alg, err := SignatureAlgorithm(privateKey.Public())
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := buildToken(domain, pins, ttl, extraClaims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	headers, err := buildHeaders(alg, keyID, typ)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := headers.Set("b64", false); err != nil {
+		return "", nil, fmt.Errorf("failed to set b64 header: %w", err)
+	}
+	if err := headers.Set(jws.CriticalKey, []string{"b64"}); err != nil {
+		return "", nil, fmt.Errorf("failed to set crit header: %w", err)
+	}
+
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	signed, err := jws.Sign(nil, jws.WithDetachedPayload(payload), jws.WithKey(alg, privateKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return string(signed), payload, nil
+}
+
+// VerifyDetachedJWS checks a token created by CreateDetachedJWS against the
+// payload it was signed over and publicKey
+func VerifyDetachedJWS(publicKey stdcrypto.PublicKey, signed []byte, payload []byte) error {
+	alg, err := SignatureAlgorithm(publicKey)
+	if err != nil {
+		return err
+	}
+	if _, err := jws.Verify(signed, jws.WithKey(alg, publicKey), jws.WithDetachedPayload(payload)); err != nil {
+		return fmt.Errorf("failed to verify token: %w", err)
+	}
+	return nil
+}
+
+// VerifyJWS checks the signature of a compact or JSON serialized JWS against
+// publicKey, using the algorithm CreateJWS signs with for that key type
+func VerifyJWS(publicKey stdcrypto.PublicKey, signed []byte) error {
+	alg, err := SignatureAlgorithm(publicKey)
+	if err != nil {
+		return err
+	}
+	if _, err := jws.Verify(signed, jws.WithKey(alg, publicKey)); err != nil {
+		return fmt.Errorf("failed to verify token: %w", err)
+	}
+	return nil
+}
+
+// SignatureAlgorithm returns the JWS algorithm used for publicKey: ES256, ES384
+// or ES512 for ECDSA keys on P-256, P-384 or P-521, and RS256 for RSA
+func SignatureAlgorithm(publicKey stdcrypto.PublicKey) (jwa.SignatureAlgorithm, error) {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwa.ES256, nil
+		case elliptic.P384():
+			return jwa.ES384, nil
+		case elliptic.P521():
+			return jwa.ES512, nil
+		default:
+			return "", fmt.Errorf("unsupported elliptic curve %s", key.Curve.Params().Name)
+		}
+	case *rsa.PublicKey:
+		return jwa.RS256, nil
+	default:
+		return "", fmt.Errorf("unsupported signing key type %T", publicKey)
+	}
+}
+
+// buildToken creates the JWT claims set shared by all serializations
+func buildToken(domain string, pins []string, ttl time.Duration, extraClaims map[string]interface{}) (jwt.Token, error) {
 	// Create a new JWT token
 	token := jwt.New()
 
+	// Set optional claims first so the standard claims below always win
+	for name, value := range extraClaims {
+		if err := token.Set(name, value); err != nil {
+			return nil, fmt.Errorf("failed to set %s claim: %w", name, err)
+		}
+	}
+
 	// Set required claims
 	if err := token.Set("domain", domain); err != nil {
-		return "", fmt.Errorf("failed to set domain claim: %w", err)
+		return nil, fmt.Errorf("failed to set domain claim: %w", err)
 	}
 	if err := token.Set("pins", pins); err != nil {
-		return "", fmt.Errorf("failed to set pins claim: %w", err)
+		return nil, fmt.Errorf("failed to set pins claim: %w", err)
 	}
 
 	// Set standard JWT claims
 	now := time.Now().UTC()
 	if err := token.Set(jwt.IssuedAtKey, now.Unix()); err != nil {
-		return "", fmt.Errorf("failed to set iat claim: %w", err)
+		return nil, fmt.Errorf("failed to set iat claim: %w", err)
 	}
 	if err := token.Set(jwt.ExpirationKey, now.Add(ttl).Unix()); err != nil {
-		return "", fmt.Errorf("failed to set exp claim: %w", err)
+		return nil, fmt.Errorf("failed to set exp claim: %w", err)
 	}
 	if err := token.Set("ttl_seconds", int(ttl.Seconds())); err != nil {
-		return "", fmt.Errorf("failed to set ttl_seconds claim: %w", err)
+		return nil, fmt.Errorf("failed to set ttl_seconds claim: %w", err)
 	}
 
-	// Create JWS headers
+	return token, nil
+}
+
+// buildHeaders creates the protected JWS headers, with typ defaulting to
+// DefaultTokenType
+func buildHeaders(alg jwa.SignatureAlgorithm, keyID string, typ string) (jws.Headers, error) {
+	if typ == "" {
+		typ = DefaultTokenType
+	}
 	headers := jws.NewHeaders()
-	if err := headers.Set(jws.AlgorithmKey, jwa.ES256); err != nil {
-		return "", fmt.Errorf("failed to set algorithm header: %w", err)
+	if err := headers.Set(jws.AlgorithmKey, alg); err != nil {
+		return nil, fmt.Errorf("failed to set algorithm header: %w", err)
 	}
 	if err := headers.Set(jws.KeyIDKey, keyID); err != nil {
-		return "", fmt.Errorf("failed to set kid header: %w", err)
+		return nil, fmt.Errorf("failed to set kid header: %w", err)
 	}
-
-	// Sign the token with ES256 (ECDSA P-256 + SHA-256)
-	signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256, privateKey, jws.WithProtectedHeaders(headers)))
-	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+	if err := headers.Set(jws.TypeKey, typ); err != nil {
+		return nil, fmt.Errorf("failed to set typ header: %w", err)
 	}
-
-	return string(signed), nil
+	return headers, nil
 }
@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"net"
+	"strings"
+)
+
+// IsValidHostname reports whether name is a syntactically valid DNS hostname:
+// at most 253 characters of dot-separated labels, each 1 to 63 ASCII letters,
+// digits or hyphens, not starting or ending with a hyphen. Internationalized
+// names must be given in punycode. IP literals and wildcards are not hostnames.
+func IsValidHostname(name string) bool {
+	if len(name) == 0 || len(name) > 253 || net.ParseIP(name) != nil {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
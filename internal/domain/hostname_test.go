@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsValidHostname(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+		expected bool
+	}{
+		{"simple", "example.com", true},
+		{"subdomain", "api.example.com", true},
+		{"mixed case", "API.Example.com", true},
+		{"single label", "localhost", true},
+		{"hyphen inside label", "my-api.example.com", true},
+		{"punycode", "xn--bcher-kva.example", true},
+		{"max label length", strings.Repeat("a", 63) + ".com", true},
+		{"empty", "", false},
+		{"label too long", strings.Repeat("a", 64) + ".com", false},
+		{"name too long", strings.Repeat("a.", 127) + "com", false},
+		{"empty label", "example..com", false},
+		{"trailing dot", "example.com.", false},
+		{"leading hyphen", "-api.example.com", false},
+		{"trailing hyphen", "api-.example.com", false},
+		{"wildcard", "*.example.com", false},
+		{"underscore", "my_api.example.com", false},
+		{"space", "exa mple.com", false},
+		{"unicode", "bücher.example", false},
+		{"port", "example.com:443", false},
+		{"ipv4", "192.168.1.1", false},
+		{"ipv6", "::1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsValidHostname(tt.hostname); got != tt.expected {
+				t.Errorf("IsValidHostname(%q) = %v, expected %v", tt.hostname, got, tt.expected)
+			}
+		})
+	}
+}
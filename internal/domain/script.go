@@ -0,0 +1,83 @@
+package domain
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// allowedScriptMixes are script combinations that legitimately share a label
+// (the "highly restrictive" profile of Unicode TS #39)
+var allowedScriptMixes = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// HasMixedScript reports whether any label of domain mixes Unicode scripts in
+// a way typical of homograph attacks, e.g. a Cyrillic "а" in "аpple.com"
+// Punycode labels are decoded first. This is a best-effort heuristic: digits,
+// hyphens and other script-neutral characters are ignored, and the combinations
+// in allowedScriptMixes are accepted
+func HasMixedScript(domain string) bool {
+	if unicodeDomain, err := idna.Lookup.ToUnicode(domain); err == nil {
+		domain = unicodeDomain
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if isMixedScriptLabel(label) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMixedScriptLabel reports whether a single label mixes scripts
+func isMixedScriptLabel(label string) bool {
+	scripts := make(map[string]bool)
+	for _, r := range label {
+		if r < unicode.MaxASCII {
+			if unicode.IsLetter(r) {
+				scripts["Latin"] = true
+			}
+			continue
+		}
+		if unicode.In(r, unicode.Common, unicode.Inherited) {
+			continue
+		}
+		for name, table := range unicode.Scripts {
+			if unicode.Is(table, r) {
+				scripts[name] = true
+				break
+			}
+		}
+	}
+
+	if len(scripts) <= 1 {
+		return false
+	}
+	for _, mix := range allowedScriptMixes {
+		if isSubset(scripts, mix) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSubset reports whether every script in scripts is listed in allowed
+func isSubset(scripts map[string]bool, allowed []string) bool {
+	for script := range scripts {
+		found := false
+		for _, a := range allowed {
+			if script == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
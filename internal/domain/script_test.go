@@ -0,0 +1,29 @@
+package domain
+
+import "testing"
+
+func TestHasMixedScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		domain   string
+		expected bool
+	}{
+		{"pure ASCII", "example.com", false},
+		{"ASCII with digits and hyphens", "api-v2.example123.com", false},
+		{"pure Cyrillic IDN", "пример.рф", false},
+		{"pure Cyrillic punycode", "xn--e1afmkfd.xn--p1ai", false},
+		{"Japanese with Latin", "日本語テストabc.jp", false},
+		{"Cyrillic a in Latin label", "аpple.com", true},
+		{"homograph punycode", "xn--pple-43d.com", true},
+		{"Greek omicron in Latin label", "gοogle.com", true},
+		{"mixed label among clean ones", "www.аpple.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasMixedScript(tt.domain); got != tt.expected {
+				t.Errorf("HasMixedScript(%q) = %v, want %v", tt.domain, got, tt.expected)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package domain
 import (
 	"net"
 	"strings"
+
+	"golang.org/x/net/idna"
 )
 
 // Validator validates domain names against a whitelist
@@ -31,32 +33,44 @@ func NewValidatorWithOptions(allowedDomains []string, allowIPLiterals bool) *Val
 // Supports wildcards like "*.example.com"
 // Rejects IP literals unless allowIPLiterals is true
 func (v *Validator) IsAllowed(domain string) bool {
+	_, ok := v.Match(domain)
+	return ok
+}
+
+// Match returns the most specific whitelist entry that allows domain, as
+// configured but trimmed of whitespace, e.g. "*.example.com" for "api.example.com"
+// An exact entry wins over a wildcard wherever it appears in the whitelist, so
+// per-domain policy can key off it; otherwise the first matching wildcard is returned
+func (v *Validator) Match(domain string) (string, bool) {
 	domain = strings.ToLower(strings.TrimSpace(domain))
 
 	// Reject IP literals (IPv4 and IPv6) unless explicitly allowed
 	if !v.allowIPLiterals {
 		if net.ParseIP(domain) != nil {
-			return false
+			return "", false
 		}
 		// Also check for [IPv6] format
 		if strings.HasPrefix(domain, "[") && strings.HasSuffix(domain, "]") {
 			ip := domain[1 : len(domain)-1]
 			if net.ParseIP(ip) != nil {
-				return false
+				return "", false
 			}
 		}
 	}
 
-	for _, allowed := range v.allowedDomains {
-		allowed = strings.ToLower(strings.TrimSpace(allowed))
+	wildcard, wildcardFound := "", false
+	for _, rule := range v.allowedDomains {
+		rule = strings.TrimSpace(rule)
+		allowed := strings.ToLower(rule)
 
 		// Exact match
 		if domain == allowed {
-			return true
+			return rule, true
 		}
 
-		// Wildcard match (only single-level wildcard supported)
-		if strings.HasPrefix(allowed, "*.") {
+		// Wildcard match (only single-level wildcard supported), kept in case
+		// a later entry matches exactly
+		if !wildcardFound && strings.HasPrefix(allowed, "*.") {
 			suffix := allowed[2:] // Remove "*."
 			// Check if domain ends with the suffix and has exactly one more level
 			if strings.HasSuffix(domain, suffix) {
@@ -65,12 +79,23 @@ func (v *Validator) IsAllowed(domain string) bool {
 					// Ensure there's only one additional level (no extra dots)
 					prefix := domain[:len(domain)-len(suffix)-1]
 					if !strings.Contains(prefix, ".") {
-						return true
+						wildcard, wildcardFound = rule, true
 					}
 				}
 			}
 		}
 	}
 
-	return false
+	return wildcard, wildcardFound
+}
+
+// Normalize returns domain as the whitelist compares it, trimmed and lowercased,
+// with internationalized labels converted to punycode (e.g. "Bücher.example.com"
+// becomes "xn--bcher-kva.example.com"). Names idna rejects are only trimmed and lowercased.
+func Normalize(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+	return domain
 }
@@ -115,3 +115,77 @@ func TestValidator_IsAllowed_MultipleWildcards(t *testing.T) {
 		})
 	}
 }
+
+func TestValidator_Match(t *testing.T) {
+	validator := NewValidator([]string{"example.com", " *.Example.com ", "*.test.org"})
+
+	tests := []struct {
+		domain       string
+		expectedRule string
+		expectedOK   bool
+	}{
+		{"example.com", "example.com", true},
+		{"EXAMPLE.COM", "example.com", true},
+		{"api.example.com", "*.Example.com", true},
+		{"api.test.org", "*.test.org", true},
+		{"sub.api.example.com", "", false},
+		{"notallowed.com", "", false},
+		{"127.0.0.1", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			rule, ok := validator.Match(tt.domain)
+			if rule != tt.expectedRule || ok != tt.expectedOK {
+				t.Errorf("Match(%q) = (%q, %v), want (%q, %v)", tt.domain, rule, ok, tt.expectedRule, tt.expectedOK)
+			}
+		})
+	}
+}
+
+// TestValidator_Match_MostSpecific tests that an exact entry wins over a
+// wildcard matching the same domain, in either whitelist order
+func TestValidator_Match_MostSpecific(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedDomains []string
+		domain         string
+		expectedRule   string
+	}{
+		{"exact_first", []string{"api.example.com", "*.example.com"}, "api.example.com", "api.example.com"},
+		{"wildcard_first", []string{"*.example.com", "api.example.com"}, "api.example.com", "api.example.com"},
+		{"case_insensitive", []string{"*.example.com", "API.example.com"}, "api.EXAMPLE.com", "API.example.com"},
+		{"other_subdomain", []string{"*.example.com", "api.example.com"}, "www.example.com", "*.example.com"},
+		{"first_wildcard", []string{"*.example.com", "*.Example.com"}, "www.example.com", "*.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := NewValidator(tt.allowedDomains).Match(tt.domain)
+			if !ok || rule != tt.expectedRule {
+				t.Errorf("Match(%q) = (%q, %v), want (%q, true)", tt.domain, rule, ok, tt.expectedRule)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		domain   string
+		expected string
+	}{
+		{"example.com", "example.com"},
+		{" API.Example.com ", "api.example.com"},
+		{"Bücher.example.com", "xn--bcher-kva.example.com"},
+		{"xn--bcher-kva.example.com", "xn--bcher-kva.example.com"},
+		{"bad_label.example.com", "bad_label.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := Normalize(tt.domain); got != tt.expected {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.domain, got, tt.expected)
+			}
+		})
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
 )
 
 var Logger *slog.Logger
 
+// autoInit guards the lazy initialization of Logger by concurrent first callers
+var autoInit sync.Once
+
 func Init() {
 	InitWithLevel("info")
 }
@@ -35,10 +39,12 @@ func parseLogLevel(level string) slog.Level {
 }
 
 func getLogger() *slog.Logger {
-	if Logger == nil {
-		// Auto-initialize if not already initialized
-		Init()
-	}
+	autoInit.Do(func() {
+		if Logger == nil {
+			// Auto-initialize if not already initialized
+			Init()
+		}
+	})
 	return Logger
 }
 
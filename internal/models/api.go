@@ -1,7 +1,71 @@
 package models
 
+import "encoding/json"
+
 // Error represents an API error response
 type Error struct {
 	Error string `json:"error"`
 	Code  int    `json:"code"`
+	// UpstreamDetail describes why the upstream TLS connection failed, if it did
+	UpstreamDetail string `json:"upstream_detail,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details error response (ERROR_FORMAT=problem-json)
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+	// UpstreamDetail describes why the upstream TLS connection failed, if it did
+	UpstreamDetail string `json:"upstream_detail,omitempty"`
+}
+
+// PinResponseV2 is the /v1/pins body for Accept: application/vnd.pinning.v2+json
+type PinResponseV2 struct {
+	Version int      `json:"version"`
+	Domain  string   `json:"domain"`
+	Token   PinToken `json:"token"`
+}
+
+// PinToken is the signed token of a PinResponseV2, in the requested serialization
+type PinToken struct {
+	Serialization string          `json:"serialization"` // compact, json or detached
+	JWS           string          `json:"jws,omitempty"`
+	JWSJSON       json.RawMessage `json:"jws_json,omitempty"`
+	// Payload is the payload signed by a detached jws
+	Payload string `json:"payload,omitempty"`
+	// ExpiresIn is the token lifetime in seconds
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// BatchRequest is the body of POST /v1/pins/batch
+type BatchRequest struct {
+	Domains []string `json:"domains"`
+	// IncludeBackupPins overrides DEFAULT_INCLUDE_BACKUP for every domain when set
+	IncludeBackupPins *bool `json:"include_backup_pins,omitempty"`
+}
+
+// BatchResult is the outcome for one domain of a batch request
+type BatchResult struct {
+	Domain         string `json:"domain"`
+	JWS            string `json:"jws,omitempty"`
+	Error          string `json:"error,omitempty"`
+	Code           int    `json:"code"`
+	UpstreamDetail string `json:"upstream_detail,omitempty"`
+}
+
+// BatchResponse holds one result per requested domain, in request order
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// PinReport is the body of POST /v1/report, sent by a client whose pin
+// validation failed for a domain
+type PinReport struct {
+	Domain       string   `json:"domain"`
+	ExpectedPins []string `json:"expected_pins"`
+	ServedPins   []string `json:"served_pins"`
+	// ClientInfo describes the reporting client (e.g. platform, app version)
+	ClientInfo map[string]string `json:"client_info,omitempty"`
 }
@@ -0,0 +1,233 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: api/proto/pins/v1/pins.proto
+
+package pinspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetPinsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Fully qualified domain name to retrieve certificate pins for.
+	Domain string `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	// Include the backup pin of the intermediate certificate.
+	// Defaults to DEFAULT_INCLUDE_BACKUP when unset, like include-backup-pins.
+	IncludeBackup *bool `protobuf:"varint,2,opt,name=include_backup,json=includeBackup,proto3,oneof" json:"include_backup,omitempty"`
+}
+
+func (x *GetPinsRequest) Reset() {
+	*x = GetPinsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_proto_pins_v1_pins_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetPinsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPinsRequest) ProtoMessage() {}
+
+func (x *GetPinsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_pins_v1_pins_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPinsRequest.ProtoReflect.Descriptor instead.
+func (*GetPinsRequest) Descriptor() ([]byte, []int) {
+	return file_api_proto_pins_v1_pins_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetPinsRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *GetPinsRequest) GetIncludeBackup() bool {
+	if x != nil && x.IncludeBackup != nil {
+		return *x.IncludeBackup
+	}
+	return false
+}
+
+type GetPinsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Compact JWS token, signed with ES256, ES384 or ES512 for the signing key's
+	// curve, or RS256 for an RSA key.
+	Jws string `protobuf:"bytes,1,opt,name=jws,proto3" json:"jws,omitempty"`
+}
+
+func (x *GetPinsResponse) Reset() {
+	*x = GetPinsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_proto_pins_v1_pins_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetPinsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPinsResponse) ProtoMessage() {}
+
+func (x *GetPinsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proto_pins_v1_pins_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPinsResponse.ProtoReflect.Descriptor instead.
+func (*GetPinsResponse) Descriptor() ([]byte, []int) {
+	return file_api_proto_pins_v1_pins_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetPinsResponse) GetJws() string {
+	if x != nil {
+		return x.Jws
+	}
+	return ""
+}
+
+var File_api_proto_pins_v1_pins_proto protoreflect.FileDescriptor
+
+var file_api_proto_pins_v1_pins_proto_rawDesc = []byte{
+	0x0a, 0x1c, 0x61, 0x70, 0x69, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x70, 0x69, 0x6e, 0x73,
+	0x2f, 0x76, 0x31, 0x2f, 0x70, 0x69, 0x6e, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x10,
+	0x64, 0x79, 0x6e, 0x61, 0x70, 0x69, 0x6e, 0x73, 0x2e, 0x70, 0x69, 0x6e, 0x73, 0x2e, 0x76, 0x31,
+	0x22, 0x67, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x50, 0x69, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x2a, 0x0a, 0x0e, 0x69, 0x6e,
+	0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x62, 0x61, 0x63, 0x6b, 0x75, 0x70, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x08, 0x48, 0x00, 0x52, 0x0d, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x42, 0x61, 0x63,
+	0x6b, 0x75, 0x70, 0x88, 0x01, 0x01, 0x42, 0x11, 0x0a, 0x0f, 0x5f, 0x69, 0x6e, 0x63, 0x6c, 0x75,
+	0x64, 0x65, 0x5f, 0x62, 0x61, 0x63, 0x6b, 0x75, 0x70, 0x22, 0x23, 0x0a, 0x0f, 0x47, 0x65, 0x74,
+	0x50, 0x69, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03,
+	0x6a, 0x77, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6a, 0x77, 0x73, 0x32, 0x5c,
+	0x0a, 0x0a, 0x50, 0x69, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x4e, 0x0a, 0x07,
+	0x47, 0x65, 0x74, 0x50, 0x69, 0x6e, 0x73, 0x12, 0x20, 0x2e, 0x64, 0x79, 0x6e, 0x61, 0x70, 0x69,
+	0x6e, 0x73, 0x2e, 0x70, 0x69, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x69,
+	0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e, 0x64, 0x79, 0x6e, 0x61,
+	0x70, 0x69, 0x6e, 0x73, 0x2e, 0x70, 0x69, 0x6e, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74,
+	0x50, 0x69, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x20, 0x5a, 0x1e,
+	0x70, 0x69, 0x6e, 0x6e, 0x69, 0x6e, 0x67, 0x2d, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2f, 0x69,
+	0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x70, 0x69, 0x6e, 0x73, 0x70, 0x62, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_api_proto_pins_v1_pins_proto_rawDescOnce sync.Once
+	file_api_proto_pins_v1_pins_proto_rawDescData = file_api_proto_pins_v1_pins_proto_rawDesc
+)
+
+func file_api_proto_pins_v1_pins_proto_rawDescGZIP() []byte {
+	file_api_proto_pins_v1_pins_proto_rawDescOnce.Do(func() {
+		file_api_proto_pins_v1_pins_proto_rawDescData = protoimpl.X.CompressGZIP(file_api_proto_pins_v1_pins_proto_rawDescData)
+	})
+	return file_api_proto_pins_v1_pins_proto_rawDescData
+}
+
+var file_api_proto_pins_v1_pins_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_api_proto_pins_v1_pins_proto_goTypes = []any{
+	(*GetPinsRequest)(nil),  // 0: dynapins.pins.v1.GetPinsRequest
+	(*GetPinsResponse)(nil), // 1: dynapins.pins.v1.GetPinsResponse
+}
+var file_api_proto_pins_v1_pins_proto_depIdxs = []int32{
+	0, // 0: dynapins.pins.v1.PinService.GetPins:input_type -> dynapins.pins.v1.GetPinsRequest
+	1, // 1: dynapins.pins.v1.PinService.GetPins:output_type -> dynapins.pins.v1.GetPinsResponse
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_api_proto_pins_v1_pins_proto_init() }
+func file_api_proto_pins_v1_pins_proto_init() {
+	if File_api_proto_pins_v1_pins_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_api_proto_pins_v1_pins_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*GetPinsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_proto_pins_v1_pins_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*GetPinsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_api_proto_pins_v1_pins_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_api_proto_pins_v1_pins_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_proto_pins_v1_pins_proto_goTypes,
+		DependencyIndexes: file_api_proto_pins_v1_pins_proto_depIdxs,
+		MessageInfos:      file_api_proto_pins_v1_pins_proto_msgTypes,
+	}.Build()
+	File_api_proto_pins_v1_pins_proto = out.File
+	file_api_proto_pins_v1_pins_proto_rawDesc = nil
+	file_api_proto_pins_v1_pins_proto_goTypes = nil
+	file_api_proto_pins_v1_pins_proto_depIdxs = nil
+}
@@ -0,0 +1,6 @@
+// Package pinspb contains the protobuf messages and gRPC stubs generated from
+// api/proto/pins/v1/pins.proto. Regenerate them with go generate after editing
+// the .proto file (requires protoc, protoc-gen-go and protoc-gen-go-grpc).
+package pinspb
+
+//go:generate protoc --proto_path=../.. --go_out=../.. --go_opt=module=pinning-server --go-grpc_out=../.. --go-grpc_opt=module=pinning-server api/proto/pins/v1/pins.proto
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"pinning-server/internal/logger"
+)
+
+// handleConfig handles GET /v1/admin/config - the effective configuration
+// without secrets, for confirming what a running instance loaded
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !s.isAdmin(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.writeError(w, r, "Unauthorized", http.StatusUnauthorized)
+		logger.Warn("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"status", http.StatusUnauthorized,
+			"error", "admin_unauthorized",
+			"duration_ms", time.Since(start).Milliseconds())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.config.Redacted()); err != nil {
+		logger.Error("Failed to encode config response", "error", err)
+	}
+
+	logger.Info("Request completed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", http.StatusOK,
+		"duration_ms", time.Since(start).Milliseconds())
+}
+
+// isAdmin reports whether r carries the ADMIN_TOKEN bearer token
+func (s *Server) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || s.config.AdminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
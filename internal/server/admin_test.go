@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		enabled        bool
+		authorization  string
+		expectedStatus int
+	}{
+		{"disabled", false, "Bearer admin-secret", http.StatusNotFound},
+		{"missing_token", true, "", http.StatusUnauthorized},
+		{"wrong_token", true, "Bearer wrong", http.StatusUnauthorized},
+		{"not_bearer", true, "admin-secret", http.StatusUnauthorized},
+		{"authorized", true, "Bearer admin-secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, retriever := createTestServer(t)
+			cfg := *base.config
+			cfg.EnableAdminConfig = tt.enabled
+			cfg.AdminToken = "admin-secret"
+			server := NewWithRetriever(&cfg, retriever)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/admin/config", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			body := w.Body.String()
+			privateKey := cfg.PrivateKey.(*ecdsa.PrivateKey)
+			keyDER, err := x509.MarshalECPrivateKey(privateKey)
+			if err != nil {
+				t.Fatalf("Failed to marshal private key: %v", err)
+			}
+			for _, secret := range []string{"admin-secret", "PRIVATE KEY", base64.StdEncoding.EncodeToString(keyDER), privateKey.D.String()} {
+				if strings.Contains(body, secret) {
+					t.Errorf("Config response leaks secret material %q", secret)
+				}
+			}
+
+			var dump map[string]interface{}
+			if err := json.Unmarshal([]byte(body), &dump); err != nil {
+				t.Fatalf("Failed to decode config response: %v", err)
+			}
+			if dump["signature_lifetime"] != cfg.SignatureLifetime.String() {
+				t.Errorf("Expected signature_lifetime %s, got %v", cfg.SignatureLifetime, dump["signature_lifetime"])
+			}
+			if dump["allowed_domains_count"] != float64(len(cfg.AllowedDomains)) {
+				t.Errorf("Expected allowed_domains_count %d, got %v", len(cfg.AllowedDomains), dump["allowed_domains_count"])
+			}
+			if dump["allow_self_signed"] != cfg.AllowSelfSigned {
+				t.Errorf("Expected allow_self_signed %v, got %v", cfg.AllowSelfSigned, dump["allow_self_signed"])
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"pinning-server/internal/logger"
+)
+
+// alertTimeout bounds each webhook delivery
+const alertTimeout = 5 * time.Second
+
+// failureAlert is the JSON body POSTed to ALERT_WEBHOOK_URL
+type failureAlert struct {
+	Domain              string `json:"domain"`
+	Error               string `json:"error"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Timestamp           int64  `json:"timestamp"`
+}
+
+// failureAlerter counts consecutive certificate fetch failures of the tracked
+// domains and POSTs an alert to a webhook once a domain reaches the threshold.
+// It alerts again only after a success has reset the domain's count.
+type failureAlerter struct {
+	webhookURL string
+	threshold  int
+	tracked    map[string]bool // Lowercased domains to track
+	client     *http.Client
+
+	mu       sync.Mutex
+	failures map[string]int
+
+	// wg tracks deliveries in flight (waited on by tests)
+	wg sync.WaitGroup
+}
+
+// newFailureAlerter creates an alerter for the exact (non-wildcard) whitelist
+// entries and the warmup domains, or returns nil when no webhook is configured
+func newFailureAlerter(webhookURL string, threshold int, allowedDomains, warmupDomains []string) *failureAlerter {
+	if webhookURL == "" {
+		return nil
+	}
+
+	tracked := make(map[string]bool)
+	for _, d := range append(append([]string{}, allowedDomains...), warmupDomains...) {
+		if !strings.Contains(d, "*") {
+			tracked[strings.ToLower(d)] = true
+		}
+	}
+
+	return &failureAlerter{
+		webhookURL: webhookURL,
+		threshold:  threshold,
+		tracked:    tracked,
+		client:     &http.Client{Timeout: alertTimeout},
+		failures:   make(map[string]int),
+	}
+}
+
+// recordFailure counts a failed fetch and sends the alert when the count reaches the threshold
+func (a *failureAlerter) recordFailure(domain string, fetchErr error) {
+	key := strings.ToLower(domain)
+	if a == nil || !a.tracked[key] {
+		return
+	}
+
+	a.mu.Lock()
+	a.failures[key]++
+	count := a.failures[key]
+	a.mu.Unlock()
+
+	if count != a.threshold {
+		return
+	}
+
+	logger.Warn("Domain fetch failing repeatedly, sending alert",
+		"domain", domain,
+		"consecutive_failures", count,
+		"error", fetchErr)
+
+	// Deliver in the background so the failing request is not delayed further
+	alert := failureAlert{
+		Domain:              domain,
+		Error:               fetchErr.Error(),
+		ConsecutiveFailures: count,
+		Timestamp:           time.Now().Unix(),
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.send(alert)
+	}()
+}
+
+// recordSuccess resets the domain's failure count
+func (a *failureAlerter) recordSuccess(domain string) {
+	key := strings.ToLower(domain)
+	if a == nil || !a.tracked[key] {
+		return
+	}
+
+	a.mu.Lock()
+	delete(a.failures, key)
+	a.mu.Unlock()
+}
+
+// send POSTs the alert to the webhook, logging delivery failures
+func (a *failureAlerter) send(alert failureAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		logger.Error("Failed to encode alert", "domain", alert.Domain, "error", err)
+		return
+	}
+
+	resp, err := a.client.Post(a.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Error("Failed to deliver alert", "domain", alert.Domain, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Error("Alert webhook rejected alert", "domain", alert.Domain, "status", resp.StatusCode)
+	}
+}
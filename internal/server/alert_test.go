@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"pinning-server/internal/cert"
+)
+
+// alertRecorder is a webhook endpoint recording the alerts it receives
+type alertRecorder struct {
+	mu     sync.Mutex
+	alerts []failureAlert
+}
+
+func (a *alertRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var alert failureAlert
+	if err := json.NewDecoder(r.Body).Decode(&alert); err == nil {
+		a.mu.Lock()
+		a.alerts = append(a.alerts, alert)
+		a.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *alertRecorder) received() []failureAlert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]failureAlert(nil), a.alerts...)
+}
+
+func TestFailureAlert_FiresOnceAtThreshold(t *testing.T) {
+	recorder := &alertRecorder{}
+	webhook := httptest.NewServer(recorder)
+	defer webhook.Close()
+
+	base, retriever := createTestServer(t)
+	cfg := *base.config
+	cfg.AlertWebhookURL = webhook.URL
+	cfg.AlertFailureThreshold = 3
+	server := NewWithRetriever(&cfg, retriever)
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	fetch := func(times int) {
+		for range times {
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			server.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		server.alerts.wg.Wait()
+	}
+
+	retriever.SetError(errors.New("connection refused"))
+	fetch(2)
+	if got := recorder.received(); len(got) != 0 {
+		t.Fatalf("Expected no alert below the threshold, got %v", got)
+	}
+
+	fetch(3)
+	got := recorder.received()
+	if len(got) != 1 {
+		t.Fatalf("Expected exactly 1 alert after 5 failures with threshold 3, got %d", len(got))
+	}
+	if got[0].Domain != "example.com" || got[0].Error != "connection refused" || got[0].ConsecutiveFailures != 3 {
+		t.Errorf("Unexpected alert: %+v", got[0])
+	}
+
+	// A success resets the count, so the next run of failures alerts again
+	retriever.SetError(nil)
+	fetch(1)
+	retriever.SetError(errors.New("connection refused"))
+	fetch(3)
+	if got := recorder.received(); len(got) != 2 {
+		t.Errorf("Expected a second alert after the count was reset, got %d alerts", len(got))
+	}
+}
+
+func TestFailureAlert_UntrackedDomain(t *testing.T) {
+	recorder := &alertRecorder{}
+	webhook := httptest.NewServer(recorder)
+	defer webhook.Close()
+
+	// Only exact whitelist entries and warmup domains are tracked
+	base, retriever := createTestServerWithFakeRetriever(t, []string{"*.example.com"})
+	cfg := *base.config
+	cfg.AlertWebhookURL = webhook.URL
+	cfg.AlertFailureThreshold = 1
+	server := NewWithRetriever(&cfg, retriever)
+
+	retriever.SetError(errors.New("connection refused"))
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=api.example.com", nil)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	server.alerts.wg.Wait()
+
+	if got := recorder.received(); len(got) != 0 {
+		t.Errorf("Expected no alert for an untracked domain, got %v", got)
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"pinning-server/internal/logger"
+	"pinning-server/internal/models"
+)
+
+// defaultBatchConcurrency bounds the upstream fetches of one batch request
+const defaultBatchConcurrency = 8
+
+// maxBatchDomains caps the number of domains in one batch request
+const maxBatchDomains = 100
+
+// maxBatchBodyBytes caps the size of a batch request body
+const maxBatchBodyBytes = 64 << 10
+
+// ndjsonMediaType selects a streamed batch response with one result per line
+const ndjsonMediaType = "application/x-ndjson"
+
+// handleBatchPins handles POST /v1/pins/batch with a JSON body {"domains": [...]}
+// Every domain gets its own result (token or error), in request order, or in
+// completion order as newline-delimited JSON when the client accepts ndjsonMediaType
+func (s *Server) handleBatchPins(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	headers := s.requestHeaderAttr(r)
+
+	var req models.BatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)).Decode(&req); err != nil {
+		s.writeError(w, r, "Invalid batch request body", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", http.StatusBadRequest,
+			"error", "invalid_batch_body",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+	if len(req.Domains) == 0 || len(req.Domains) > maxBatchDomains {
+		s.writeError(w, r, "Batch must contain between 1 and 100 domains", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", http.StatusBadRequest,
+			"error", "invalid_batch_size",
+			"domains", len(req.Domains),
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	if ok, retryAfter := s.distinct.allow(clientKey(r.RemoteAddr), req.Domains...); !ok {
+		s.writeTooManyDomains(w, r, retryAfter)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", http.StatusTooManyRequests,
+			"error", "too_many_distinct_domains",
+			"remote_addr", r.RemoteAddr,
+			"domains", len(req.Domains),
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	includeBackup := s.config.DefaultIncludeBackup
+	if req.IncludeBackupPins != nil {
+		includeBackup = *req.IncludeBackupPins
+	}
+
+	w.Header().Set("Vary", "Accept")
+
+	if acceptsNDJSON(r) {
+		failed := s.streamPinsBatch(w, req.Domains, includeBackup)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", http.StatusOK,
+			"domains", len(req.Domains),
+			"failed", failed,
+			"include_backup", includeBackup,
+			"streamed", true,
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	results := s.issuePinsBatch(req.Domains, includeBackup)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(models.BatchResponse{Results: results}); err != nil {
+		logger.Error("Failed to encode batch response", "error", err)
+	}
+
+	logger.Info("Request completed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", http.StatusOK,
+		"domains", len(results),
+		"failed", failed,
+		"include_backup", includeBackup,
+		"duration_ms", time.Since(start).Milliseconds(),
+		headers)
+}
+
+// issuePinsBatch issues a token for each domain, running at most
+// BATCH_CONCURRENCY upstream fetches at once. Results keep the order of domains.
+func (s *Server) issuePinsBatch(domains []string, includeBackup bool) []models.BatchResult {
+	results := make([]models.BatchResult, len(domains))
+	s.runPinsBatch(domains, includeBackup, func(i int, result models.BatchResult) {
+		results[i] = result
+	})
+	return results
+}
+
+// streamPinsBatch writes each result to w as a line of JSON as soon as it
+// completes, flushing after every line, and returns the number of failures
+func (s *Server) streamPinsBatch(w http.ResponseWriter, domains []string, includeBackup bool) int {
+	results := make(chan models.BatchResult)
+	go func() {
+		s.runPinsBatch(domains, includeBackup, func(_ int, result models.BatchResult) {
+			results <- result
+		})
+		close(results)
+	}()
+
+	w.Header().Set("Content-Type", ndjsonMediaType)
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+	failed := 0
+	var writeErr error
+	for result := range results {
+		if result.Error != "" {
+			failed++
+		}
+		// Keep draining after a write error so every worker finishes
+		if writeErr != nil {
+			continue
+		}
+		if writeErr = encoder.Encode(result); writeErr != nil {
+			logger.Error("Failed to stream batch result", "domain", result.Domain, "error", writeErr)
+			continue
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			writeErr = err
+		}
+	}
+	return failed
+}
+
+// runPinsBatch issues a token for each domain, running at most
+// BATCH_CONCURRENCY upstream fetches at once, and passes each result with the
+// index of its domain to emit. emit is called concurrently, in completion order.
+func (s *Server) runPinsBatch(domains []string, includeBackup bool, emit func(int, models.BatchResult)) {
+	slots := make(chan struct{}, s.batchConcurrency())
+
+	var wg sync.WaitGroup
+	for i, domain := range domains {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			emit(i, s.batchResult(domain, includeBackup))
+		}()
+	}
+	wg.Wait()
+}
+
+// acceptsNDJSON reports whether the Accept header of r names ndjsonMediaType
+func acceptsNDJSON(r *http.Request) bool {
+	for _, part := range strings.Split(strings.Join(r.Header.Values("Accept"), ","), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == ndjsonMediaType && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// batchResult issues the token for one domain of a batch
+func (s *Server) batchResult(domain string, includeBackup bool) models.BatchResult {
+	result, pinErr := s.issuePins(pinRequest{
+		domain:        domain,
+		includeBackup: includeBackup,
+		pinType:       pinTypeSPKI,
+	})
+	if pinErr != nil {
+		return models.BatchResult{
+			Domain:         domain,
+			Error:          pinErr.message,
+			Code:           pinErr.status,
+			UpstreamDetail: pinErr.detail,
+		}
+	}
+	return models.BatchResult{Domain: domain, JWS: result.jws, Code: http.StatusOK}
+}
+
+// batchConcurrency returns the configured batch worker pool size
+func (s *Server) batchConcurrency() int {
+	if s.config.BatchConcurrency <= 0 {
+		return defaultBatchConcurrency
+	}
+	return s.config.BatchConcurrency
+}
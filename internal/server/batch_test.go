@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pinning-server/internal/cert"
+	"pinning-server/internal/models"
+)
+
+// concurrencyRetriever records the highest number of concurrent retrievals
+type concurrencyRetriever struct {
+	certs   map[string][]*x509.Certificate
+	active  atomic.Int32
+	highest atomic.Int32
+}
+
+func (c *concurrencyRetriever) GetCertificates(domain string) ([]*x509.Certificate, error) {
+	n := c.active.Add(1)
+	defer c.active.Add(-1)
+	for {
+		highest := c.highest.Load()
+		if n <= highest || c.highest.CompareAndSwap(highest, n) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	certs, ok := c.certs[domain]
+	if !ok {
+		return nil, fmt.Errorf("no certificates configured for domain: %s", domain)
+	}
+	return certs, nil
+}
+
+func (c *concurrencyRetriever) GetCertificatesWithOptions(domain string, opts cert.FetchOptions) ([]*x509.Certificate, error) {
+	return c.GetCertificates(domain)
+}
+
+// TestBatchPins_ConcurrencyAndOrder tests that a batch larger than BATCH_CONCURRENCY
+// never exceeds the limit and returns results in request order
+func TestBatchPins_ConcurrencyAndOrder(t *testing.T) {
+	const limit = 3
+	const total = 20
+
+	var domains []string
+	retriever := &concurrencyRetriever{certs: make(map[string][]*x509.Certificate)}
+	for i := 0; i < total; i++ {
+		domain := fmt.Sprintf("host%d.example.com", i)
+		domains = append(domains, domain)
+		if i == 7 {
+			continue // left without certificates to produce a failure mid-batch
+		}
+		testCert, err := cert.GenerateTestCertificate(domain)
+		if err != nil {
+			t.Fatalf("Failed to generate test certificate: %v", err)
+		}
+		retriever.certs[domain] = []*x509.Certificate{testCert}
+	}
+
+	base, _ := createTestServerWithFakeRetriever(t, domains)
+	cfg := *base.config
+	cfg.BatchConcurrency = limit
+	server := NewWithRetriever(&cfg, retriever)
+
+	// A domain outside the whitelist fails without an upstream fetch
+	requested := append(append([]string{}, domains...), "other.com")
+	body, err := json.Marshal(models.BatchRequest{Domains: requested})
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/pins/batch", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp models.BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Results) != len(requested) {
+		t.Fatalf("Expected %d results, got %d", len(requested), len(resp.Results))
+	}
+
+	for i, result := range resp.Results {
+		if result.Domain != requested[i] {
+			t.Errorf("Result %d: expected domain %s, got %s", i, requested[i], result.Domain)
+			continue
+		}
+		switch {
+		case i == 7:
+			if result.Code != http.StatusUnprocessableEntity || result.JWS != "" {
+				t.Errorf("Result %d: expected 422 without token, got %+v", i, result)
+			}
+		case result.Domain == "other.com":
+			if result.Code != http.StatusForbidden || result.Error == "" {
+				t.Errorf("Result %d: expected 403 with error, got %+v", i, result)
+			}
+		default:
+			if result.Code != http.StatusOK || result.Error != "" {
+				t.Errorf("Result %d: expected success, got %+v", i, result)
+				continue
+			}
+			if payload := decodeJWSPayload(t, result.JWS); payload["domain"] != requested[i] {
+				t.Errorf("Result %d: expected token for %s, got %v", i, requested[i], payload["domain"])
+			}
+		}
+	}
+
+	if highest := retriever.highest.Load(); highest > limit {
+		t.Errorf("Expected at most %d concurrent fetches, got %d", limit, highest)
+	}
+}
+
+// TestBatchPins_NDJSON tests that Accept: application/x-ndjson streams one
+// JSON object per requested domain
+func TestBatchPins_NDJSON(t *testing.T) {
+	var domains []string
+	retriever := &concurrencyRetriever{certs: make(map[string][]*x509.Certificate)}
+	for i := 0; i < 5; i++ {
+		domain := fmt.Sprintf("host%d.example.com", i)
+		domains = append(domains, domain)
+		testCert, err := cert.GenerateTestCertificate(domain)
+		if err != nil {
+			t.Fatalf("Failed to generate test certificate: %v", err)
+		}
+		retriever.certs[domain] = []*x509.Certificate{testCert}
+	}
+
+	base, _ := createTestServerWithFakeRetriever(t, domains)
+	server := NewWithRetriever(base.config, retriever)
+
+	requested := append(append([]string{}, domains...), "other.com")
+	body, err := json.Marshal(models.BatchRequest{Domains: requested})
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/pins/batch", bytes.NewReader(body))
+	req.Header.Set("Accept", "application/x-ndjson")
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected Content-Type application/x-ndjson, got %q", ct)
+	}
+	if !w.Flushed {
+		t.Error("Expected the stream to be flushed")
+	}
+
+	seen := make(map[string]models.BatchResult)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var result models.BatchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to decode line %q: %v", scanner.Text(), err)
+		}
+		if _, dup := seen[result.Domain]; dup {
+			t.Errorf("Duplicate result for %s", result.Domain)
+		}
+		seen[result.Domain] = result
+	}
+	if len(seen) != len(requested) {
+		t.Fatalf("Expected %d results, got %d", len(requested), len(seen))
+	}
+
+	for _, domain := range domains {
+		if result := seen[domain]; result.Code != http.StatusOK || result.JWS == "" {
+			t.Errorf("Expected token for %s, got %+v", domain, result)
+		}
+	}
+	if result := seen["other.com"]; result.Code != http.StatusForbidden || result.Error == "" {
+		t.Errorf("Expected 403 with error for other.com, got %+v", result)
+	}
+}
+
+func TestBatchPins_InvalidRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{"wrong_method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"malformed_body", http.MethodPost, "{", http.StatusBadRequest},
+		{"empty_batch", http.MethodPost, `{"domains": []}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := createTestServer(t)
+
+			req := httptest.NewRequest(tt.method, "/v1/pins/batch", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
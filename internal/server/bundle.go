@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"pinning-server/internal/crypto"
+	"pinning-server/internal/logger"
+)
+
+// handleBundle handles GET /v1/bundle?domain=example.com
+// It returns the server's verification key as a JWKS together with the signed
+// pins for the domain, so a client can bootstrap from cold in a single fetch
+func (s *Server) handleBundle(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	headers := s.requestHeaderAttr(r)
+
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		s.writeError(w, r, "Missing required query parameter: domain", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", http.StatusBadRequest,
+			"error", "missing_domain",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	includeBackup := s.config.DefaultIncludeBackup
+	if includeBackupStr := r.URL.Query().Get("include-backup-pins"); includeBackupStr != "" {
+		includeBackup = includeBackupStr == "true"
+	}
+
+	fetchOpts, errMsg := s.parseFetchOptions(r)
+	if errMsg != "" {
+		s.writeError(w, r, errMsg, http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_fetch_options",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	// Do not let one client scan arbitrary numbers of domains
+	if ok, retryAfter := s.distinct.allow(clientKey(r.RemoteAddr), domain); !ok {
+		s.writeTooManyDomains(w, r, retryAfter)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusTooManyRequests,
+			"error", "too_many_distinct_domains",
+			"remote_addr", r.RemoteAddr,
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	result, pinErr := s.issuePins(pinRequest{
+		domain:        domain,
+		includeBackup: includeBackup,
+		fetchOpts:     fetchOpts,
+		pinType:       pinTypeSPKI,
+	})
+	if pinErr != nil {
+		s.writePinError(w, r, pinErr)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", pinErr.status,
+			"error", pinErr.reason,
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	// The key set names the same kid as the JWS header
+	jwks, err := crypto.PublicJWKS(s.config.PublicKey, s.keyID)
+	if err != nil {
+		logger.Error("Failed to create JWKS", "error", err)
+		s.writeError(w, r, "Failed to generate key set", http.StatusInternalServerError)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusInternalServerError,
+			"error", "jwks_creation_failed",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.setPinCacheHeaders(w, 0, "")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"jwks":             jwks,
+		s.jwsResponseKey(): result.jws,
+	}); err != nil {
+		logger.Error("Failed to encode bundle response", "error", err)
+	}
+
+	logger.Info("Request completed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"domain", domain,
+		"status", http.StatusOK,
+		"pin_count", len(result.pins),
+		"include_backup", includeBackup,
+		"leaf_sha256_fingerprint", result.leafFingerprint,
+		"duration_ms", time.Since(start).Milliseconds(),
+		connectionInfoAttr(result.connInfo),
+		headers)
+}
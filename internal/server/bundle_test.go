@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+
+	"pinning-server/internal/cert"
+)
+
+// TestHandleBundle tests that the bundle carries the key set verifying its JWS
+func TestHandleBundle(t *testing.T) {
+	server, retriever := createTestServer(t)
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/bundle?domain=example.com", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		JWKS json.RawMessage `json:"jwks"`
+		JWS  string          `json:"jws"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.JWKS) == 0 || resp.JWS == "" {
+		t.Fatalf("Expected both jwks and jws, got %+v", resp)
+	}
+
+	set, err := jwk.Parse(resp.JWKS)
+	if err != nil {
+		t.Fatalf("Failed to parse jwks: %v", err)
+	}
+	if set.Len() != 1 {
+		t.Fatalf("Expected 1 key in jwks, got %d", set.Len())
+	}
+	key, _ := set.Key(0)
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(strings.Split(resp.JWS, ".")[0])
+	if err != nil {
+		t.Fatalf("Failed to decode JWS header: %v", err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		t.Fatalf("Failed to parse JWS header: %v", err)
+	}
+	if header["kid"] != key.KeyID() {
+		t.Errorf("Expected jwks kid %q to match JWS kid %v", key.KeyID(), header["kid"])
+	}
+
+	// A cold client needs nothing but the bundle to verify the pins
+	if _, err := jws.Verify([]byte(resp.JWS), jws.WithKeySet(set)); err != nil {
+		t.Errorf("Failed to verify JWS with the bundled jwks: %v", err)
+	}
+	if got := decodeJWSPayload(t, resp.JWS)["domain"]; got != "example.com" {
+		t.Errorf("Expected domain claim example.com, got %v", got)
+	}
+}
+
+// TestHandleBundle_Errors tests that bundle requests fail like pin requests
+func TestHandleBundle_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{"missing_domain", "", http.StatusBadRequest},
+		{"not_whitelisted", "?domain=other.com", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := createTestServer(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/bundle"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if strings.Contains(w.Body.String(), "jwks") {
+				t.Error("Failed bundle requests must not return a key set")
+			}
+		})
+	}
+}
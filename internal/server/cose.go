@@ -0,0 +1,37 @@
+package server
+
+import (
+	stdcrypto "crypto"
+	"net/http"
+	"time"
+
+	"pinning-server/internal/crypto"
+	"pinning-server/internal/logger"
+)
+
+// formatCOSE is the format parameter value returning pins as a COSE_Sign1 token
+const formatCOSE = "cose"
+
+// coseMediaType is the Content-Type of COSE_Sign1 responses (RFC 9052)
+const coseMediaType = `application/cose; cose-type="cose-sign1"`
+
+// signPinsCOSE creates the signed COSE_Sign1 token for the given pins
+func (s *Server) signPinsCOSE(domain string, pins []string, lifetime time.Duration, extraClaims map[string]interface{}) (*pinResult, *pinError) {
+	claims := s.responseClaims(extraClaims, lifetime)
+
+	token, pinErr := retrySign(s, domain, "COSE", func(key stdcrypto.Signer, keyID string) ([]byte, error) {
+		return s.createCOSE(key, keyID, domain, pins, lifetime, claims)
+	})
+	if pinErr != nil {
+		return nil, pinErr
+	}
+	if s.config.VerifyAfterSign {
+		if _, err := crypto.VerifyCOSE(s.config.PublicKey, token); err != nil {
+			logger.Error("Issued token failed verification", "domain", domain, "error", err)
+			return nil, &pinError{status: http.StatusInternalServerError, message: "Failed to generate signed token", reason: "cose_verification_failed"}
+		}
+	}
+	s.tokensIssued.Add(1)
+
+	return &pinResult{cose: token, pins: pins}, nil
+}
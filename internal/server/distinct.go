@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTrackedClients bounds the clients whose distinct domains are tracked at once
+const maxTrackedClients = 10000
+
+// clientDomains holds the distinct domains one client queried in its current window
+type clientDomains struct {
+	windowStart time.Time
+	domains     map[string]struct{}
+}
+
+// distinctDomainLimiter caps the distinct domains each client may query per
+// window (MAX_DISTINCT_DOMAINS_PER_CLIENT), so the server cannot be used as a
+// mass certificate scanner. Repeat queries for a known domain are always allowed.
+type distinctDomainLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*clientDomains
+}
+
+// newDistinctDomainLimiter creates a limiter, or returns nil when limit is 0
+func newDistinctDomainLimiter(limit int, window time.Duration) *distinctDomainLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &distinctDomainLimiter{
+		limit:   limit,
+		window:  window,
+		now:     time.Now,
+		clients: make(map[string]*clientDomains),
+	}
+}
+
+// allow records domains for client and reports whether they fit within the
+// client's limit. Either all domains are admitted or none are; when rejected,
+// retryAfter is the time left in the client's window.
+func (l *distinctDomainLimiter) allow(client string, domains ...string) (ok bool, retryAfter time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, found := l.clients[client]
+	if !found || now.Sub(entry.windowStart) >= l.window {
+		if !found && len(l.clients) >= maxTrackedClients {
+			l.evict(now)
+		}
+		entry = &clientDomains{windowStart: now, domains: make(map[string]struct{})}
+		l.clients[client] = entry
+	}
+
+	var added []string
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(d), ".")
+		if _, seen := entry.domains[d]; seen {
+			continue
+		}
+		entry.domains[d] = struct{}{}
+		added = append(added, d)
+	}
+
+	if len(entry.domains) > l.limit {
+		for _, d := range added {
+			delete(entry.domains, d)
+		}
+		return false, entry.windowStart.Add(l.window).Sub(now)
+	}
+	return true, 0
+}
+
+// evict drops clients whose window has ended, or the oldest client if none has
+// Callers must hold l.mu
+func (l *distinctDomainLimiter) evict(now time.Time) {
+	var oldest string
+	for client, entry := range l.clients {
+		if now.Sub(entry.windowStart) >= l.window {
+			delete(l.clients, client)
+			continue
+		}
+		if oldest == "" || entry.windowStart.Before(l.clients[oldest].windowStart) {
+			oldest = client
+		}
+	}
+	if len(l.clients) >= maxTrackedClients {
+		delete(l.clients, oldest)
+	}
+}
+
+// clientKey identifies the client of a request by its IP address
+func clientKey(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
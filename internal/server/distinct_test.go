@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"pinning-server/internal/cert"
+)
+
+func TestHandleGetPins_MaxDistinctDomainsPerClient(t *testing.T) {
+	const limit = 3
+	domains := make([]string, limit+2)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("host%d.example.com", i)
+	}
+
+	base, retriever := createTestServerWithFakeRetriever(t, domains)
+	cfg := *base.config
+	cfg.MaxDistinctDomainsPerClient = limit
+	cfg.DistinctDomainsWindow = time.Hour
+	server := NewWithRetriever(&cfg, retriever)
+
+	for _, domain := range domains {
+		testCert, err := cert.GenerateTestCertificate(domain)
+		if err != nil {
+			t.Fatalf("Failed to generate test certificate: %v", err)
+		}
+		retriever.SetCertificates(domain, []*x509.Certificate{testCert})
+	}
+
+	get := func(domain, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain="+domain, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, domain := range domains {
+		w := get(domain, "203.0.113.7:40000")
+		if i < limit && w.Code != http.StatusOK {
+			t.Fatalf("Domain %d: expected status 200 within the limit, got %d", i, w.Code)
+		}
+		if i >= limit {
+			if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("Domain %d: expected status 429 above the limit, got %d", i, w.Code)
+			}
+			if w.Header().Get("Retry-After") == "" {
+				t.Error("Expected a Retry-After header on 429")
+			}
+		}
+	}
+
+	// Domains already queried stay available, from any source port
+	if w := get(domains[0], "203.0.113.7:40001"); w.Code != http.StatusOK {
+		t.Errorf("Expected a repeat query to succeed, got %d", w.Code)
+	}
+
+	// Other clients are counted separately
+	if w := get(domains[limit], "198.51.100.2:40000"); w.Code != http.StatusOK {
+		t.Errorf("Expected another client to be unaffected, got %d", w.Code)
+	}
+
+	// Batches count every domain and are rejected as a whole
+	body := fmt.Sprintf(`{"domains": [%q, %q]}`, domains[0], domains[limit+1])
+	req := httptest.NewRequest(http.MethodPost, "/v1/pins/batch", bytes.NewBufferString(body))
+	req.RemoteAddr = "203.0.113.7:40002"
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected batch above the limit to get 429, got %d", w.Code)
+	}
+}
+
+func TestHandlePinCheck_MaxDistinctDomainsPerClient(t *testing.T) {
+	domains := []string{"a.example.com", "b.example.com"}
+	base, retriever := createTestServerWithFakeRetriever(t, domains)
+	cfg := *base.config
+	cfg.MaxDistinctDomainsPerClient = 1
+	cfg.DistinctDomainsWindow = time.Hour
+	server := NewWithRetriever(&cfg, retriever)
+
+	for _, domain := range domains {
+		testCert, err := cert.GenerateTestCertificate(domain)
+		if err != nil {
+			t.Fatalf("Failed to generate test certificate: %v", err)
+		}
+		retriever.SetCertificates(domain, []*x509.Certificate{testCert})
+	}
+
+	pin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	check := func(domain string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins/check?domain="+domain+"&pin="+url.QueryEscape(pin), nil)
+		req.RemoteAddr = "203.0.113.7:40000"
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := check(domains[0]); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 within the limit, got %d", w.Code)
+	}
+	w := check(domains[1])
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 above the limit, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header on 429")
+	}
+}
+
+func TestDistinctDomainLimiter_Window(t *testing.T) {
+	now := time.Now()
+	l := newDistinctDomainLimiter(1, time.Minute)
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.allow("client", "a.example.com"); !ok {
+		t.Fatal("Expected the first domain to be allowed")
+	}
+	if ok, _ := l.allow("client", "A.Example.com."); !ok {
+		t.Error("Expected the same domain in another spelling to be allowed")
+	}
+
+	now = now.Add(20 * time.Second)
+	ok, retryAfter := l.allow("client", "b.example.com")
+	if ok {
+		t.Fatal("Expected a second distinct domain to be rejected")
+	}
+	if retryAfter != 40*time.Second {
+		t.Errorf("Expected retry after 40s, got %v", retryAfter)
+	}
+
+	// A new window starts once the old one has ended
+	now = now.Add(40 * time.Second)
+	if ok, _ := l.allow("client", "b.example.com"); !ok {
+		t.Error("Expected the domain to be allowed in a new window")
+	}
+}
+
+func TestDistinctDomainLimiter_BoundedClients(t *testing.T) {
+	now := time.Now()
+	l := newDistinctDomainLimiter(1, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := range maxTrackedClients + 10 {
+		now = now.Add(time.Millisecond)
+		l.allow(fmt.Sprintf("client-%d", i), "example.com")
+	}
+	if len(l.clients) > maxTrackedClients {
+		t.Errorf("Expected at most %d tracked clients, got %d", maxTrackedClients, len(l.clients))
+	}
+	if _, found := l.clients["client-0"]; found {
+		t.Error("Expected the oldest client to be evicted")
+	}
+}
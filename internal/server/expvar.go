@@ -0,0 +1,54 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// expvarKey names the server's metrics in the /debug/vars document
+const expvarKey = "pinning_server"
+
+// cacheStatter is implemented by retrievers that count certificate cache lookups
+type cacheStatter interface {
+	CacheStats() (hits, misses uint64)
+}
+
+// newExpvarMap builds the metrics served under /debug/vars. The map is kept per
+// server rather than published globally, since expvar.Publish panics when a
+// second server (e.g. in tests) registers the same name.
+func (s *Server) newExpvarMap() *expvar.Map {
+	vars := new(expvar.Map).Init()
+	vars.Set("tokens_issued", expvar.Func(func() any { return s.tokensIssued.Load() }))
+	vars.Set("requests_in_flight", expvar.Func(func() any { return s.requestsInFlight.Load() }))
+
+	if stats, ok := s.retriever.(cacheStatter); ok {
+		vars.Set("cache_hits", expvar.Func(func() any {
+			hits, _ := stats.CacheStats()
+			return hits
+		}))
+		vars.Set("cache_misses", expvar.Func(func() any {
+			_, misses := stats.CacheStats()
+			return misses
+		}))
+		vars.Set("cache_hit_ratio", expvar.Func(func() any {
+			hits, misses := stats.CacheStats()
+			if hits+misses == 0 {
+				return 0.0
+			}
+			return float64(hits) / float64(hits+misses)
+		}))
+	}
+	return vars
+}
+
+// handleExpvar handles GET /debug/vars, serving the standard expvar variables
+// (cmdline, memstats) and the server's metrics under expvarKey
+func (s *Server) handleExpvar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "%q: %s\n}\n", expvarKey, s.vars)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"pinning-server/internal/cert"
+)
+
+func TestExpvar(t *testing.T) {
+	base, _ := createTestServer(t)
+	cfg := *base.config
+	cfg.EnableExpvar = true
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	cache := cert.NewMemoryCache()
+	cache.Set("example.com", []*x509.Certificate{testCert}, time.Minute)
+	retriever := cert.NewRetriever(time.Second, time.Minute)
+	retriever.SetCache(cache)
+	server := NewWithRetriever(&cfg, retriever)
+
+	readVars := func() map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode /debug/vars: %v\n%s", err, w.Body.String())
+		}
+		if _, ok := body["memstats"]; !ok {
+			t.Error("Expected the standard memstats variable")
+		}
+		var vars map[string]interface{}
+		if err := json.Unmarshal(body[expvarKey], &vars); err != nil {
+			t.Fatalf("Failed to decode %s variables: %v", expvarKey, err)
+		}
+		return vars
+	}
+
+	vars := readVars()
+	if vars["tokens_issued"] != float64(0) || vars["cache_hits"] != float64(0) || vars["cache_hit_ratio"] != float64(0) {
+		t.Errorf("Expected zeroed metrics before any request, got %v", vars)
+	}
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	vars = readVars()
+	if vars["tokens_issued"] != float64(2) {
+		t.Errorf("Expected 2 tokens issued, got %v", vars["tokens_issued"])
+	}
+	if vars["cache_hits"] != float64(2) || vars["cache_misses"] != float64(0) || vars["cache_hit_ratio"] != float64(1) {
+		t.Errorf("Expected 2 cache hits and a hit ratio of 1, got %v", vars)
+	}
+	// The /debug/vars request itself is in flight while the snapshot is taken
+	if vars["requests_in_flight"] != float64(1) {
+		t.Errorf("Expected 1 request in flight, got %v", vars["requests_in_flight"])
+	}
+}
+
+func TestExpvar_Disabled(t *testing.T) {
+	server, _ := createTestServer(t)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
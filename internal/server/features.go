@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+
+	"pinning-server/internal/logger"
+)
+
+// compiledFeatures reports which optional features are built into the binary,
+// independent of whether the configuration enables them. Features that can be
+// left out with a build tag take their value from the tagged files.
+var compiledFeatures = map[string]bool{
+	"jws_json":        true,
+	"detached_jws":    true,
+	"cose":            true,
+	"grpc":            true,
+	"ats_plist":       true,
+	"android_nsc":     true,
+	"starttls":        true,
+	"expvar":          true,
+	"cert_cache_file": true,
+	"pprof":           pprofCompiled,
+	// No external certificate cache backend is built in yet
+	"redis_cache": false,
+}
+
+// Features returns the optional features compiled into the binary
+func Features() map[string]bool {
+	return maps.Clone(compiledFeatures)
+}
+
+// handleFeatures handles GET /v1/features
+// It reports which optional features this build supports, so operators can
+// tell images apart; use /v1/admin/config for what is actually enabled
+func (s *Server) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]map[string]bool{
+		"features": compiledFeatures,
+	}); err != nil {
+		logger.Error("Failed to encode features response", "error", err)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleFeatures tests that the default build reports the core features
+func TestHandleFeatures(t *testing.T) {
+	server, _ := createTestServer(t)
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/features", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", ct)
+	}
+
+	var resp struct {
+		Features map[string]bool `json:"features"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	for _, feature := range []string{"jws_json", "detached_jws", "cose", "grpc", "ats_plist", "android_nsc", "starttls", "expvar", "cert_cache_file"} {
+		if !resp.Features[feature] {
+			t.Errorf("Expected core feature %q to be reported present, got %v", feature, resp.Features)
+		}
+	}
+	if resp.Features["pprof"] != pprofCompiled {
+		t.Errorf("Expected pprof %v, got %v", pprofCompiled, resp.Features["pprof"])
+	}
+	if present, ok := resp.Features["redis_cache"]; !ok || present {
+		t.Errorf("Expected redis_cache to be reported absent, got %v (listed: %v)", present, ok)
+	}
+}
+
+// TestFeatures_ReturnsCopy tests that callers cannot alter the reported features
+func TestFeatures_ReturnsCopy(t *testing.T) {
+	features := Features()
+	features["cose"] = false
+	if !Features()["cose"] {
+		t.Error("Modifying the result of Features changed the compiled features")
+	}
+}
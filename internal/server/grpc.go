@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"pinning-server/internal/logger"
+	"pinning-server/internal/pinspb"
+)
+
+// grpcService implements pinspb.PinServiceServer on top of the HTTP server internals
+type grpcService struct {
+	pinspb.UnimplementedPinServiceServer
+	server *Server
+}
+
+// NewGRPCServer creates a gRPC server exposing the PinService
+// It shares validation, retrieval and signing with the HTTP handlers
+func (s *Server) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	gs := grpc.NewServer(opts...)
+	pinspb.RegisterPinServiceServer(gs, &grpcService{server: s})
+	return gs
+}
+
+// GetPins implements pinspb.PinServiceServer
+func (g *grpcService) GetPins(ctx context.Context, req *pinspb.GetPinsRequest) (*pinspb.GetPinsResponse, error) {
+	start := time.Now()
+
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+
+	// Shed load once saturated, sharing the HTTP limit
+	release, ok := g.server.acquireInflight()
+	if !ok {
+		logger.Warn("gRPC request rejected",
+			"method", pinspb.PinService_GetPins_FullMethodName,
+			"status", http.StatusServiceUnavailable,
+			"error", "too_many_inflight_requests")
+		return nil, status.Error(codes.Unavailable, "Server is busy, retry later")
+	}
+	defer release()
+
+	g.server.requestsInFlight.Add(1)
+	defer g.server.requestsInFlight.Add(-1)
+
+	logger.Info("Processing gRPC pins request", "domain", req.Domain, "remote_addr", remoteAddr)
+
+	// Do not let one client scan arbitrary numbers of domains
+	if ok, retryAfter := g.server.distinct.allow(clientKey(remoteAddr), req.Domain); !ok {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))))
+		logger.Info("gRPC request completed",
+			"method", pinspb.PinService_GetPins_FullMethodName,
+			"domain", req.Domain,
+			"status", http.StatusTooManyRequests,
+			"error", "too_many_distinct_domains",
+			"remote_addr", remoteAddr,
+			"duration_ms", time.Since(start).Milliseconds())
+		return nil, status.Error(codes.ResourceExhausted, "Too many distinct domains queried, retry later")
+	}
+
+	includeBackup := g.server.config.DefaultIncludeBackup
+	if req.IncludeBackup != nil {
+		includeBackup = *req.IncludeBackup
+	}
+
+	result, pinErr := g.server.issuePins(pinRequest{
+		domain:        req.Domain,
+		includeBackup: includeBackup,
+	})
+	if pinErr != nil {
+		logger.Info("gRPC request completed",
+			"method", pinspb.PinService_GetPins_FullMethodName,
+			"domain", req.Domain,
+			"status", pinErr.status,
+			"error", pinErr.reason,
+			"duration_ms", time.Since(start).Milliseconds())
+		return nil, status.Error(grpcCode(pinErr.status), pinErr.message)
+	}
+
+	logger.Info("gRPC request completed",
+		"method", pinspb.PinService_GetPins_FullMethodName,
+		"domain", req.Domain,
+		"status", http.StatusOK,
+		"pin_count", len(result.pins),
+		"include_backup", includeBackup,
+		"leaf_sha256_fingerprint", result.leafFingerprint,
+		"duration_ms", time.Since(start).Milliseconds(),
+		connectionInfoAttr(result.connInfo))
+
+	return &pinspb.GetPinsResponse{Jws: result.jws}, nil
+}
+
+// grpcCode maps the HTTP status of a pin error to a gRPC status code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusInternalServerError:
+		return codes.Internal
+	default:
+		return codes.Unknown
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"pinning-server/internal/cert"
+	"pinning-server/internal/pinspb"
+)
+
+// newTestGRPCClient starts the server's gRPC service on an in-memory listener
+func newTestGRPCClient(t *testing.T, server *Server) pinspb.PinServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	gs := server.NewGRPCServer()
+	go func() {
+		_ = gs.Serve(listener)
+	}()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create gRPC client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pinspb.NewPinServiceClient(conn)
+}
+
+func TestGRPCGetPins_Success(t *testing.T) {
+	server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	retriever.SetCertificates("example.com", chain)
+
+	client := newTestGRPCClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	includeBackup := true
+	resp, err := client.GetPins(ctx, &pinspb.GetPinsRequest{Domain: "example.com", IncludeBackup: &includeBackup})
+	if err != nil {
+		t.Fatalf("GetPins failed: %v", err)
+	}
+
+	parts := strings.Split(resp.Jws, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected 3 parts in JWS token, got %d", len(parts))
+	}
+
+	payload := decodeJWSPayload(t, resp.Jws)
+	if payload["domain"] != "example.com" {
+		t.Errorf("Expected domain 'example.com', got '%v'", payload["domain"])
+	}
+	if pins, ok := payload["pins"].([]interface{}); !ok || len(pins) != 2 {
+		t.Errorf("Expected 2 pins with include_backup, got %v", payload["pins"])
+	}
+}
+
+func TestGRPCGetPins_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		domain        string
+		retrieverErr  bool
+		failureStatus int
+		expectedCode  codes.Code
+	}{
+		{
+			name:         "not_whitelisted",
+			domain:       "notallowed.com",
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "empty_domain",
+			domain:       "",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "retrieval_failed",
+			domain:       "example.com",
+			retrieverErr: true,
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name:          "retrieval_failed_bad_gateway",
+			domain:        "example.com",
+			retrieverErr:  true,
+			failureStatus: http.StatusBadGateway,
+			expectedCode:  codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+			server.config.CertFailureStatus = tt.failureStatus
+			if !tt.retrieverErr {
+				leaf, err := cert.GenerateTestCertificate("example.com")
+				if err != nil {
+					t.Fatalf("Failed to generate test certificate: %v", err)
+				}
+				retriever.SetCertificates("example.com", []*x509.Certificate{leaf})
+			}
+
+			client := newTestGRPCClient(t, server)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err := client.GetPins(ctx, &pinspb.GetPinsRequest{Domain: tt.domain})
+			if status.Code(err) != tt.expectedCode {
+				t.Errorf("Expected code %v, got %v (%v)", tt.expectedCode, status.Code(err), err)
+			}
+		})
+	}
+}
+
+func TestGRPCGetPins_MaxDistinctDomainsPerClient(t *testing.T) {
+	domains := []string{"a.example.com", "b.example.com"}
+	base, retriever := createTestServerWithFakeRetriever(t, domains)
+	cfg := *base.config
+	cfg.MaxDistinctDomainsPerClient = 1
+	cfg.DistinctDomainsWindow = time.Hour
+	server := NewWithRetriever(&cfg, retriever)
+
+	for _, domain := range domains {
+		leaf, err := cert.GenerateTestCertificate(domain)
+		if err != nil {
+			t.Fatalf("Failed to generate test certificate: %v", err)
+		}
+		retriever.SetCertificates(domain, []*x509.Certificate{leaf})
+	}
+
+	client := newTestGRPCClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.GetPins(ctx, &pinspb.GetPinsRequest{Domain: domains[0]}); err != nil {
+		t.Fatalf("Expected the first domain to be allowed, got %v", err)
+	}
+	var trailer metadata.MD
+	_, err := client.GetPins(ctx, &pinspb.GetPinsRequest{Domain: domains[1]}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected code %v above the limit, got %v (%v)", codes.ResourceExhausted, status.Code(err), err)
+	}
+	if len(trailer.Get("retry-after")) != 1 {
+		t.Errorf("Expected a retry-after trailer, got %v", trailer)
+	}
+
+	// Domains already queried stay available
+	if _, err := client.GetPins(ctx, &pinspb.GetPinsRequest{Domain: domains[0]}); err != nil {
+		t.Errorf("Expected a repeat query to succeed, got %v", err)
+	}
+}
+
+func TestGRPCGetPins_DefaultIncludeBackup(t *testing.T) {
+	base, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+	cfg := *base.config
+	cfg.DefaultIncludeBackup = true
+	server := NewWithRetriever(&cfg, retriever)
+
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	retriever.SetCertificates("example.com", chain)
+
+	client := newTestGRPCClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// An unset include_backup follows DEFAULT_INCLUDE_BACKUP, an explicit false overrides it
+	excludeBackup := false
+	for _, tt := range []struct {
+		name          string
+		includeBackup *bool
+		expectedPins  int
+	}{
+		{"unset", nil, 2},
+		{"explicit_false", &excludeBackup, 1},
+	} {
+		resp, err := client.GetPins(ctx, &pinspb.GetPinsRequest{Domain: "example.com", IncludeBackup: tt.includeBackup})
+		if err != nil {
+			t.Fatalf("%s: GetPins failed: %v", tt.name, err)
+		}
+		if pins, ok := decodeJWSPayload(t, resp.Jws)["pins"].([]interface{}); !ok || len(pins) != tt.expectedPins {
+			t.Errorf("%s: expected %d pins, got %v", tt.name, tt.expectedPins, decodeJWSPayload(t, resp.Jws)["pins"])
+		}
+	}
+}
+
+func TestGRPCGetPins_MaxInflightRequests(t *testing.T) {
+	base, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+	cfg := *base.config
+	cfg.MaxInflightRequests = 1
+	server := NewWithRetriever(&cfg, retriever)
+
+	leaf, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{leaf})
+
+	client := newTestGRPCClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Occupy the only slot as an HTTP request in progress would
+	release, ok := server.acquireInflight()
+	if !ok {
+		t.Fatal("Expected a free inflight slot")
+	}
+	_, err = client.GetPins(ctx, &pinspb.GetPinsRequest{Domain: "example.com"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected code %v while saturated, got %v (%v)", codes.Unavailable, status.Code(err), err)
+	}
+
+	release()
+	if _, err := client.GetPins(ctx, &pinspb.GetPinsRequest{Domain: "example.com"}); err != nil {
+		t.Errorf("Expected GetPins to succeed once the slot is free, got %v", err)
+	}
+}
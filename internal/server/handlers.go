@@ -1,126 +1,348 @@
 package server
 
 import (
-	"crypto/x509"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"pinning-server/internal/crypto"
+	"pinning-server/api"
+	"pinning-server/internal/cert"
+	"pinning-server/internal/config"
 	"pinning-server/internal/logger"
 	"pinning-server/internal/models"
 )
 
-// handleGetPins handles GET /v1/pins?domain=example.com
+// defaultJWSResponseKey is the JSON key holding the compact JWS in pin responses
+const defaultJWSResponseKey = "jws"
+
+// Supported values of the serialization parameter
+const (
+	serializationCompact  = "compact"
+	serializationJSON     = "json"
+	serializationDetached = "detached"
+)
+
+// handleGetPins handles GET /v1/pins?domain=example.com[&port=587&starttls=smtp]
 func (s *Server) handleGetPins(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	headers := s.requestHeaderAttr(r)
+
+	// Let clients compare their clock with ours when tokens look expired
+	w.Header().Set("X-Server-Time", strconv.FormatInt(start.Unix(), 10))
 
-	// Only allow GET requests
-	if r.Method != http.MethodGet {
-		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Select the response schema from the Accept header
+	w.Header().Add("Vary", "Accept")
+	version, ok := negotiateVersion(r)
+	if !ok {
+		s.writeError(w, r, fmt.Sprintf("Unsupported response version (supported: application/json, %s to %s)",
+			vendorMediaType(responseVersion1), vendorMediaType(latestResponseVersion)), http.StatusNotAcceptable)
 		logger.Info("Request completed",
 			"method", r.Method,
 			"path", r.URL.Path,
-			"status", http.StatusMethodNotAllowed,
-			"duration_ms", time.Since(start).Milliseconds())
+			"status", http.StatusNotAcceptable,
+			"error", "unsupported_version",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
 		return
 	}
 
 	// Get domain from query parameter
 	domain := r.URL.Query().Get("domain")
 	if domain == "" {
-		writeError(w, "Missing required query parameter: domain", http.StatusBadRequest)
+		s.writeError(w, r, "Missing required query parameter: domain", http.StatusBadRequest)
 		logger.Info("Request completed",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"status", http.StatusBadRequest,
 			"error", "missing_domain",
-			"duration_ms", time.Since(start).Milliseconds())
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
 		return
 	}
 
-	// Validate domain format (basic validation for malformed domains)
-	if len(domain) == 0 || len(domain) > 253 {
-		writeError(w, "Invalid domain parameter", http.StatusBadRequest)
+	// Check if backup pins should be included (DEFAULT_INCLUDE_BACKUP applies when absent)
+	includeBackup := s.config.DefaultIncludeBackup
+	if includeBackupStr := r.URL.Query().Get("include-backup-pins"); includeBackupStr != "" {
+		includeBackup = includeBackupStr == "true"
+	}
+
+	// Parse optional STARTTLS protocol and upstream port
+	fetchOpts, errMsg := s.parseFetchOptions(r)
+	if errMsg != "" {
+		s.writeError(w, r, errMsg, http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_fetch_options",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
 		return
 	}
 
-	// Check if backup pins should be included
-	includeBackupStr := r.URL.Query().Get("include-backup-pins")
-	includeBackup := includeBackupStr == "true"
+	// Check which kind of pin should be generated
+	pinType := r.URL.Query().Get("pin-type")
+	if pinType == "" {
+		pinType = pinTypeSPKI
+	}
+	if pinType != pinTypeSPKI && pinType != pinTypeAKI {
+		s.writeError(w, r, "Invalid pin-type parameter (supported: spki, aki)", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_pin_type",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
 
-	logger.Info("Processing pins request", "domain", domain, "remote_addr", r.RemoteAddr)
+	// Optional nonce echoed in the token for challenge-response flows
+	nonce := r.URL.Query().Get("nonce")
+	if !isValidNonce(nonce) {
+		s.writeError(w, r, fmt.Sprintf("Invalid nonce parameter (up to %d printable ASCII characters)", maxNonceLength), http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_nonce",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
 
-	// Validate domain is in whitelist
-	if !s.validator.IsAllowed(domain) {
-		logger.Warn("Domain not in whitelist", "domain", domain)
-		writeError(w, "Domain not found in whitelist", http.StatusForbidden)
+	// Compact serialization is the default, flattened JSON on request
+	serialization := r.URL.Query().Get("serialization")
+	if serialization != "" && serialization != serializationCompact && serialization != serializationJSON {
+		s.writeError(w, r, "Invalid serialization parameter (supported: compact, json)", http.StatusBadRequest)
 		logger.Info("Request completed",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"domain", domain,
-			"status", http.StatusForbidden,
-			"error", "domain_not_allowed",
-			"duration_ms", time.Since(start).Milliseconds())
+			"status", http.StatusBadRequest,
+			"error", "invalid_serialization",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
 		return
 	}
 
-	// Retrieve certificates for the domain
-	certs, err := s.retriever.GetCertificates(domain)
-	if err != nil {
-		logger.Error("Failed to retrieve certificates", "domain", domain, "error", err)
-		writeError(w, "Failed to retrieve certificate for domain", http.StatusUnprocessableEntity)
+	// Optionally return the pins as a mobile platform's pinning config or a COSE token instead of a JWS
+	format := r.URL.Query().Get("format")
+	if format != "" && format != formatATSPlist && format != formatAndroidNSC && format != formatCOSE {
+		s.writeError(w, r, "Invalid format parameter (supported: ats-plist, android-nsc, cose)", http.StatusBadRequest)
 		logger.Info("Request completed",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"domain", domain,
-			"status", http.StatusUnprocessableEntity,
-			"error", "cert_retrieval_failed",
-			"duration_ms", time.Since(start).Milliseconds())
+			"status", http.StatusBadRequest,
+			"error", "invalid_format",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+	// A COSE token replaces the JWS, so it has no JWS serialization
+	if format == formatCOSE && serialization == serializationJSON {
+		s.writeError(w, r, "format=cose cannot be combined with serialization=json", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_format",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+	// Platform configs only take SPKI hashes
+	if format != "" && format != formatCOSE && pinType != pinTypeSPKI {
+		s.writeError(w, r, "format requires pin-type=spki", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_format",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+	// ATS pins a single domain's leaf, so it cannot take a www. variant's pins
+	if format == formatATSPlist && r.URL.Query().Get("include-www") == "true" {
+		s.writeError(w, r, "format=ats-plist cannot be combined with include-www", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_format",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
 		return
 	}
 
-	// Determine which certificates to use for pin generation
-	var certsForPinning []*x509.Certificate
-	if includeBackup && len(certs) > 1 {
-		// Use leaf and intermediate certificate
-		certsForPinning = certs[:2]
-	} else if len(certs) > 0 {
-		// Use only leaf certificate
-		certsForPinning = certs[:1]
+	// A detached payload only applies to the compact JWS
+	detached := r.URL.Query().Get("detached") == "true"
+	if detached && (format != "" || serialization == serializationJSON) {
+		s.writeError(w, r, "detached=true cannot be combined with format or serialization=json", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_detached",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
 	}
 
-	// Generate SPKI hashes in TrustKit format: base64(SHA256(SPKI))
-	pins := crypto.GenerateSPKIHashes(certsForPinning)
+	// Optional token lifetime override, bounded by SIGNATURE_LIFETIME_MIN/MAX
+	lifetime, errMsg := s.parseTTL(r.URL.Query().Get("ttl"))
+	if errMsg != "" {
+		s.writeError(w, r, errMsg, http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_ttl",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
 
-	// Create JWS token
-	jwsToken, err := crypto.CreateJWS(
-		s.config.PrivateKey,
-		s.keyID,
-		domain,
-		pins,
-		s.config.SignatureLifetime,
-	)
-	if err != nil {
-		logger.Error("Failed to create JWS token", "domain", domain, "error", err)
-		writeError(w, "Failed to generate signed token", http.StatusInternalServerError)
+	// Do not let one client scan arbitrary numbers of domains
+	if ok, retryAfter := s.distinct.allow(clientKey(r.RemoteAddr), domain); !ok {
+		s.writeTooManyDomains(w, r, retryAfter)
 		logger.Info("Request completed",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"domain", domain,
-			"status", http.StatusInternalServerError,
-			"error", "jws_creation_failed",
-			"duration_ms", time.Since(start).Milliseconds())
+			"status", http.StatusTooManyRequests,
+			"error", "too_many_distinct_domains",
+			"remote_addr", r.RemoteAddr,
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
 		return
 	}
 
-	// Create JWS response
-	response := map[string]string{
-		"jws": jwsToken,
+	logger.Info("Processing pins request", "domain", domain, "remote_addr", r.RemoteAddr, headers)
+
+	result, pinErr := s.issuePins(pinRequest{
+		domain:        domain,
+		includeBackup: includeBackup,
+		fetchOpts:     fetchOpts,
+		pinType:       pinType,
+		nonce:         nonce,
+		jsonJWS:       serialization == serializationJSON,
+		detached:      detached,
+		cose:          format == formatCOSE,
+		includeWWW:    r.URL.Query().Get("include-www") == "true",
+		lifetime:      lifetime,
+		includeOCSP:   r.URL.Query().Get("include-ocsp") == "true",
+		allRoots:      r.URL.Query().Get("include-all-roots") == "true",
+		certValidity:  r.URL.Query().Get("include-cert-validity") == "true",
+	})
+	if pinErr != nil {
+		s.writePinError(w, r, pinErr)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", pinErr.status,
+			"error", pinErr.reason,
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	// COSE tokens are returned as raw CBOR for constrained clients
+	if format == formatCOSE {
+		w.Header().Set("Content-Type", coseMediaType)
+		s.setPinCacheHeaders(w, lifetime, nonce)
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(result.cose); err != nil {
+			logger.Error("Failed to write response", "error", err)
+		}
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusOK,
+			"pin_count", len(result.pins),
+			"include_backup", includeBackup,
+			"pin_type", pinType,
+			"format", format,
+			"leaf_sha256_fingerprint", result.leafFingerprint,
+			"duration_ms", time.Since(start).Milliseconds(),
+			connectionInfoAttr(result.connInfo),
+			headers)
+		return
+	}
+
+	// Platform configs carry the pins unsigned, for pasting into an app's resources
+	if format != "" {
+		body := atsPlist(domain, result.pins)
+		if format == formatAndroidNSC {
+			body = androidNSC(domain, result.pins)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		s.setPinCacheHeaders(w, lifetime, nonce)
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(body); err != nil {
+			logger.Error("Failed to write response", "error", err)
+		}
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusOK,
+			"pin_count", len(result.pins),
+			"include_backup", includeBackup,
+			"format", format,
+			"leaf_sha256_fingerprint", result.leafFingerprint,
+			"duration_ms", time.Since(start).Milliseconds(),
+			connectionInfoAttr(result.connInfo),
+			headers)
+		return
+	}
+
+	// Create JWS response in the negotiated schema
+	var response interface{}
+	contentType := "application/json"
+	switch {
+	case version == responseVersion2:
+		ttl, _ := s.tokenLifetime(lifetime)
+		token := models.PinToken{Serialization: serializationCompact, JWS: result.jws, JWSJSON: result.jwsJSON, ExpiresIn: int64(ttl.Seconds())}
+		if result.jwsJSON != nil {
+			token.Serialization = serializationJSON
+		}
+		if result.payload != nil {
+			token.Serialization = serializationDetached
+			token.Payload = string(result.payload)
+		}
+		response = models.PinResponseV2{Version: responseVersion2, Domain: domain, Token: token}
+		contentType = vendorMediaType(responseVersion2)
+	case result.jwsJSON != nil:
+		response = map[string]json.RawMessage{"jws_json": result.jwsJSON}
+	case result.payload != nil:
+		// The client needs the exact payload bytes to verify the signature
+		response = map[string]string{s.jwsResponseKey(): result.jws, "payload": string(result.payload)}
+	default:
+		response = map[string]string{s.jwsResponseKey(): result.jws}
 	}
 
 	// Write response
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
+	s.setPinCacheHeaders(w, lifetime, nonce)
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		logger.Error("Failed to encode response", "error", err)
@@ -131,34 +353,303 @@ func (s *Server) handleGetPins(w http.ResponseWriter, r *http.Request) {
 		"path", r.URL.Path,
 		"domain", domain,
 		"status", http.StatusOK,
-		"pin_count", len(pins),
+		"pin_count", len(result.pins),
 		"include_backup", includeBackup,
-		"duration_ms", time.Since(start).Milliseconds())
+		"pin_type", pinType,
+		"response_version", version,
+		"leaf_sha256_fingerprint", result.leafFingerprint,
+		"duration_ms", time.Since(start).Milliseconds(),
+		connectionInfoAttr(result.connInfo),
+		headers)
 }
 
-// handleHealth handles GET /health - basic liveness check
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// setPinCacheHeaders lets the requesting client keep a pin response for as long
+// as its token lives (PIN_CACHE_CONTROL), replacing the no-store security header.
+// Responses stay private so shared caches never serve one client's pins to
+// another. Nonce-bound tokens answer a single challenge and are never cached.
+func (s *Server) setPinCacheHeaders(w http.ResponseWriter, lifetime time.Duration, nonce string) {
+	if !s.config.PinCacheControl {
 		return
 	}
+	if nonce != "" {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	lifetime, _ = s.tokenLifetime(lifetime)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(lifetime.Seconds())))
+	w.Header().Set("Expires", time.Now().Add(lifetime).UTC().Format(http.TimeFormat))
+}
+
+// isValidNonce reports whether a nonce is within the length limit and consists
+// of printable ASCII characters only (an empty nonce is valid and means none)
+func isValidNonce(nonce string) bool {
+	if len(nonce) > maxNonceLength {
+		return false
+	}
+	for i := 0; i < len(nonce); i++ {
+		if nonce[i] < 0x21 || nonce[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// handlePinCheck handles GET /v1/pins/check?domain=example.com&pin=<base64>
+// It reports whether the supplied SPKI pin is among the domain's current pins
+func (s *Server) handlePinCheck(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	headers := s.requestHeaderAttr(r)
+
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		s.writeError(w, r, "Missing required query parameter: domain", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", http.StatusBadRequest,
+			"error", "missing_domain",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	// The pin must be base64(SHA256(SPKI)), as issued by /v1/pins
+	pin := r.URL.Query().Get("pin")
+	if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+		s.writeError(w, r, "Invalid pin parameter (expected base64 encoded SHA-256 hash)", http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_pin",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	fetchOpts, errMsg := s.parseFetchOptions(r)
+	if errMsg != "" {
+		s.writeError(w, r, errMsg, http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_fetch_options",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	// Checks fetch upstream like pin requests, so they count towards the same limit
+	if ok, retryAfter := s.distinct.allow(clientKey(r.RemoteAddr), domain); !ok {
+		s.writeTooManyDomains(w, r, retryAfter)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", http.StatusTooManyRequests,
+			"error", "too_many_distinct_domains",
+			"remote_addr", r.RemoteAddr,
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	// Compare against both the leaf and the backup pin
+	resolved, pinErr := s.resolvePins(pinRequest{
+		domain:        domain,
+		includeBackup: true,
+		fetchOpts:     fetchOpts,
+		pinType:       pinTypeSPKI,
+	})
+	if pinErr != nil {
+		s.writePinError(w, r, pinErr)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", domain,
+			"status", pinErr.status,
+			"error", pinErr.reason,
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	match := false
+	for _, current := range resolved.pins {
+		if current == pin {
+			match = true
+			break
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]string{
-		"status": "healthy",
+	if err := json.NewEncoder(w).Encode(map[string]bool{
+		"match": match,
 	}); err != nil {
+		logger.Error("Failed to encode pin check response", "error", err)
+	}
+
+	logger.Info("Request completed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"domain", domain,
+		"status", http.StatusOK,
+		"match", match,
+		"duration_ms", time.Since(start).Milliseconds(),
+		headers)
+}
+
+// jwsResponseKey returns the configured JSON key for the compact JWS in pin responses
+func (s *Server) jwsResponseKey() string {
+	if s.config.JWSResponseKey == "" {
+		return defaultJWSResponseKey
+	}
+	return s.config.JWSResponseKey
+}
+
+// parseTTL parses the optional ttl query parameter as a Go duration
+// Values outside [SignatureLifetimeMin, SignatureLifetimeMax] are clamped, or
+// rejected when TTL_STRICT is enabled. Returns 0 (use SIGNATURE_LIFETIME) when
+// the parameter is absent and a non-empty error message if it is invalid
+func (s *Server) parseTTL(raw string) (time.Duration, string) {
+	if raw == "" {
+		return 0, ""
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		return 0, "Invalid ttl parameter (expected a positive duration such as 5m)"
+	}
+
+	minTTL, maxTTL := s.config.SignatureLifetimeMin, s.config.SignatureLifetimeMax
+	if minTTL > 0 && ttl < minTTL {
+		if s.config.TTLStrict {
+			return 0, fmt.Sprintf("ttl parameter below minimum of %s", minTTL)
+		}
+		ttl = minTTL
+	}
+	if maxTTL > 0 && ttl > maxTTL {
+		if s.config.TTLStrict {
+			return 0, fmt.Sprintf("ttl parameter exceeds maximum of %s", maxTTL)
+		}
+		ttl = maxTTL
+	}
+
+	return ttl, ""
+}
+
+// parseFetchOptions parses the optional starttls and port query parameters
+// Returns a non-empty error message if a parameter is invalid or the port to
+// dial is not in ALLOWED_CERT_PORTS. Without a port, STARTTLS dials the
+// protocol's default port, which is checked the same way; plain TLS dials
+// CERT_FALLBACK_PORTS, which Config.Validate checks at startup.
+func (s *Server) parseFetchOptions(r *http.Request) (cert.FetchOptions, string) {
+	opts := cert.FetchOptions{}
+
+	if starttls := r.URL.Query().Get("starttls"); starttls != "" {
+		starttls = strings.ToLower(starttls)
+		if !cert.IsSupportedSTARTTLS(starttls) {
+			return opts, "Invalid starttls parameter (supported: smtp, imap)"
+		}
+		opts.STARTTLS = starttls
+	}
+
+	if portStr := r.URL.Query().Get("port"); portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return opts, "Invalid port parameter"
+		}
+		opts.Port = port
+	}
+
+	if opts.Port != 0 || opts.STARTTLS != "" {
+		port := opts.Port
+		if port == 0 {
+			port = cert.DefaultPort(opts.STARTTLS)
+		}
+		if !s.isAllowedCertPort(port) {
+			return opts, "Port not allowed"
+		}
+	}
+
+	return opts, ""
+}
+
+// isAllowedCertPort reports whether clients may request certificates from port
+func (s *Server) isAllowedCertPort(port int) bool {
+	if len(s.config.AllowedCertPorts) == 0 {
+		return true
+	}
+	for _, allowed := range s.config.AllowedCertPorts {
+		if port == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// handleHealth handles GET /health - basic liveness check
+// With deep=true it also fetches HEALTH_CANARY_DOMAIN, bounded by HEALTH_TIMEOUT
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	body := map[string]string{
+		"status": "healthy",
+	}
+	if r.URL.Query().Get("deep") == "true" && s.config.HealthCanaryDomain != "" {
+		body["canary"] = s.config.HealthCanaryDomain
+		if err := s.checkCanary(); err != nil {
+			// Still 200: an upstream problem is no reason to restart this instance
+			body["status"] = "degraded"
+			body["reason"] = err.Error()
+			logger.Warn("Deep health check degraded", "canary", s.config.HealthCanaryDomain, "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		logger.Error("Failed to encode health response", "error", err)
 	}
 }
 
-// handleReadiness handles GET /readiness - readiness check with crypto validation
-func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+// checkCanary fetches the certificates of HEALTH_CANARY_DOMAIN, giving up after
+// HEALTH_TIMEOUT so a hanging upstream cannot hang the probe. An abandoned
+// fetch finishes in the background within CERT_DIAL_TIMEOUT.
+func (s *Server) checkCanary() error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.retriever.GetCertificates(s.config.HealthCanaryDomain)
+		done <- err
+	}()
+
+	var timeout <-chan time.Time
+	if s.config.HealthTimeout > 0 {
+		timer := time.NewTimer(s.config.HealthTimeout)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("canary fetch failed: %w", err)
+		}
+		return nil
+	case <-timeout:
+		return fmt.Errorf("canary fetch timed out after %s", s.config.HealthTimeout)
+	}
+}
+
+// cacheSizer is implemented by retrievers that can report their cache size
+type cacheSizer interface {
+	CacheEntries() (int, bool)
+}
+
+// handleReadiness handles GET /readiness - readiness check with crypto validation
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
 	// Verify crypto components are initialized
 	if s.config.PrivateKey == nil || s.config.PublicKey == nil {
 		w.Header().Set("Content-Type", "application/json")
@@ -172,25 +663,81 @@ func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+	body := map[string]interface{}{
 		"status":          "ready",
 		"allowed_domains": len(s.config.AllowedDomains),
 		"key_id":          s.keyID,
-	}); err != nil {
+		"tokens_issued":   s.tokensIssued.Load(),
+	}
+
+	// Warn operators before the certificate cache grows large enough to matter
+	if sized, ok := s.retriever.(cacheSizer); ok {
+		if entries, known := sized.CacheEntries(); known {
+			body["cache_entries"] = entries
+			if mark := s.config.CacheHighWaterMark; mark > 0 && entries > mark {
+				body["status"] = "degraded"
+				body["reason"] = fmt.Sprintf("certificate cache holds %d entries, above the high-water mark of %d", entries, mark)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		logger.Error("Failed to encode readiness response", "error", err)
 	}
 }
 
-// writeError writes an error response
-func writeError(w http.ResponseWriter, message string, code int) {
+// handleOpenAPI handles GET /openapi.json - serves the embedded OpenAPI specification
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	if err := json.NewEncoder(w).Encode(models.Error{
-		Error: message,
-		Code:  code,
-	}); err != nil {
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(api.OpenAPISpec); err != nil {
+		logger.Error("Failed to write OpenAPI response", "error", err)
+	}
+}
+
+// writeError writes an error response in the configured ERROR_FORMAT
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	s.writeErrorBody(w, r, models.Error{Error: message, Code: code})
+}
+
+// writeTooManyDomains answers a client over MAX_DISTINCT_DOMAINS_PER_CLIENT
+func (s *Server) writeTooManyDomains(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	s.writeError(w, r, "Too many distinct domains queried, retry later", http.StatusTooManyRequests)
+}
+
+// writePinError writes a pin issuance failure, including the sanitized
+// upstream detail when certificate retrieval failed
+func (s *Server) writePinError(w http.ResponseWriter, r *http.Request, e *pinError) {
+	s.writeErrorBody(w, r, models.Error{
+		Error:          e.message,
+		Code:           e.status,
+		UpstreamDetail: e.detail,
+	})
+}
+
+// writeErrorBody writes body as {"error","code"} JSON, or as an RFC 7807
+// application/problem+json document when ERROR_FORMAT=problem-json
+func (s *Server) writeErrorBody(w http.ResponseWriter, r *http.Request, body models.Error) {
+	var payload interface{} = body
+	if s.config.ErrorFormat == config.ErrorFormatProblemJSON {
+		w.Header().Set("Content-Type", "application/problem+json")
+		payload = models.Problem{
+			Type:           "about:blank",
+			Title:          http.StatusText(body.Code),
+			Status:         body.Code,
+			Detail:         body.Error,
+			Instance:       r.URL.Path,
+			UpstreamDetail: body.UpstreamDetail,
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	w.WriteHeader(body.Code)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
 		logger.Error("Failed to encode error response", "error", err)
 	}
 }
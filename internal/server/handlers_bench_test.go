@@ -384,7 +384,7 @@ func BenchmarkErrorHandling(b *testing.B) {
 		b.Run(bm.name, func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
 				w := httptest.NewRecorder()
-				srv.handleGetPins(w, bm.request)
+				srv.ServeHTTP(w, bm.request)
 
 				if w.Code != bm.expected {
 					b.Fatalf("Expected status %d, got %d", bm.expected, w.Code)
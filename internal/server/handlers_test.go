@@ -1,21 +1,38 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	stdcrypto "crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"pinning-server/internal/cert"
 	"pinning-server/internal/config"
+	"pinning-server/internal/crypto"
+	"pinning-server/internal/domain"
+	"pinning-server/internal/logger"
 	"pinning-server/internal/models"
 )
 
@@ -76,6 +93,70 @@ func TestHandleGetPins_DomainNotInWhitelist(t *testing.T) {
 	}
 }
 
+// TestHandleGetPins_ErrorFormat tests both ERROR_FORMAT bodies for a 403 response
+func TestHandleGetPins_ErrorFormat(t *testing.T) {
+	tests := []struct {
+		name                string
+		format              string
+		expectedContentType string
+		expectedBody        map[string]interface{}
+	}{
+		{
+			name:                "json",
+			format:              config.ErrorFormatJSON,
+			expectedContentType: "application/json",
+			expectedBody: map[string]interface{}{
+				"error": "Domain not found in whitelist",
+				"code":  float64(http.StatusForbidden),
+			},
+		},
+		{
+			name:                "problem_json",
+			format:              config.ErrorFormatProblemJSON,
+			expectedContentType: "application/problem+json",
+			expectedBody: map[string]interface{}{
+				"type":     "about:blank",
+				"title":    "Forbidden",
+				"status":   float64(http.StatusForbidden),
+				"detail":   "Domain not found in whitelist",
+				"instance": "/v1/pins",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := createTestServer(t)
+			server.config.ErrorFormat = tt.format
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=notallowed.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.expectedContentType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.expectedContentType, ct)
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if len(body) != len(tt.expectedBody) {
+				t.Errorf("Expected body %v, got %v", tt.expectedBody, body)
+			}
+			for key, want := range tt.expectedBody {
+				if body[key] != want {
+					t.Errorf("Expected %s %v, got %v", key, want, body[key])
+				}
+			}
+		})
+	}
+}
+
 func TestHandleGetPins_Success(t *testing.T) {
 	server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
 
@@ -216,6 +297,28 @@ func TestHandleGetPins_WildcardDomain(t *testing.T) {
 	}
 }
 
+func TestHandleGetPins_WildcardInRequest(t *testing.T) {
+	server, _ := createTestServerWithFakeRetrieverAndDomains(t, []string{"*.example.com", "example.com"})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=*.example.com", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	var errorResp models.Error
+	if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+
+	if !strings.Contains(strings.ToLower(errorResp.Error), "wildcard not allowed") {
+		t.Errorf("Expected wildcard error message, got %q", errorResp.Error)
+	}
+}
+
 // Helper function to create a test server with fake retriever
 func createTestServer(t *testing.T) (*Server, *cert.FakeRetriever) {
 	return createTestServerWithFakeRetriever(t, []string{"example.com"})
@@ -246,6 +349,7 @@ func createTestServerWithFakeRetrieverAndDomains(t *testing.T, domains []string)
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       60 * time.Second,
 		ShutdownTimeout:   10 * time.Second,
+		AllowSelfSigned:   true,    // GenerateTestCertificate produces self-signed certs
 		LogLevel:          "error", // Reduce noise in tests
 	}
 
@@ -255,6 +359,28 @@ func createTestServerWithFakeRetrieverAndDomains(t *testing.T, domains []string)
 	return NewWithRetriever(cfg, fakeRetriever), fakeRetriever
 }
 
+// decodeJWSPayload decodes the payload of a compact JWS token without verifying it
+func decodeJWSPayload(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Invalid JWS format: expected 3 parts, got %d", len(parts))
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		t.Fatalf("Failed to parse payload: %v", err)
+	}
+
+	return payload
+}
+
 // TestHandleGetPins_BackupPins tests the include-backup-pins parameter
 func TestHandleGetPins_BackupPins(t *testing.T) {
 	server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
@@ -474,3 +600,3264 @@ func TestHandleGetPins_IPLiterals(t *testing.T) {
 		})
 	}
 }
+
+// TestHandleGetPins_FetchOptions tests the starttls and port query parameters
+func TestHandleGetPins_FetchOptions(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedOpts   cert.FetchOptions
+	}{
+		{
+			name:           "defaults",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedOpts:   cert.FetchOptions{},
+		},
+		{
+			name:           "smtp_with_port",
+			query:          "&starttls=smtp&port=587",
+			expectedStatus: http.StatusOK,
+			expectedOpts:   cert.FetchOptions{Port: 587, STARTTLS: "smtp"},
+		},
+		{
+			name:           "imap_uppercase",
+			query:          "&starttls=IMAP",
+			expectedStatus: http.StatusOK,
+			expectedOpts:   cert.FetchOptions{STARTTLS: "imap"},
+		},
+		{
+			name:           "unsupported_protocol",
+			query:          "&starttls=pop3",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid_port",
+			query:          "&port=abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "port_out_of_range",
+			query:          "&port=70000",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if tt.expectedStatus == http.StatusOK && retriever.LastOptions() != tt.expectedOpts {
+				t.Errorf("Expected fetch options %+v, got %+v", tt.expectedOpts, retriever.LastOptions())
+			}
+		})
+	}
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	base, retriever := createTestServer(t)
+
+	// Enable optional endpoints so that every documented path is registered
+	cfg := *base.config
+	cfg.EnableAdminConfig = true
+	cfg.AdminToken = "admin-secret"
+	server := NewWithRetriever(&cfg, retriever)
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", ct)
+	}
+
+	var spec struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&spec); err != nil {
+		t.Fatalf("Failed to decode OpenAPI document: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("Expected OpenAPI 3 document, got version %q", spec.OpenAPI)
+	}
+
+	// Every documented path must be routed by the mux
+	for path := range spec.Paths {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+
+		server.ServeHTTP(w, req)
+
+		if w.Code == http.StatusNotFound {
+			t.Errorf("Documented path %s is not routed (404)", path)
+		}
+	}
+}
+
+// TestHandleGetPins_RequireServerAuthEKU tests rejection of leaf certificates without serverAuth EKU
+func TestHandleGetPins_RequireServerAuthEKU(t *testing.T) {
+	tests := []struct {
+		name           string
+		extKeyUsage    []x509.ExtKeyUsage
+		requireEKU     bool
+		expectedStatus int
+	}{
+		{
+			name:           "server_auth_accepted",
+			extKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			requireEKU:     true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "client_auth_rejected",
+			extKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			requireEKU:     true,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "client_auth_allowed_when_disabled",
+			extKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			requireEKU:     false,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+			server.config.RequireServerAuthEKU = tt.requireEKU
+
+			leaf, err := cert.GenerateTestCertificateWithTemplate(&x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "example.com"},
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+				KeyUsage:     x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:  tt.extKeyUsage,
+				DNSNames:     []string{"example.com"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{leaf})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_PinTypeAKI tests the pin-type=aki mode
+func TestHandleGetPins_PinTypeAKI(t *testing.T) {
+	signedChain, err := cert.GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	selfSigned, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		pinType        string
+		chain          []*x509.Certificate
+		expectedStatus int
+		expectedPin    string
+	}{
+		{
+			name:           "aki_from_leaf",
+			pinType:        "aki",
+			chain:          signedChain,
+			expectedStatus: http.StatusOK,
+			expectedPin:    base64.StdEncoding.EncodeToString(signedChain[0].AuthorityKeyId),
+		},
+		{
+			name:           "aki_absent",
+			pinType:        "aki",
+			chain:          []*x509.Certificate{selfSigned},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "invalid_pin_type",
+			pinType:        "sha1",
+			chain:          signedChain,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+			retriever.SetCertificates("example.com", tt.chain)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&pin-type="+tt.pinType, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var jwsResp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			payload := decodeJWSPayload(t, jwsResp["jws"])
+			pins, ok := payload["pins"].([]interface{})
+			if !ok || len(pins) != 1 || pins[0] != tt.expectedPin {
+				t.Errorf("Expected pins [%s], got %v", tt.expectedPin, payload["pins"])
+			}
+			if payload["pin_type"] != "aki" {
+				t.Errorf("Expected pin_type 'aki', got '%v'", payload["pin_type"])
+			}
+		})
+	}
+}
+
+// TestHandlePinCheck tests membership checks of a precomputed pin
+func TestHandlePinCheck(t *testing.T) {
+	server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	retriever.SetCertificates("example.com", chain)
+
+	other, err := cert.GenerateTestCertificate("other.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		pin            string
+		expectedStatus int
+		expectedMatch  bool
+	}{
+		{"leaf_pin", crypto.GenerateSPKIHash(chain[0]), http.StatusOK, true},
+		{"backup_pin", crypto.GenerateSPKIHash(chain[1]), http.StatusOK, true},
+		{"unknown_pin", crypto.GenerateSPKIHash(other), http.StatusOK, false},
+		{"missing_pin", "", http.StatusBadRequest, false},
+		{"not_base64", "not-base64!", http.StatusBadRequest, false},
+		{"wrong_length", base64.StdEncoding.EncodeToString([]byte("short")), http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins/check?domain=example.com&pin="+url.QueryEscape(tt.pin), nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp map[string]bool
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp["match"] != tt.expectedMatch {
+				t.Errorf("Expected match %v, got %v", tt.expectedMatch, resp["match"])
+			}
+		})
+	}
+}
+
+// TestHandlePinCheck_DomainNotInWhitelist tests that pin checks honour the whitelist
+func TestHandlePinCheck_DomainNotInWhitelist(t *testing.T) {
+	server, _ := createTestServer(t)
+
+	pin := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins/check?domain=notallowed.com&pin="+url.QueryEscape(pin), nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+// TestHandleReadiness_TokensIssued tests that readiness reports the issued token count
+func TestHandleReadiness_TokensIssued(t *testing.T) {
+	server, retriever := createTestServer(t)
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	readTokensIssued := func() float64 {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/readiness", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode readiness response: %v", err)
+		}
+		count, ok := body["tokens_issued"].(float64)
+		if !ok {
+			t.Fatalf("Expected numeric tokens_issued, got %v", body["tokens_issued"])
+		}
+		return count
+	}
+
+	if count := readTokensIssued(); count != 0 {
+		t.Errorf("Expected 0 tokens issued, got %v", count)
+	}
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	}
+
+	// Failed requests are not counted
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=notallowed.com", nil)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	if count := readTokensIssued(); count != 3 {
+		t.Errorf("Expected 3 tokens issued, got %v", count)
+	}
+}
+
+// TestHandleGetPins_ServerTime tests the X-Server-Time header and server_time claim
+func TestHandleGetPins_ServerTime(t *testing.T) {
+	for _, claimEnabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("claim_%v", claimEnabled), func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.ServerTimeClaim = claimEnabled
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			serverTime, err := strconv.ParseInt(w.Header().Get("X-Server-Time"), 10, 64)
+			if err != nil {
+				t.Fatalf("Invalid X-Server-Time header %q: %v", w.Header().Get("X-Server-Time"), err)
+			}
+			if diff := time.Now().Unix() - serverTime; diff < 0 || diff > 1 {
+				t.Errorf("Expected X-Server-Time within a second of now, got %d (diff %ds)", serverTime, diff)
+			}
+
+			var jwsResp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, jwsResp["jws"])
+
+			_, hasClaim := payload["server_time"]
+			if hasClaim != claimEnabled {
+				t.Errorf("Expected server_time claim present=%v, got %v", claimEnabled, payload["server_time"])
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_HumanTimes tests that INCLUDE_HUMAN_TIMES adds RFC 3339
+// copies of iat and exp to issued tokens
+func TestHandleGetPins_HumanTimes(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled_%v", enabled), func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.IncludeHumanTimes = enabled
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var jwsResp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, jwsResp["jws"])
+
+			if !enabled {
+				if _, ok := payload["iat_str"]; ok {
+					t.Error("Expected no iat_str claim by default")
+				}
+				return
+			}
+			for _, claim := range []string{"iat", "exp"} {
+				str, ok := payload[claim+"_str"].(string)
+				if !ok {
+					t.Fatalf("Expected %s_str claim, got %v", claim, payload)
+				}
+				parsed, err := time.Parse(time.RFC3339, str)
+				if err != nil {
+					t.Fatalf("Failed to parse %s_str %q: %v", claim, str, err)
+				}
+				// Both are taken while signing, at most a second boundary apart
+				if diff := int64(payload[claim].(float64)) - parsed.Unix(); diff < 0 || diff > 1 {
+					t.Errorf("Expected %s_str %q to match %s %v", claim, str, claim, payload[claim])
+				}
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_TypHeader tests that JWS_TYP sets the typ header of issued tokens
+func TestHandleGetPins_TypHeader(t *testing.T) {
+	for _, typ := range []string{"", "pins+jwt"} {
+		t.Run(fmt.Sprintf("typ_%q", typ), func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.JWSType = typ
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var jwsResp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			headerJSON, err := base64.RawURLEncoding.DecodeString(strings.Split(jwsResp["jws"], ".")[0])
+			if err != nil {
+				t.Fatalf("Failed to decode JWS header: %v", err)
+			}
+			var header map[string]interface{}
+			if err := json.Unmarshal(headerJSON, &header); err != nil {
+				t.Fatalf("Failed to parse JWS header: %v", err)
+			}
+
+			expected := typ
+			if expected == "" {
+				expected = crypto.DefaultTokenType
+			}
+			if header["typ"] != expected {
+				t.Errorf("Expected typ %q, got %v", expected, header["typ"])
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_Nonce tests that the nonce parameter is echoed as a claim
+func TestHandleGetPins_Nonce(t *testing.T) {
+	tests := []struct {
+		name           string
+		nonce          string
+		expectedStatus int
+	}{
+		{"absent", "", http.StatusOK},
+		{"round_trip", "c2VjcmV0LW5vbmNl-123", http.StatusOK},
+		{"max_length", strings.Repeat("a", maxNonceLength), http.StatusOK},
+		{"too_long", strings.Repeat("a", maxNonceLength+1), http.StatusBadRequest},
+		{"non_ascii", "nonce-\u00e9", http.StatusBadRequest},
+		{"whitespace", "two words", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			target := "/v1/pins?domain=example.com"
+			if tt.nonce != "" {
+				target += "&nonce=" + url.QueryEscape(tt.nonce)
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var jwsResp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, jwsResp["jws"])
+
+			nonce, hasNonce := payload["nonce"]
+			if tt.nonce == "" {
+				if hasNonce {
+					t.Errorf("Expected no nonce claim, got %v", nonce)
+				}
+				return
+			}
+			if nonce != tt.nonce {
+				t.Errorf("Expected nonce %q, got %v", tt.nonce, nonce)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_SelfSigned tests rejection of lone self-signed leaf certificates
+func TestHandleGetPins_SelfSigned(t *testing.T) {
+	selfSigned, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	signedChain, err := cert.GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		allowSelfSigned bool
+		chain           []*x509.Certificate
+		expectedStatus  int
+	}{
+		{"self_signed_rejected", false, []*x509.Certificate{selfSigned}, http.StatusUnprocessableEntity},
+		{"self_signed_allowed", true, []*x509.Certificate{selfSigned}, http.StatusOK},
+		{"ca_issued_chain", false, signedChain, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.AllowSelfSigned = tt.allowSelfSigned
+			retriever.SetCertificates("example.com", tt.chain)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if tt.expectedStatus == http.StatusUnprocessableEntity {
+				var errorResp models.Error
+				if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+					t.Fatalf("Failed to decode error response: %v", err)
+				}
+				if !strings.Contains(errorResp.Error, "self-signed") {
+					t.Errorf("Expected self-signed error message, got %q", errorResp.Error)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_Serialization tests the compact and flattened JSON serializations
+func TestHandleGetPins_Serialization(t *testing.T) {
+	server, retriever := createTestServer(t)
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	request := func(serialization string) *httptest.ResponseRecorder {
+		target := "/v1/pins?domain=example.com"
+		if serialization != "" {
+			target += "&serialization=" + serialization
+		}
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, serialization := range []string{"", "compact"} {
+		w := request(serialization)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for serialization %q, got %d", http.StatusOK, serialization, w.Code)
+		}
+		var jwsResp map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if payload := decodeJWSPayload(t, jwsResp["jws"]); payload["domain"] != "example.com" {
+			t.Errorf("Expected domain 'example.com', got '%v'", payload["domain"])
+		}
+	}
+
+	w := request("json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var jsonResp map[string]map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&jsonResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	flattened, ok := jsonResp["jws_json"]
+	if !ok {
+		t.Fatal("Expected jws_json key in response")
+	}
+	// Reassembling the members must yield a valid compact token
+	compact := flattened["protected"] + "." + flattened["payload"] + "." + flattened["signature"]
+	if payload := decodeJWSPayload(t, compact); payload["domain"] != "example.com" {
+		t.Errorf("Expected domain 'example.com', got '%v'", payload["domain"])
+	}
+
+	if w := request("xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for unsupported serialization, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// TestHandleGetPins_Detached tests the detached payload JWS and its verification
+// against the payload reconstructed from the claims
+func TestHandleGetPins_Detached(t *testing.T) {
+	server, retriever := createTestServer(t)
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&detached=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if parts := strings.Split(resp["jws"], "."); len(parts) != 3 || parts[1] != "" {
+		t.Fatalf("Expected a JWS with an empty payload segment, got %q", resp["jws"])
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal([]byte(resp["payload"]), &claims); err != nil {
+		t.Fatalf("Failed to parse payload: %v", err)
+	}
+	if claims["domain"] != "example.com" {
+		t.Errorf("Expected domain 'example.com', got '%v'", claims["domain"])
+	}
+	reconstructed, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to reconstruct payload: %v", err)
+	}
+	if err := crypto.VerifyDetachedJWS(server.config.PublicKey, []byte(resp["jws"]), reconstructed); err != nil {
+		t.Errorf("Expected the reconstructed payload to verify, got %v", err)
+	}
+
+	for _, query := range []string{"&serialization=json", "&format=cose", "&format=ats-plist"} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&detached=true"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, w.Code)
+		}
+	}
+}
+
+// TestHandleGetPins_MinRemainingValidity tests rejection of leaves close to expiry
+func TestHandleGetPins_MinRemainingValidity(t *testing.T) {
+	tests := []struct {
+		name           string
+		minValidity    time.Duration
+		validFor       time.Duration
+		expectedStatus int
+	}{
+		{"disabled", 0, time.Hour, http.StatusOK},
+		{"near_expiry", 7 * 24 * time.Hour, 24 * time.Hour, http.StatusUnprocessableEntity},
+		{"far_from_expiry", 7 * 24 * time.Hour, 90 * 24 * time.Hour, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.CertMinRemainingValidity = tt.minValidity
+
+			testCert, err := cert.GenerateTestCertificateWithTemplate(&x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "example.com"},
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(tt.validFor),
+				DNSNames:     []string{"example.com"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if tt.expectedStatus == http.StatusUnprocessableEntity {
+				var errorResp models.Error
+				if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+					t.Fatalf("Failed to decode error response: %v", err)
+				}
+				if !strings.Contains(errorResp.Error, "rotate") {
+					t.Errorf("Expected error suggesting rotation, got %q", errorResp.Error)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_BlockedIssuers tests that chains from blocked CAs are refused
+func TestHandleGetPins_BlockedIssuers(t *testing.T) {
+	chain, err := cert.GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		blocked        []string
+		expectedStatus int
+	}{
+		{"none", nil, http.StatusOK},
+		{"other_ca", []string{"Other CA"}, http.StatusOK},
+		{"issuer_name", []string{"Intermediate CA"}, http.StatusUnprocessableEntity},
+		{"intermediate_spki", []string{crypto.GenerateSPKIHash(chain[1])}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, retriever := createTestServer(t)
+			cfg := *base.config
+			cfg.BlockedIssuers = tt.blocked
+			server := NewWithRetriever(&cfg, retriever)
+			retriever.SetCertificates("example.com", chain)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if tt.expectedStatus == http.StatusUnprocessableEntity {
+				var errorResp models.Error
+				if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+					t.Fatalf("Failed to decode error response: %v", err)
+				}
+				if !strings.Contains(errorResp.Error, "blocked issuer") {
+					t.Errorf("Expected blocked issuer error, got %q", errorResp.Error)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_PinDrift tests EXPECTED_PINS against matching and drifting leaves
+func TestHandleGetPins_PinDrift(t *testing.T) {
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	otherCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	livePin := crypto.GenerateSPKIHash(testCert)
+
+	tests := []struct {
+		name           string
+		expected       map[string][]string
+		failOnDrift    bool
+		expectedStatus int
+		expectWarning  bool
+	}{
+		{"not_configured", nil, true, http.StatusOK, false},
+		{"matching", map[string][]string{"example.com": {livePin}}, true, http.StatusOK, false},
+		{"matching_second_pin", map[string][]string{"example.com": {crypto.GenerateSPKIHash(otherCert), livePin}}, true, http.StatusOK, false},
+		{"drift_warns", map[string][]string{"example.com": {crypto.GenerateSPKIHash(otherCert)}}, false, http.StatusOK, true},
+		{"drift_fails", map[string][]string{"example.com": {crypto.GenerateSPKIHash(otherCert)}}, true, http.StatusUnprocessableEntity, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			previous := logger.Logger
+			logger.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+			defer func() { logger.Logger = previous }()
+
+			base, retriever := createTestServer(t)
+			cfg := *base.config
+			cfg.ExpectedPins = tt.expected
+			cfg.FailOnPinDrift = tt.failOnDrift
+			server := NewWithRetriever(&cfg, retriever)
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if warned := strings.Contains(logs.String(), "Pin drift detected"); warned != tt.expectWarning {
+				t.Errorf("Expected drift warning %v, got logs: %s", tt.expectWarning, logs.String())
+			}
+			if tt.expectedStatus == http.StatusUnprocessableEntity {
+				var errorResp models.Error
+				if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+					t.Fatalf("Failed to decode error response: %v", err)
+				}
+				if !strings.Contains(errorResp.Error, "expected pin") {
+					t.Errorf("Expected pin drift error, got %q", errorResp.Error)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_ConfiguredBackupPins tests that BACKUP_PINS are appended to the live
+// pins and listed in the backup_pins claim
+func TestHandleGetPins_ConfiguredBackupPins(t *testing.T) {
+	chain, err := cert.GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	livePins := crypto.GenerateSPKIHashes(chain)
+	futurePin := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	tests := []struct {
+		name          string
+		backup        map[string][]string
+		query         string
+		expectedPins  []string
+		expectedClaim []interface{}
+	}{
+		{"not_configured", nil, "", livePins[:1], nil},
+		{"leaf_only", map[string][]string{"example.com": {futurePin}}, "", []string{livePins[0], futurePin}, []interface{}{futurePin}},
+		{"with_backup", map[string][]string{"example.com": {futurePin}}, "&include-backup-pins=true", append(append([]string{}, livePins...), futurePin), []interface{}{futurePin}},
+		{"already_live", map[string][]string{"example.com": {livePins[0], futurePin}}, "", []string{livePins[0], futurePin}, []interface{}{futurePin}},
+		{"other_domain", map[string][]string{"other.com": {futurePin}}, "", livePins[:1], nil},
+		{"aki", map[string][]string{"example.com": {futurePin}}, "&pin-type=aki", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, retriever := createTestServer(t)
+			cfg := *base.config
+			cfg.BackupPins = tt.backup
+			server := NewWithRetriever(&cfg, retriever)
+			retriever.SetCertificates("example.com", chain)
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+
+			pins, _ := payload["pins"].([]interface{})
+			if tt.expectedPins != nil {
+				if len(pins) != len(tt.expectedPins) {
+					t.Fatalf("Expected pins %v, got %v", tt.expectedPins, pins)
+				}
+				for i, pin := range tt.expectedPins {
+					if pins[i] != pin {
+						t.Errorf("Expected pin %d to be %s, got %v", i, pin, pins[i])
+					}
+				}
+			} else if slices.Contains(pins, interface{}(futurePin)) {
+				t.Errorf("Expected no backup pin for this request, got %v", pins)
+			}
+
+			claim, _ := payload["backup_pins"].([]interface{})
+			if !slices.Equal(claim, tt.expectedClaim) {
+				t.Errorf("Expected backup_pins claim %v, got %v", tt.expectedClaim, payload["backup_pins"])
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_DefaultIncludeBackup tests that DEFAULT_INCLUDE_BACKUP flips the default
+func TestHandleGetPins_DefaultIncludeBackup(t *testing.T) {
+	server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com"})
+	server.config.DefaultIncludeBackup = true
+
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	retriever.SetCertificates("example.com", chain)
+
+	tests := []struct {
+		name             string
+		includeBackup    string
+		expectedPinCount int
+	}{
+		{"default_on", "", 2},
+		{"explicit_true", "true", 2},
+		{"explicit_false_overrides", "false", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/v1/pins?domain=example.com"
+			if tt.includeBackup != "" {
+				target += "&include-backup-pins=" + tt.includeBackup
+			}
+
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var jwsResp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			pins, _ := decodeJWSPayload(t, jwsResp["jws"])["pins"].([]interface{})
+			if len(pins) != tt.expectedPinCount {
+				t.Errorf("Expected %d pins, got %d", tt.expectedPinCount, len(pins))
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_IdentifyLeaf tests pinning of chains sent intermediate-first
+func TestHandleGetPins_IdentifyLeaf(t *testing.T) {
+	chain, err := cert.GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	reordered := []*x509.Certificate{chain[1], chain[0]}
+
+	tests := []struct {
+		name         string
+		identifyLeaf bool
+		expectedPin  string
+	}{
+		{"identify_leaf", true, crypto.GenerateSPKIHash(chain[0])},
+		{"assume_leaf_first", false, crypto.GenerateSPKIHash(chain[1])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.IdentifyLeaf = tt.identifyLeaf
+			retriever.SetCertificates("example.com", reordered)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var jwsResp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			pins, _ := decodeJWSPayload(t, jwsResp["jws"])["pins"].([]interface{})
+			if len(pins) != 1 || pins[0] != tt.expectedPin {
+				t.Errorf("Expected pins [%s], got %v", tt.expectedPin, pins)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_JWSResponseKey tests the configurable response wrapper key
+func TestHandleGetPins_JWSResponseKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		responseKey string
+		expectedKey string
+	}{
+		{"default", "", "jws"},
+		{"custom", "token", "token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.JWSResponseKey = tt.responseKey
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(resp) != 1 || resp[tt.expectedKey] == "" {
+				t.Errorf("Expected token under %q only, got %v", tt.expectedKey, resp)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_ResponseVersion tests response schema negotiation via the Accept header
+func TestHandleGetPins_ResponseVersion(t *testing.T) {
+	server, retriever := createTestServer(t)
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	get := func(query, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("v1_default", func(t *testing.T) {
+		for _, accept := range []string{"", "application/json", "*/*", "application/vnd.pinning.v1+json"} {
+			w := get("", accept)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Accept %q: expected status %d, got %d", accept, http.StatusOK, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Accept %q: expected Content-Type application/json, got %q", accept, ct)
+			}
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Accept %q: failed to decode response: %v", accept, err)
+			}
+			if len(resp) != 1 || resp["jws"] == "" {
+				t.Errorf("Accept %q: expected the flat v1 body, got %v", accept, resp)
+			}
+		}
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		w := get("", "application/vnd.pinning.v2+json")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/vnd.pinning.v2+json" {
+			t.Errorf("Expected the v2 vendor Content-Type, got %q", ct)
+		}
+		if vary := w.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("Expected Vary: Accept, got %q", vary)
+		}
+
+		var resp models.PinResponseV2
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Version != 2 || resp.Domain != "example.com" || resp.Token.Serialization != "compact" {
+			t.Errorf("Unexpected v2 response: %+v", resp)
+		}
+		if resp.Token.ExpiresIn != 3600 {
+			t.Errorf("Expected expires_in 3600, got %d", resp.Token.ExpiresIn)
+		}
+		if payload := decodeJWSPayload(t, resp.Token.JWS); payload["domain"] != "example.com" {
+			t.Errorf("Expected a token for example.com, got %v", payload)
+		}
+	})
+
+	t.Run("v2_json_serialization", func(t *testing.T) {
+		w := get("&serialization=json", "application/vnd.pinning.v3+json, application/vnd.pinning.v2+json")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp models.PinResponseV2
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Token.Serialization != "json" || resp.Token.JWS != "" || len(resp.Token.JWSJSON) == 0 {
+			t.Errorf("Expected the flattened JSON token only, got %+v", resp.Token)
+		}
+	})
+
+	t.Run("unsupported_version", func(t *testing.T) {
+		w := get("", "application/vnd.pinning.v9+json")
+		if w.Code != http.StatusNotAcceptable {
+			t.Fatalf("Expected status %d, got %d", http.StatusNotAcceptable, w.Code)
+		}
+		var errorResp models.Error
+		if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+			t.Fatalf("Failed to decode error response: %v", err)
+		}
+		if !strings.Contains(errorResp.Error, "application/vnd.pinning.v2+json") {
+			t.Errorf("Expected the supported versions in the error, got %q", errorResp.Error)
+		}
+	})
+}
+
+// TestNegotiateVersion tests Accept header parsing
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected int
+		ok       bool
+	}{
+		{"", 1, true},
+		{"application/json", 1, true},
+		{"text/html, */*;q=0.8", 1, true},
+		{"application/vnd.pinning.v1+json", 1, true},
+		{"application/vnd.pinning.v2+json; charset=utf-8", 2, true},
+		{"application/json, application/vnd.pinning.v2+json", 2, true},
+		{"application/vnd.pinning.v2+json;q=0, application/json", 1, true},
+		{"application/vnd.pinning.v3+json, application/json", 1, true},
+		{"application/vnd.pinning.v3+json", 0, false},
+		{"application/vnd.pinning.vx+json", 0, false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		version, ok := negotiateVersion(req)
+		if version != tt.expected || ok != tt.ok {
+			t.Errorf("negotiateVersion(%q) = %d, %v; expected %d, %v", tt.accept, version, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+// TestHandleGetPins_IncludeWWW tests merging the pins of the www. variant
+func TestHandleGetPins_IncludeWWW(t *testing.T) {
+	apexCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	wwwCert, err := cert.GenerateTestCertificate("www.example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		whitelist    []string
+		query        string
+		expectedPins []string
+	}{
+		{
+			name:         "www_whitelisted",
+			whitelist:    []string{"example.com", "www.example.com"},
+			query:        "domain=example.com&include-www=true",
+			expectedPins: []string{crypto.GenerateSPKIHash(apexCert), crypto.GenerateSPKIHash(wwwCert)},
+		},
+		{
+			name:         "www_not_whitelisted",
+			whitelist:    []string{"example.com"},
+			query:        "domain=example.com&include-www=true",
+			expectedPins: []string{crypto.GenerateSPKIHash(apexCert)},
+		},
+		{
+			name:         "option_absent",
+			whitelist:    []string{"example.com", "www.example.com"},
+			query:        "domain=example.com",
+			expectedPins: []string{crypto.GenerateSPKIHash(apexCert)},
+		},
+		{
+			name:         "www_request",
+			whitelist:    []string{"example.com", "www.example.com", "www.www.example.com"},
+			query:        "domain=www.example.com&include-www=true",
+			expectedPins: []string{crypto.GenerateSPKIHash(wwwCert)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServerWithFakeRetriever(t, tt.whitelist)
+			retriever.SetCertificates("example.com", []*x509.Certificate{apexCert})
+			retriever.SetCertificates("www.example.com", []*x509.Certificate{wwwCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var jwsResp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&jwsResp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			pins, _ := decodeJWSPayload(t, jwsResp["jws"])["pins"].([]interface{})
+			if len(pins) != len(tt.expectedPins) {
+				t.Fatalf("Expected pins %v, got %v", tt.expectedPins, pins)
+			}
+			for i, pin := range tt.expectedPins {
+				if pins[i] != pin {
+					t.Errorf("Expected pin %s at %d, got %v", pin, i, pins[i])
+				}
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_LogRequestHeaders tests that only allowlisted headers reach the logs
+func TestHandleGetPins_LogRequestHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	previous := logger.Logger
+	logger.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	defer func() { logger.Logger = previous }()
+
+	server, retriever := createTestServer(t)
+	server.config.LogRequestHeaders = []string{"User-Agent", "X-Request-Id", "Authorization", "X-Trace"}
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+	req.Header.Set("User-Agent", "pinning-client/1.0")
+	req.Header.Set("X-Request-ID", strings.Repeat("a", 300))
+	req.Header.Set("Authorization", "secret-credential")
+	req.Header.Set("X-Trace", "Bearer leaked-token")
+	req.Header.Set("X-Not-Logged", "hidden-value")
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var completed map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode log line %q: %v", line, err)
+		}
+		if entry["msg"] == "Request completed" {
+			completed = entry
+		}
+	}
+	if completed == nil {
+		t.Fatalf("Expected a Request completed log, got %s", buf.String())
+	}
+
+	headers, ok := completed["headers"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected headers group in log, got %v", completed)
+	}
+	if headers["User-Agent"] != "pinning-client/1.0" {
+		t.Errorf("Expected User-Agent to be logged, got %v", headers["User-Agent"])
+	}
+	if got, _ := headers["X-Request-Id"].(string); got != strings.Repeat("a", 256)+"..." {
+		t.Errorf("Expected X-Request-Id to be truncated, got %q", got)
+	}
+	if headers["Authorization"] != "[REDACTED]" {
+		t.Errorf("Expected Authorization to be redacted, got %v", headers["Authorization"])
+	}
+	if headers["X-Trace"] != "[REDACTED]" {
+		t.Errorf("Expected bearer value to be redacted, got %v", headers["X-Trace"])
+	}
+	if _, found := headers["X-Not-Logged"]; found || strings.Contains(buf.String(), "hidden-value") {
+		t.Errorf("Expected non-allowlisted header to be absent from logs, got %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "secret-credential") || strings.Contains(buf.String(), "leaked-token") {
+		t.Errorf("Expected credentials to be absent from logs, got %s", buf.String())
+	}
+}
+
+// TestNewWithRetriever_KeyMismatch tests that a public key not derived from the
+// private key is rejected at construction
+func TestNewWithRetriever_KeyMismatch(t *testing.T) {
+	server, _ := createTestServer(t)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := *server.config
+	cfg.PublicKey = &otherKey.PublicKey
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("Expected NewWithRetriever to panic on mismatched key pair")
+		} else if !strings.Contains(fmt.Sprint(r), "public key does not match private key") {
+			t.Errorf("Unexpected panic message: %v", r)
+		}
+	}()
+	NewWithRetriever(&cfg, cert.NewFakeRetriever())
+}
+
+// TestSecurityHeaders tests the security response headers and SECURITY_HEADERS=false
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		path          string
+		expectNoStore bool
+	}{
+		{"pins response", true, "/v1/pins?domain=example.com", true},
+		{"health response", true, "/health", false},
+		{"disabled", false, "/v1/pins?domain=example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.SecurityHeaders = tt.enabled
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			expectMinimal := map[string]string{
+				"X-Content-Type-Options": "nosniff",
+				"Referrer-Policy":        "no-referrer",
+			}
+			for header, value := range expectMinimal {
+				if got := w.Header().Get(header); tt.enabled && got != value {
+					t.Errorf("Expected %s %q, got %q", header, value, got)
+				} else if !tt.enabled && got != "" {
+					t.Errorf("Expected no %s header when disabled, got %q", header, got)
+				}
+			}
+
+			if got := w.Header().Get("Cache-Control"); tt.expectNoStore && got != "no-store" {
+				t.Errorf("Expected Cache-Control no-store, got %q", got)
+			} else if !tt.expectNoStore && got != "" {
+				t.Errorf("Expected no Cache-Control header, got %q", got)
+			}
+		})
+	}
+}
+
+func TestHandleGetPins_CacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          string // PIN_CACHE_CONTROL, empty for the default
+		query        string
+		expectMaxAge bool
+	}{
+		{"default", "", "", true},
+		{"enabled", "true", "", true},
+		{"nonce_bound", "true", "&nonce=abc", false},
+		{"disabled", "false", "", false},
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Take the setting as config.Load resolves it, so the default is covered
+			t.Setenv("ALLOWED_DOMAINS", "example.com")
+			t.Setenv("PRIVATE_KEY_PEM", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+			t.Setenv("PIN_CACHE_CONTROL", tt.env)
+			loaded, err := config.Load()
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+
+			server, retriever := createTestServer(t)
+			server.config.PinCacheControl = loaded.PinCacheControl
+			server.config.SecurityHeaders = loaded.SecurityHeaders
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			cacheControl := w.Header().Get("Cache-Control")
+			if !tt.expectMaxAge {
+				if cacheControl != "no-store" {
+					t.Errorf("Expected Cache-Control no-store, got %q", cacheControl)
+				}
+				return
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			ttlSeconds, _ := decodeJWSPayload(t, resp["jws"])["ttl_seconds"].(float64)
+
+			if want := fmt.Sprintf("private, max-age=%d", int(ttlSeconds)); cacheControl != want {
+				t.Errorf("Expected Cache-Control %q, got %q", want, cacheControl)
+			}
+			expires, err := http.ParseTime(w.Header().Get("Expires"))
+			if err != nil {
+				t.Fatalf("Failed to parse Expires header: %v", err)
+			}
+			if until := time.Until(expires); until > time.Duration(ttlSeconds)*time.Second || until < time.Duration(ttlSeconds)*time.Second-5*time.Second {
+				t.Errorf("Expected Expires about %vs from now, got %v", ttlSeconds, until)
+			}
+		})
+	}
+}
+
+// TestSecurityHeaders_PinCacheControl tests that PIN_CACHE_CONTROL only relaxes
+// no-store to a private cache and leaves the other security headers in place
+func TestSecurityHeaders_PinCacheControl(t *testing.T) {
+	server, retriever := createTestServer(t)
+	server.config.SecurityHeaders = true
+	server.config.PinCacheControl = true
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if got := w.Header().Values("Cache-Control"); len(got) != 1 || !strings.HasPrefix(got[0], "private, max-age=") {
+		t.Errorf("Expected a single private Cache-Control header, got %q", got)
+	}
+	for header, value := range map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "no-referrer",
+		"X-Frame-Options":        "DENY",
+	} {
+		if got := w.Header().Get(header); got != value {
+			t.Errorf("Expected %s %q, got %q", header, value, got)
+		}
+	}
+}
+
+// TestHandleGetPins_ExcludeRootFromBackup tests leaving a root CA out of the backup pins
+func TestHandleGetPins_ExcludeRootFromBackup(t *testing.T) {
+	// The issuer of this chain is a self-issued CA
+	rootChain, err := cert.GenerateSignedTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	intermediateChain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		chain            []*x509.Certificate
+		exclude          bool
+		expectedPinCount int
+	}{
+		{"root_included_by_default", rootChain, false, 2},
+		{"root_excluded", rootChain, true, 1},
+		{"non_ca_backup_kept", intermediateChain, true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.ExcludeRootFromBackup = tt.exclude
+			retriever.SetCertificates("example.com", tt.chain)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&include-backup-pins=true", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			pins, _ := decodeJWSPayload(t, resp["jws"])["pins"].([]interface{})
+			if len(pins) != tt.expectedPinCount {
+				t.Errorf("Expected %d pins, got %d", tt.expectedPinCount, len(pins))
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_MatchedRuleClaim tests the optional matched_rule claim
+func TestHandleGetPins_MatchedRuleClaim(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		domain        string
+		expectedClaim interface{}
+	}{
+		{"disabled", false, "api.example.com", nil},
+		{"exact", true, "example.com", "example.com"},
+		{"wildcard", true, "api.example.com", "*.example.com"},
+		{"exact_over_wildcard", true, "login.example.com", "login.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com", "*.example.com", "login.example.com"})
+			server.config.MatchedRuleClaim = tt.enabled
+
+			testCert, err := cert.GenerateTestCertificate(tt.domain)
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates(tt.domain, []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain="+tt.domain, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if got := decodeJWSPayload(t, resp["jws"])["matched_rule"]; got != tt.expectedClaim {
+				t.Errorf("Expected matched_rule %v, got %v", tt.expectedClaim, got)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_CanonicalDomainClaim tests that the domain claim is
+// lowercased and punycode-normalized unless CANONICAL_DOMAIN_CLAIM is off
+func TestHandleGetPins_CanonicalDomainClaim(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		domain        string
+		expectedClaim string
+	}{
+		{"mixed_case", true, "Example.COM", "example.com"},
+		{"unicode", true, "Bücher.example.com", "xn--bcher-kva.example.com"},
+		{"disabled", false, "Example.COM", "Example.COM"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com", "bücher.example.com"})
+			server.config.CanonicalDomainClaim = tt.enabled
+
+			testCert, err := cert.GenerateTestCertificate(domain.Normalize(tt.domain))
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates(tt.domain, []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain="+url.QueryEscape(tt.domain), nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if got := decodeJWSPayload(t, resp["jws"])["domain"]; got != tt.expectedClaim {
+				t.Errorf("Expected domain claim %q, got %v", tt.expectedClaim, got)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_WhitelistBySAN tests allowing domains by the SANs of their leaf
+func TestHandleGetPins_WhitelistBySAN(t *testing.T) {
+	tests := []struct {
+		name           string
+		enabled        bool
+		domain         string
+		sans           []string
+		expectedStatus int
+	}{
+		{"alias_sharing_cert", true, "alias.example.net", []string{"alias.example.net", "example.com"}, http.StatusOK},
+		{"wildcard_san", true, "alias.example.net", []string{"*.example.net", "example.com"}, http.StatusOK},
+		{"no_whitelisted_san", true, "other.example.net", []string{"other.example.net"}, http.StatusForbidden},
+		{"leaf_not_valid_for_domain", true, "alias.example.net", []string{"example.com"}, http.StatusForbidden},
+		{"disabled", false, "alias.example.net", []string{"alias.example.net", "example.com"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.WhitelistBySAN = tt.enabled
+			server.config.MatchedRuleClaim = true
+
+			testCert, err := cert.GenerateTestCertificateWithTemplate(&x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: tt.sans[0]},
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+				DNSNames:     tt.sans,
+			})
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates(tt.domain, []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain="+tt.domain, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+			if payload["domain"] != tt.domain || payload["matched_rule"] != "example.com" {
+				t.Errorf("Expected domain %s matched by example.com, got %v / %v", tt.domain, payload["domain"], payload["matched_rule"])
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_WhitelistBySANMalformed tests that malformed domains are
+// rejected without being fetched in WHITELIST_BY_SAN mode
+func TestHandleGetPins_WhitelistBySANMalformed(t *testing.T) {
+	server, retriever := createTestServer(t)
+	server.config.WhitelistBySAN = true
+
+	// A fetch would fail with 422, so 403 shows no fetch was attempted
+	retriever.SetError(errors.New("should not be dialed"))
+
+	for _, domain := range []string{"bad_host.example", "192.168.1.1", "exa%20mple.com", "-bad.example"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain="+domain, nil)
+		w := httptest.NewRecorder()
+
+		server.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Domain %q: expected status %d, got %d", domain, http.StatusForbidden, w.Code)
+		}
+	}
+}
+
+// TestHandleGetPins_LeafFingerprintLogged tests that the success log carries the
+// full-certificate fingerprint of the leaf
+func TestHandleGetPins_LeafFingerprintLogged(t *testing.T) {
+	var buf bytes.Buffer
+	previous := logger.Logger
+	logger.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	defer func() { logger.Logger = previous }()
+
+	server, retriever := createTestServer(t)
+
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate chain: %v", err)
+	}
+	retriever.SetCertificates("example.com", chain)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&include-backup-pins=true", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var fingerprint interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode log line %q: %v", line, err)
+		}
+		if entry["msg"] == "Request completed" {
+			fingerprint = entry["leaf_sha256_fingerprint"]
+		}
+	}
+
+	if expected := crypto.CertificateFingerprint(chain[0]); fingerprint != expected {
+		t.Errorf("Expected leaf fingerprint %q, got %v", expected, fingerprint)
+	}
+}
+
+// TestHandleGetPins_HideWhitelist tests answering 404 for unknown domains with HIDE_WHITELIST
+func TestHandleGetPins_HideWhitelist(t *testing.T) {
+	tests := []struct {
+		name           string
+		hideWhitelist  bool
+		expectedStatus int
+		expectedError  string
+	}{
+		{"default", false, http.StatusForbidden, "Domain not found in whitelist"},
+		{"hidden", true, http.StatusNotFound, "Not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := createTestServer(t)
+			server.config.HideWhitelist = tt.hideWhitelist
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=notallowed.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			var errorResp models.Error
+			if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if errorResp.Error != tt.expectedError || errorResp.Code != tt.expectedStatus {
+				t.Errorf("Expected error %q (%d), got %q (%d)", tt.expectedError, tt.expectedStatus, errorResp.Error, errorResp.Code)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_TTL tests overriding the token lifetime with the ttl parameter
+func TestHandleGetPins_TTL(t *testing.T) {
+	tests := []struct {
+		name           string
+		ttl            string
+		strict         bool
+		expectedStatus int
+		expectedTTL    float64
+	}{
+		{"default", "", false, http.StatusOK, 3600},
+		{"within_bounds", "5m", false, http.StatusOK, 300},
+		{"clamped_to_max", "2h", false, http.StatusOK, 3600},
+		{"clamped_to_min", "10s", false, http.StatusOK, 60},
+		{"strict_over_max", "2h", true, http.StatusBadRequest, 0},
+		{"strict_under_min", "10s", true, http.StatusBadRequest, 0},
+		{"invalid_duration", "soon", false, http.StatusBadRequest, 0},
+		{"negative_duration", "-5m", false, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.SignatureLifetimeMin = time.Minute
+			server.config.SignatureLifetimeMax = time.Hour
+			server.config.TTLStrict = tt.strict
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			query := url.Values{"domain": {"example.com"}}
+			if tt.ttl != "" {
+				query.Set("ttl", tt.ttl)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?"+query.Encode(), nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+			if payload["ttl_seconds"] != tt.expectedTTL {
+				t.Errorf("Expected ttl_seconds %v, got %v", tt.expectedTTL, payload["ttl_seconds"])
+			}
+			iat, _ := payload["iat"].(float64)
+			exp, _ := payload["exp"].(float64)
+			if exp-iat != tt.expectedTTL {
+				t.Errorf("Expected exp - iat = %v, got %v", tt.expectedTTL, exp-iat)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_MinTTL tests that every lifetime source is raised to MIN_TTL
+func TestHandleGetPins_MinTTL(t *testing.T) {
+	tests := []struct {
+		name              string
+		signatureLifetime time.Duration
+		ttl               string
+		expectedTTL       float64
+	}{
+		{"ttl_below_floor", time.Hour, "1ms", 60},
+		{"config_below_floor", time.Millisecond, "", 60},
+		{"above_floor", time.Hour, "5m", 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.SignatureLifetime = tt.signatureLifetime
+			server.config.SignatureLifetimeMax = time.Hour
+			server.config.MinTTL = time.Minute
+			server.config.PinCacheControl = true
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			query := url.Values{"domain": {"example.com"}}
+			if tt.ttl != "" {
+				query.Set("ttl", tt.ttl)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?"+query.Encode(), nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+			iat, _ := payload["iat"].(float64)
+			exp, _ := payload["exp"].(float64)
+			if exp-iat != tt.expectedTTL {
+				t.Errorf("Expected exp - iat = %v, got %v", tt.expectedTTL, exp-iat)
+			}
+			if exp-iat < server.config.MinTTL.Seconds() {
+				t.Errorf("Expected exp - iat >= MIN_TTL, got %v", exp-iat)
+			}
+			if got, want := w.Header().Get("Cache-Control"), fmt.Sprintf("private, max-age=%d", int(tt.expectedTTL)); got != want {
+				t.Errorf("Expected Cache-Control %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_EmptyChain tests the distinct error for connections presenting no certificates
+func TestHandleGetPins_EmptyChain(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*cert.FakeRetriever)
+	}{
+		{"empty_chain", func(r *cert.FakeRetriever) {
+			r.SetCertificates("example.com", []*x509.Certificate{})
+		}},
+		{"no_certificates_error", func(r *cert.FakeRetriever) {
+			r.SetError(fmt.Errorf("%w for domain: example.com", cert.ErrNoCertificates))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			tt.setup(retriever)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+			}
+
+			var errorResp models.Error
+			if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if errorResp.Error != "No certificate presented by domain" {
+				t.Errorf("Expected distinct empty-chain error, got %q", errorResp.Error)
+			}
+		})
+	}
+}
+
+// TestHandleReadiness_CacheHighWaterMark tests degraded readiness once the cache grows too large
+func TestHandleReadiness_CacheHighWaterMark(t *testing.T) {
+	base, _ := createTestServer(t)
+	cfg := *base.config
+	cfg.CacheHighWaterMark = 2
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	cache := cert.NewMemoryCache()
+	retriever := cert.NewRetriever(time.Second, time.Minute)
+	retriever.SetCache(cache)
+	server := NewWithRetriever(&cfg, retriever)
+
+	readReadiness := func() map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode readiness response: %v", err)
+		}
+		return body
+	}
+
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		cache.Set(domain, []*x509.Certificate{testCert}, time.Minute)
+	}
+	if body := readReadiness(); body["status"] != "ready" || body["cache_entries"] != float64(2) {
+		t.Errorf("Expected ready with 2 cache entries at the mark, got %v", body)
+	}
+
+	cache.Set("c.example.com", []*x509.Certificate{testCert}, time.Minute)
+	body := readReadiness()
+	if body["status"] != "degraded" || body["cache_entries"] != float64(3) {
+		t.Errorf("Expected degraded with 3 cache entries, got %v", body)
+	}
+	if reason, _ := body["reason"].(string); !strings.Contains(reason, "high-water mark of 2") {
+		t.Errorf("Expected reason naming the high-water mark, got %q", reason)
+	}
+}
+
+func TestHandleHealth_DeepCanary(t *testing.T) {
+	base, fakeRetriever := createTestServer(t)
+	cfg := *base.config
+	cfg.HealthCanaryDomain = "example.com"
+	cfg.HealthTimeout = 100 * time.Millisecond
+	server := NewWithRetriever(&cfg, fakeRetriever)
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	fakeRetriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	readHealth := func(path string) (map[string]string, time.Duration) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		start := time.Now()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		elapsed := time.Since(start)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for %s, got %d", http.StatusOK, path, w.Code)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode health response: %v", err)
+		}
+		return body, elapsed
+	}
+
+	if body, _ := readHealth("/health?deep=true"); body["status"] != "healthy" || body["canary"] != "example.com" {
+		t.Errorf("Expected healthy deep check, got %v", body)
+	}
+
+	fakeRetriever.SetDelay(2 * time.Second)
+
+	body, elapsed := readHealth("/health?deep=true")
+	if body["status"] != "degraded" || !strings.Contains(body["reason"], "timed out") {
+		t.Errorf("Expected degraded deep check on timeout, got %v", body)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected the deep check to give up after HEALTH_TIMEOUT, took %s", elapsed)
+	}
+
+	// The liveness check never touches the upstream
+	body, elapsed = readHealth("/health")
+	if body["status"] != "healthy" || body["canary"] != "" {
+		t.Errorf("Expected shallow healthy response, got %v", body)
+	}
+	if elapsed > 50*time.Millisecond {
+		t.Errorf("Expected the shallow check to be instant, took %s", elapsed)
+	}
+}
+
+func TestHandleHealth_DeepCanaryFailure(t *testing.T) {
+	base, fakeRetriever := createTestServer(t)
+	cfg := *base.config
+	cfg.HealthCanaryDomain = "example.com"
+	cfg.HealthTimeout = time.Second
+	server := NewWithRetriever(&cfg, fakeRetriever)
+	fakeRetriever.SetError(errors.New("connection refused"))
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health?deep=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode health response: %v", err)
+	}
+	if body["status"] != "degraded" || !strings.Contains(body["reason"], "connection refused") {
+		t.Errorf("Expected degraded deep check naming the failure, got %v", body)
+	}
+}
+
+// infoRetriever is a FakeRetriever that also reports a fixed TLS connection
+type infoRetriever struct {
+	*cert.FakeRetriever
+	info *cert.ConnectionInfo
+}
+
+func (r *infoRetriever) GetCertificatesWithInfo(domain string, opts cert.FetchOptions) ([]*x509.Certificate, *cert.ConnectionInfo, error) {
+	certs, err := r.GetCertificatesWithOptions(domain, opts)
+	return certs, r.info, err
+}
+
+// TestHandleGetPins_TLSInfoClaim tests the optional tls_version and cipher_suite claims
+func TestHandleGetPins_TLSInfoClaim(t *testing.T) {
+	base, fake := createTestServer(t)
+	cfg := *base.config
+	cfg.TLSInfoClaim = true
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	fake.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	server := NewWithRetriever(&cfg, &infoRetriever{
+		FakeRetriever: fake,
+		info:          &cert.ConnectionInfo{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	payload := decodeJWSPayload(t, resp["jws"])
+	if payload["tls_version"] != "TLS 1.3" || payload["cipher_suite"] != "TLS_AES_128_GCM_SHA256" {
+		t.Errorf("Expected TLS 1.3 / TLS_AES_128_GCM_SHA256 claims, got %v / %v", payload["tls_version"], payload["cipher_suite"])
+	}
+}
+
+// TestHandleGetPins_RejectMixedScript tests rejecting homograph domains with REJECT_MIXED_SCRIPT
+func TestHandleGetPins_RejectMixedScript(t *testing.T) {
+	homograph := "аpple.com"
+
+	tests := []struct {
+		name           string
+		reject         bool
+		domain         string
+		expectedStatus int
+	}{
+		{"ascii_allowed", true, "apple.com", http.StatusOK},
+		{"cyrillic_allowed", true, "пример.рф", http.StatusOK},
+		{"homograph_rejected", true, homograph, http.StatusBadRequest},
+		{"homograph_allowed_by_default", false, homograph, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServerWithFakeRetriever(t, []string{"apple.com", "пример.рф", homograph})
+			server.config.RejectMixedScript = tt.reject
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates(tt.domain, []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain="+url.QueryEscape(tt.domain), nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_UpstreamDetail tests that retrieval failures carry a sanitized upstream detail
+func TestHandleGetPins_UpstreamDetail(t *testing.T) {
+	server, retriever := createTestServer(t)
+	retriever.SetError(errors.New("failed to connect to 10.0.0.1:443: remote error: tls: handshake failure"))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	body := w.Body.String()
+	if strings.Contains(body, "10.0.0.1") {
+		t.Errorf("Error response leaks the upstream address: %s", body)
+	}
+
+	var errorResp models.Error
+	if err := json.Unmarshal([]byte(body), &errorResp); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	if errorResp.UpstreamDetail != "tls alert: handshake failure" {
+		t.Errorf("Expected upstream_detail 'tls alert: handshake failure', got %q", errorResp.UpstreamDetail)
+	}
+}
+
+// TestHandleGetPins_CertFailureStatus tests the configured and classified statuses of retrieval failures
+func TestHandleGetPins_CertFailureStatus(t *testing.T) {
+	timeoutErr := fmt.Errorf("failed to connect to example.com: %w", context.DeadlineExceeded)
+	refusedErr := errors.New("failed to connect to example.com: dial tcp 10.0.0.1:443: connect: connection refused")
+	untrustedErr := fmt.Errorf("TLS handshake with example.com failed: %w", x509.UnknownAuthorityError{})
+	unknownErr := errors.New("something unexpected")
+
+	tests := []struct {
+		name           string
+		failureStatus  int
+		classify       bool
+		err            error
+		expectedStatus int
+	}{
+		{"default", 0, false, timeoutErr, http.StatusUnprocessableEntity},
+		{"configured_502", http.StatusBadGateway, false, untrustedErr, http.StatusBadGateway},
+		{"configured_503", http.StatusServiceUnavailable, false, timeoutErr, http.StatusServiceUnavailable},
+		{"classified_timeout", http.StatusUnprocessableEntity, true, timeoutErr, http.StatusServiceUnavailable},
+		{"classified_refused", http.StatusUnprocessableEntity, true, refusedErr, http.StatusServiceUnavailable},
+		{"classified_untrusted", http.StatusBadGateway, true, untrustedErr, http.StatusUnprocessableEntity},
+		{"classified_unknown", http.StatusBadGateway, true, unknownErr, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.CertFailureStatus = tt.failureStatus
+			server.config.ClassifyCertErrors = tt.classify
+			retriever.SetError(tt.err)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_LogsNormalizedDomain tests that LOG_NORMALIZED_DOMAIN logs
+// the raw and punycode forms of an IDN request next to the matched rule
+func TestHandleGetPins_LogsNormalizedDomain(t *testing.T) {
+	var buf bytes.Buffer
+	previous := logger.Logger
+	logger.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	defer func() { logger.Logger = previous }()
+
+	const raw = "Bücher.example.com"
+	server, retriever := createTestServerWithFakeRetriever(t, []string{"*.example.com"})
+	server.config.LogNormalizedDomain = true
+
+	testCert, err := cert.GenerateTestCertificate("xn--bcher-kva.example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates(raw, []*x509.Certificate{testCert})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain="+url.QueryEscape(raw), nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var matched map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode log line %q: %v", line, err)
+		}
+		if entry["msg"] == "Domain matched whitelist rule" {
+			matched = entry
+		}
+	}
+	if matched == nil {
+		t.Fatal("Expected a log line for the matched whitelist rule")
+	}
+	if matched["domain"] != raw {
+		t.Errorf("Expected raw domain %q, got %v", raw, matched["domain"])
+	}
+	if matched["normalized_domain"] != "xn--bcher-kva.example.com" {
+		t.Errorf("Expected normalized domain xn--bcher-kva.example.com, got %v", matched["normalized_domain"])
+	}
+	if matched["rule"] != "*.example.com" {
+		t.Errorf("Expected rule *.example.com, got %v", matched["rule"])
+	}
+}
+
+// TestHandleGetPins_LogsLimitedSANs tests that certificates with many SANs are logged truncated
+func TestHandleGetPins_LogsLimitedSANs(t *testing.T) {
+	var buf bytes.Buffer
+	previous := logger.Logger
+	logger.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	defer func() { logger.Logger = previous }()
+
+	server, retriever := createTestServer(t)
+	server.config.MaxLoggedSANs = 10
+
+	sans := []string{"example.com"}
+	for i := 1; i < 250; i++ {
+		sans = append(sans, fmt.Sprintf("host%d.example.com", i))
+	}
+	testCert, err := cert.GenerateTestCertificateWithTemplate(&x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     sans,
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var logged []interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode log line %q: %v", line, err)
+		}
+		if entry["msg"] == "Retrieved certificate" {
+			logged, _ = entry["sans"].([]interface{})
+		}
+	}
+
+	if len(logged) != 11 {
+		t.Fatalf("Expected 10 SANs and a truncation marker, got %v", logged)
+	}
+	if logged[0] != "example.com" {
+		t.Errorf("Expected first SAN 'example.com', got %v", logged[0])
+	}
+	if logged[10] != "+240 more" {
+		t.Errorf("Expected '+240 more' marker, got %v", logged[10])
+	}
+}
+
+func TestLimitSANs(t *testing.T) {
+	sans := []string{"a.com", "b.com", "c.com"}
+
+	if got := limitSANs(sans, 0); len(got) != 3 {
+		t.Errorf("Expected all SANs with no cap, got %v", got)
+	}
+	if got := limitSANs(sans, 3); len(got) != 3 {
+		t.Errorf("Expected all SANs at the cap, got %v", got)
+	}
+	got := limitSANs(sans, 1)
+	if len(got) != 2 || got[0] != "a.com" || got[1] != "+2 more" {
+		t.Errorf("Expected [a.com +2 more], got %v", got)
+	}
+	if sans[1] != "b.com" {
+		t.Errorf("limitSANs modified its input: %v", sans)
+	}
+}
+
+// TestHandleGetPins_VerifyAfterSign tests that tokens failing re-verification are never returned
+func TestHandleGetPins_VerifyAfterSign(t *testing.T) {
+	server, retriever := createTestServer(t)
+	server.config.VerifyAfterSign = true
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d with a matching key, got %d", http.StatusOK, w.Code)
+	}
+
+	// Force a mismatch between the signing key and the verification key
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	server.config.PublicKey = &otherKey.PublicKey
+	issued := server.tokensIssued.Load()
+
+	for _, query := range []string{"domain=example.com", "domain=example.com&serialization=json"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins?"+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusInternalServerError, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "eyJ") {
+			t.Errorf("%s: unverified token leaked in response: %s", query, w.Body.String())
+		}
+	}
+
+	if got := server.tokensIssued.Load(); got != issued {
+		t.Errorf("Expected failed tokens not to be counted, issued went from %d to %d", issued, got)
+	}
+}
+
+// TestHandleGetPins_AllowedCertPorts tests that the port parameter is restricted to ALLOWED_CERT_PORTS
+func TestHandleGetPins_AllowedCertPorts(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedPort   int
+	}{
+		{"omitted", "", http.StatusOK, 0},
+		{"allowed", "&port=443", http.StatusOK, 443},
+		{"disallowed", "&port=22", http.StatusBadRequest, 0},
+		{"starttls_default_disallowed", "&starttls=smtp", http.StatusBadRequest, 0},
+		{"starttls_allowed", "&starttls=smtp&port=443", http.StatusOK, 443},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.AllowedCertPorts = []int{443}
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus == http.StatusOK && retriever.LastOptions().Port != tt.expectedPort {
+				t.Errorf("Expected port %d, got %d", tt.expectedPort, retriever.LastOptions().Port)
+			}
+			if tt.expectedStatus == http.StatusBadRequest {
+				var errorResp models.Error
+				if err := json.NewDecoder(w.Body).Decode(&errorResp); err != nil {
+					t.Fatalf("Failed to decode error response: %v", err)
+				}
+				if errorResp.Error != "Port not allowed" {
+					t.Errorf("Expected 'Port not allowed', got %q", errorResp.Error)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_IncludeOCSP tests the ocsp claim carrying the upstream's stapled OCSP response
+func TestHandleGetPins_IncludeOCSP(t *testing.T) {
+	staple := []byte("mock ocsp response")
+
+	tests := []struct {
+		name         string
+		staple       []byte
+		query        string
+		expectedOCSP interface{}
+	}{
+		{"stapled", staple, "&include-ocsp=true", base64.StdEncoding.EncodeToString(staple)},
+		{"not_stapled", nil, "&include-ocsp=true", nil},
+		{"not_requested", staple, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, fake := createTestServer(t)
+			cfg := *base.config
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			fake.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			server := NewWithRetriever(&cfg, &infoRetriever{
+				FakeRetriever: fake,
+				info:          &cert.ConnectionInfo{Version: tls.VersionTLS13, OCSPResponse: tt.staple},
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+			if payload["ocsp"] != tt.expectedOCSP {
+				t.Errorf("Expected ocsp claim %v, got %v", tt.expectedOCSP, payload["ocsp"])
+			}
+		})
+	}
+}
+
+func TestHandleGetPins_SkewToleranceClaim(t *testing.T) {
+	tests := []struct {
+		name      string
+		tolerance time.Duration
+	}{
+		{"configured", 90 * time.Second},
+		{"unset", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.ClientSkewTolerance = tt.tolerance
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+
+			skew, present := payload["skew_tolerance_seconds"]
+			if tt.tolerance == 0 {
+				if present {
+					t.Errorf("Expected no skew_tolerance_seconds claim, got %v", skew)
+				}
+				return
+			}
+			if skew != float64(90) {
+				t.Errorf("Expected skew_tolerance_seconds 90, got %v", skew)
+			}
+		})
+	}
+}
+
+func TestHandleGetPins_IncludeCertValidity(t *testing.T) {
+	notBefore := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	leaf, err := cert.GenerateTestCertificateWithTemplate(&x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		requested bool
+	}{
+		{"requested", "&include-cert-validity=true", true},
+		{"not_requested", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			retriever.SetCertificates("example.com", []*x509.Certificate{leaf})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+
+			if !tt.requested {
+				if _, present := payload["cert_not_after"]; present {
+					t.Error("Expected no cert validity claims when not requested")
+				}
+				return
+			}
+			if payload["cert_not_before"] != float64(notBefore.Unix()) {
+				t.Errorf("Expected cert_not_before %d, got %v", notBefore.Unix(), payload["cert_not_before"])
+			}
+			if payload["cert_not_after"] != float64(notAfter.Unix()) {
+				t.Errorf("Expected cert_not_after %d, got %v", notAfter.Unix(), payload["cert_not_after"])
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_Environment tests the env claim and X-Environment header
+func TestHandleGetPins_Environment(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+	}{
+		{"configured", "staging"},
+		{"unset", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.Environment = tt.environment
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			if got := w.Header().Get("X-Environment"); got != tt.environment {
+				t.Errorf("Expected X-Environment %q, got %q", tt.environment, got)
+			}
+			if _, present := w.Header()["X-Environment"]; tt.environment == "" && present {
+				t.Error("Expected no X-Environment header when ENVIRONMENT is unset")
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+			env, present := payload["env"]
+			if tt.environment == "" {
+				if present {
+					t.Errorf("Expected no env claim, got %v", env)
+				}
+			} else if env != tt.environment {
+				t.Errorf("Expected env claim %q, got %v", tt.environment, env)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_SigningRetry tests that a transient signing failure is retried once
+func TestHandleGetPins_SigningRetry(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		failures       int
+		expectedStatus int
+	}{
+		{"compact_one_shot_failure", "", 1, http.StatusOK},
+		{"json_one_shot_failure", "&serialization=json", 1, http.StatusOK},
+		{"cose_one_shot_failure", "&format=cose", 1, http.StatusOK},
+		{"persistent_failure", "", 2, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+			attempts := 0
+			createJWS, createJWSJSON, createCOSE := server.createJWS, server.createJWSJSON, server.createCOSE
+			server.createJWS = func(key stdcrypto.Signer, keyID, typ, domain string, pins []string, ttl time.Duration, claims map[string]interface{}) (string, error) {
+				if attempts++; attempts <= tt.failures {
+					return "", errors.New("injected signing failure")
+				}
+				return createJWS(key, keyID, typ, domain, pins, ttl, claims)
+			}
+			server.createJWSJSON = func(key stdcrypto.Signer, keyID, typ, domain string, pins []string, ttl time.Duration, claims map[string]interface{}) ([]byte, error) {
+				if attempts++; attempts <= tt.failures {
+					return nil, errors.New("injected signing failure")
+				}
+				return createJWSJSON(key, keyID, typ, domain, pins, ttl, claims)
+			}
+			server.createCOSE = func(key stdcrypto.Signer, keyID, domain string, pins []string, ttl time.Duration, claims map[string]interface{}) ([]byte, error) {
+				if attempts++; attempts <= tt.failures {
+					return nil, errors.New("injected signing failure")
+				}
+				return createCOSE(key, keyID, domain, pins, ttl, claims)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if attempts != 2 {
+				t.Errorf("Expected exactly 2 signing attempts, got %d", attempts)
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_IncludeAllRoots tests pinning every root of a cross-signed chain
+func TestHandleGetPins_IncludeAllRoots(t *testing.T) {
+	chain, roots, err := cert.GenerateCrossSignedTestChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate cross-signed chain: %v", err)
+	}
+	trusted := x509.NewCertPool()
+	for _, root := range roots {
+		trusted.AddCert(root)
+	}
+
+	expected := crypto.GenerateSPKIHashes([]*x509.Certificate{chain[0], roots[0], roots[1]})
+
+	tests := []struct {
+		name           string
+		query          string
+		pool           *x509.CertPool
+		expectedStatus int
+		expectedPins   []string
+	}{
+		{"all_roots", "&include-all-roots=true", trusted, http.StatusOK, expected},
+		{"leaf_only_by_default", "", trusted, http.StatusOK, expected[:1]},
+		{"no_verified_chains", "&include-all-roots=true", x509.NewCertPool(), http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.rootCAs = tt.pool
+			retriever.SetCertificates("example.com", chain)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			payload := decodeJWSPayload(t, resp["jws"])
+			pins, _ := payload["pins"].([]interface{})
+			if len(pins) != len(tt.expectedPins) {
+				t.Fatalf("Expected pins %v, got %v", tt.expectedPins, pins)
+			}
+			for i, pin := range tt.expectedPins {
+				if pins[i] != pin {
+					t.Errorf("Pin %d: expected %s, got %v", i, pin, pins[i])
+				}
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_PinSortLeafFirst tests that PIN_SORT=leaf-first keeps the
+// leaf pin first and sorts the others
+func TestHandleGetPins_PinSortLeafFirst(t *testing.T) {
+	chain, roots, err := cert.GenerateCrossSignedTestChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate cross-signed chain: %v", err)
+	}
+	trusted := x509.NewCertPool()
+	for _, root := range roots {
+		trusted.AddCert(root)
+	}
+
+	server, retriever := createTestServer(t)
+	server.config.PinSort = config.PinSortLeafFirst
+	server.rootCAs = trusted
+	retriever.SetCertificates("example.com", chain)
+
+	// Two roots after the leaf, so the backup pins have an order to settle
+	leafPin := crypto.GenerateSPKIHashes(chain[:1])[0]
+	backupPins := crypto.GenerateSPKIHashes(roots)
+	sort.Strings(backupPins)
+	expected := append([]string{leafPin}, backupPins...)
+
+	for range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&include-all-roots=true", nil)
+		w := httptest.NewRecorder()
+
+		server.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var resp map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		pins, _ := decodeJWSPayload(t, resp["jws"])["pins"].([]interface{})
+		if len(pins) != len(expected) {
+			t.Fatalf("Expected pins %v, got %v", expected, pins)
+		}
+		for i, pin := range expected {
+			if pins[i] != pin {
+				t.Errorf("Pin %d: expected %s, got %v", i, pin, pins[i])
+			}
+		}
+	}
+}
+
+// TestHandleGetPins_ForceLeafOnly tests that FORCE_LEAF_ONLY suppresses backup and root pins
+func TestHandleGetPins_ForceLeafOnly(t *testing.T) {
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test chain: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		forceLeafOnly bool
+		defaultBackup bool
+		query         string
+		expectedPins  int
+	}{
+		{"backup_requested", true, false, "&include-backup-pins=true", 1},
+		{"backup_by_default", true, true, "", 1},
+		{"backup_allowed_when_unset", false, false, "&include-backup-pins=true", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.ForceLeafOnly = tt.forceLeafOnly
+			server.config.DefaultIncludeBackup = tt.defaultBackup
+			retriever.SetCertificates("example.com", chain)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			pins, _ := decodeJWSPayload(t, resp["jws"])["pins"].([]interface{})
+			if len(pins) != tt.expectedPins {
+				t.Fatalf("Expected %d pins, got %d", tt.expectedPins, len(pins))
+			}
+			if pins[0] != crypto.GenerateSPKIHash(chain[0]) {
+				t.Errorf("Expected the leaf pin first, got %v", pins[0])
+			}
+		})
+	}
+}
+
+// TestHandleGetPins_BackupChainPolicy tests BACKUP_REQUIRE_INTERMEDIATE and BACKUP_MAX_CERTS
+func TestHandleGetPins_BackupChainPolicy(t *testing.T) {
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test chain: %v", err)
+	}
+	extra, err := cert.GenerateTestCertificate("Root CA")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	single := chain[:1]
+	long := append(append([]*x509.Certificate{}, chain...), extra)
+
+	tests := []struct {
+		name                string
+		certs               []*x509.Certificate
+		requireIntermediate bool
+		maxCerts            int
+		query               string
+		expectedStatus      int
+		expectedPins        int
+	}{
+		{"single_cert_allowed_by_default", single, false, 0, "&include-backup-pins=true", http.StatusOK, 1},
+		{"single_cert_rejected_when_required", single, true, 0, "&include-backup-pins=true", http.StatusUnprocessableEntity, 0},
+		{"single_cert_without_backup", single, true, 0, "", http.StatusOK, 1},
+		{"intermediate_present", chain, true, 2, "&include-backup-pins=true", http.StatusOK, 2},
+		{"long_chain_truncated_by_default", long, false, 0, "&include-backup-pins=true", http.StatusOK, 2},
+		{"long_chain_rejected_above_max", long, false, 2, "&include-backup-pins=true", http.StatusUnprocessableEntity, 0},
+		{"long_chain_without_backup", long, false, 2, "", http.StatusOK, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			server.config.BackupRequireIntermediate = tt.requireIntermediate
+			server.config.BackupMaxCerts = tt.maxCerts
+			retriever.SetCertificates("example.com", tt.certs)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			pins, _ := decodeJWSPayload(t, resp["jws"])["pins"].([]interface{})
+			if len(pins) != tt.expectedPins {
+				t.Errorf("Expected %d pins, got %d", tt.expectedPins, len(pins))
+			}
+		})
+	}
+}
+
+func TestHandleGetPins_ATSPlist(t *testing.T) {
+	chain, _, err := cert.GenerateCrossSignedTestChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate chain: %v", err)
+	}
+	expected := crypto.GenerateSPKIHashes(chain)
+
+	server, retriever := createTestServer(t)
+	retriever.SetCertificates("example.com", chain)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&include-backup-pins=true&format=ats-plist", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("Expected Content-Type application/xml, got %q", ct)
+	}
+
+	dec := xml.NewDecoder(w.Body)
+	var root interface{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatalf("Failed to find plist root: %v", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "plist" {
+			if root, err = parsePlistValue(dec); err != nil {
+				t.Fatalf("Failed to parse plist: %v", err)
+			}
+			break
+		}
+	}
+
+	domains := plistPath(t, root, "NSAppTransportSecurity", "NSPinnedDomains")
+	domainDict, ok := domains["example.com"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected example.com entry in NSPinnedDomains, got %v", domains)
+	}
+
+	identities := func(key string) []string {
+		list, ok := domainDict[key].([]interface{})
+		if !ok {
+			t.Fatalf("Expected %s array, got %v", key, domainDict[key])
+		}
+		var pins []string
+		for _, item := range list {
+			entry, _ := item.(map[string]interface{})
+			pin, _ := entry["SPKI-SHA256-BASE64"].(string)
+			pins = append(pins, pin)
+		}
+		return pins
+	}
+	if leaf := identities("NSPinnedLeafIdentities"); len(leaf) != 1 || leaf[0] != expected[0] {
+		t.Errorf("Expected leaf identities [%s], got %v", expected[0], leaf)
+	}
+	if ca := identities("NSPinnedCAIdentities"); len(ca) != 1 || ca[0] != expected[1] {
+		t.Errorf("Expected CA identities [%s], got %v", expected[1], ca)
+	}
+}
+
+func TestHandleGetPins_ATSPlistRejectedCombinations(t *testing.T) {
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate chain: %v", err)
+	}
+	server, retriever := createTestServer(t)
+	retriever.SetCertificates("example.com", chain)
+
+	for _, query := range []string{"&format=pem", "&format=ats-plist&pin-type=aki", "&format=ats-plist&include-www=true", "&format=android-nsc&pin-type=aki", "&format=cose&serialization=json"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com"+query, nil)
+		w := httptest.NewRecorder()
+
+		server.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestHandleGetPins_AndroidNSC(t *testing.T) {
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate chain: %v", err)
+	}
+	expected := crypto.GenerateSPKIHashes(chain[:2])
+
+	server, retriever := createTestServer(t)
+	retriever.SetCertificates("example.com", chain)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&include-backup-pins=true&format=android-nsc", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("Expected Content-Type application/xml, got %q", ct)
+	}
+
+	var nsc struct {
+		XMLName      xml.Name `xml:"network-security-config"`
+		DomainConfig struct {
+			Domain struct {
+				Name              string `xml:",chardata"`
+				IncludeSubdomains string `xml:"includeSubdomains,attr"`
+			} `xml:"domain"`
+			Pins []struct {
+				Digest string `xml:"digest,attr"`
+				Value  string `xml:",chardata"`
+			} `xml:"pin-set>pin"`
+		} `xml:"domain-config"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &nsc); err != nil {
+		t.Fatalf("Failed to parse network security config: %v", err)
+	}
+
+	if nsc.DomainConfig.Domain.Name != "example.com" {
+		t.Errorf("Expected domain example.com, got %q", nsc.DomainConfig.Domain.Name)
+	}
+	if len(nsc.DomainConfig.Pins) != len(expected) {
+		t.Fatalf("Expected %d pins, got %d", len(expected), len(nsc.DomainConfig.Pins))
+	}
+	for i, pin := range nsc.DomainConfig.Pins {
+		if pin.Digest != "SHA-256" {
+			t.Errorf("Expected digest SHA-256, got %q", pin.Digest)
+		}
+		if pin.Value != expected[i] {
+			t.Errorf("Expected pin %s, got %s", expected[i], pin.Value)
+		}
+	}
+}
+
+func TestHandleGetPins_COSE(t *testing.T) {
+	chain, err := cert.GenerateTestCertificateChain("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate chain: %v", err)
+	}
+	expected := crypto.GenerateSPKIHashes(chain[:2])
+
+	server, retriever := createTestServer(t)
+	server.config.VerifyAfterSign = true
+	retriever.SetCertificates("example.com", chain)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com&include-backup-pins=true&format=cose&nonce=abc", nil)
+	w := httptest.NewRecorder()
+
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != `application/cose; cose-type="cose-sign1"` {
+		t.Errorf("Expected COSE Content-Type, got %q", ct)
+	}
+
+	claims, err := crypto.VerifyCOSE(server.config.PublicKey, w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to verify COSE token: %v", err)
+	}
+	if claims["domain"] != "example.com" || claims["nonce"] != "abc" {
+		t.Errorf("Unexpected claims: %v", claims)
+	}
+	pins, _ := claims["pins"].([]interface{})
+	if len(pins) != len(expected) {
+		t.Fatalf("Expected %d pins, got %v", len(expected), claims["pins"])
+	}
+	for i, pin := range pins {
+		if pin != expected[i] {
+			t.Errorf("Expected pin %s, got %v", expected[i], pin)
+		}
+	}
+}
+
+// parsePlistValue decodes the next plist value (dict, array, string or other scalar)
+func parsePlistValue(dec *xml.Decoder) (interface{}, error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "dict":
+				dict := make(map[string]interface{})
+				for {
+					var key string
+					tok, err := dec.Token()
+					if err != nil {
+						return nil, err
+					}
+					if end, ok := tok.(xml.EndElement); ok && end.Name.Local == "dict" {
+						return dict, nil
+					}
+					start, ok := tok.(xml.StartElement)
+					if !ok {
+						continue
+					}
+					if start.Name.Local != "key" {
+						return nil, fmt.Errorf("expected key, got %s", start.Name.Local)
+					}
+					if err := dec.DecodeElement(&key, &start); err != nil {
+						return nil, err
+					}
+					if dict[key], err = parsePlistValue(dec); err != nil {
+						return nil, err
+					}
+				}
+			case "array":
+				var list []interface{}
+				for {
+					value, err := parsePlistValue(dec)
+					if err != nil {
+						return nil, err
+					}
+					if value == nil {
+						return list, nil
+					}
+					list = append(list, value)
+				}
+			default:
+				var text string
+				if err := dec.DecodeElement(&text, &el); err != nil {
+					return nil, err
+				}
+				return text, nil
+			}
+		case xml.EndElement:
+			// End of the enclosing array
+			return nil, nil
+		}
+	}
+}
+
+// plistPath walks nested plist dictionaries by key
+func plistPath(t *testing.T, value interface{}, keys ...string) map[string]interface{} {
+	t.Helper()
+	dict, ok := value.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected plist dict, got %v", value)
+	}
+	for _, key := range keys {
+		if dict, ok = dict[key].(map[string]interface{}); !ok {
+			t.Fatalf("Expected dict under %s", key)
+		}
+	}
+	return dict
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxLoggedHeaderLength caps the length of a header value attached to logs
+const maxLoggedHeaderLength = 256
+
+// redactedHeaderValue replaces header values that look like credentials
+const redactedHeaderValue = "[REDACTED]"
+
+// credentialHeaderMarkers identify header names that carry credentials
+var credentialHeaderMarkers = []string{"authorization", "cookie", "token", "secret", "password", "api-key", "apikey", "session"}
+
+// credentialValuePrefixes identify header values that carry credentials
+var credentialValuePrefixes = []string{"bearer ", "basic ", "digest ", "negotiate "}
+
+// requestHeaderAttr returns a "headers" log group holding the values of the
+// LOG_REQUEST_HEADERS allowlist present on r
+// The group is empty, and omitted from logs, when no allowlisted header is set
+func (s *Server) requestHeaderAttr(r *http.Request) slog.Attr {
+	var attrs []any
+	for _, name := range s.config.LogRequestHeaders {
+		value := r.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		attrs = append(attrs, slog.String(name, sanitizeHeaderValue(name, value)))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// sanitizeHeaderValue redacts credential-like values and truncates long ones
+func sanitizeHeaderValue(name, value string) string {
+	lowerName := strings.ToLower(name)
+	for _, marker := range credentialHeaderMarkers {
+		if strings.Contains(lowerName, marker) {
+			return redactedHeaderValue
+		}
+	}
+
+	lowerValue := strings.ToLower(value)
+	for _, prefix := range credentialValuePrefixes {
+		if strings.HasPrefix(lowerValue, prefix) {
+			return redactedHeaderValue
+		}
+	}
+
+	if len(value) > maxLoggedHeaderLength {
+		return value[:maxLoggedHeaderLength] + "..."
+	}
+	return value
+}
+
+// setSecurityHeaders sets the response headers requested by security scanners
+// Every response gets the minimal set; pin responses are also never cached or framed
+func setSecurityHeaders(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "no-referrer")
+
+	if r.URL.Path == "/v1/pins" || strings.HasPrefix(r.URL.Path, "/v1/pins/") {
+		h.Set("Cache-Control", "no-store")
+		h.Set("X-Frame-Options", "DENY")
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+// formatAndroidNSC is the format parameter value returning pins as an Android Network Security Config
+const formatAndroidNSC = "android-nsc"
+
+// androidNSC renders pins as an Android network_security_config.xml pinning domain.
+// Android accepts a chain when any of its certificates matches a pin, so the leaf
+// and backup pins share one pin-set.
+func androidNSC(domain string, pins []string) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString("<network-security-config>\n")
+	b.WriteString("\t<domain-config>\n")
+	b.WriteString("\t\t<domain includeSubdomains=\"false\">")
+	xml.EscapeText(&b, []byte(domain))
+	b.WriteString("</domain>\n")
+	b.WriteString("\t\t<pin-set>\n")
+	for _, pin := range pins {
+		b.WriteString("\t\t\t<pin digest=\"SHA-256\">")
+		xml.EscapeText(&b, []byte(pin))
+		b.WriteString("</pin>\n")
+	}
+	b.WriteString("\t\t</pin-set>\n")
+	b.WriteString("\t</domain-config>\n")
+	b.WriteString("</network-security-config>\n")
+	return b.Bytes()
+}
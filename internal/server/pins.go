@@ -0,0 +1,709 @@
+package server
+
+import (
+	stdcrypto "crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"pinning-server/internal/cert"
+	"pinning-server/internal/config"
+	"pinning-server/internal/crypto"
+	"pinning-server/internal/domain"
+	"pinning-server/internal/logger"
+)
+
+// Supported values of the pin-type parameter
+const (
+	pinTypeSPKI = "spki"
+	pinTypeAKI  = "aki"
+)
+
+// maxNonceLength is the maximum length of a client supplied nonce
+const maxNonceLength = 128
+
+// pinRequest describes a pin issuance request independent of the transport
+type pinRequest struct {
+	domain        string
+	includeBackup bool
+	fetchOpts     cert.FetchOptions
+	pinType       string        // pinTypeSPKI (default) or pinTypeAKI
+	nonce         string        // Echoed as the nonce claim when non-empty
+	jsonJWS       bool          // Use the flattened JSON serialization instead of compact
+	detached      bool          // Sign a compact JWS with a detached, unencoded payload
+	cose          bool          // Sign a COSE_Sign1 token instead of a JWS
+	includeWWW    bool          // Also pin the www. variant of the domain if whitelisted
+	lifetime      time.Duration // Token lifetime (0 uses SIGNATURE_LIFETIME)
+	includeOCSP   bool          // Add the stapled OCSP response as the ocsp claim, if any
+	allRoots      bool          // Also pin every root the chain verifies to
+	certValidity  bool          // Add the leaf's validity window as cert_not_before/cert_not_after claims
+}
+
+// pinResult holds the outcome of a successful pin issuance
+type pinResult struct {
+	jws             string          // Compact serialization
+	jwsJSON         json.RawMessage // Flattened JSON serialization (if requested instead)
+	cose            []byte          // COSE_Sign1 token (if requested instead)
+	payload         []byte          // Payload signed by a detached jws
+	pins            []string
+	leafFingerprint string               // SHA-256 fingerprint of the full leaf certificate
+	connInfo        *cert.ConnectionInfo // Upstream TLS connection (nil if unknown)
+}
+
+// resolvedPins holds the pins generated from a domain's current chain
+type resolvedPins struct {
+	pins            []string
+	claims          map[string]interface{} // Extra claims describing the pins
+	leafFingerprint string                 // SHA-256 fingerprint of the full leaf certificate
+	connInfo        *cert.ConnectionInfo   // Upstream TLS connection (nil if unknown)
+}
+
+// connectionInfoRetriever is implemented by retrievers that report the TLS
+// connection a chain was retrieved over
+type connectionInfoRetriever interface {
+	GetCertificatesWithInfo(domain string, opts cert.FetchOptions) ([]*x509.Certificate, *cert.ConnectionInfo, error)
+}
+
+// pinError describes why a pin issuance failed
+type pinError struct {
+	status  int    // HTTP status code
+	message string // Client-facing error message
+	reason  string // Machine-readable reason used in logs
+	detail  string // Sanitized upstream failure detail returned to clients, if any
+}
+
+// Error implements the error interface
+func (e *pinError) Error() string {
+	return e.message
+}
+
+// issuePins validates the domain, retrieves its certificates, generates the
+// pins and signs them. It is shared by every transport serving pins.
+func (s *Server) issuePins(req pinRequest) (*pinResult, *pinError) {
+	// Never pin CAs when the operator forbids it
+	if s.config.ForceLeafOnly && (req.includeBackup || req.allRoots) {
+		logger.Info("Suppressed CA pins (FORCE_LEAF_ONLY)",
+			"domain", req.domain,
+			"include_backup", req.includeBackup,
+			"include_all_roots", req.allRoots)
+		req.includeBackup = false
+		req.allRoots = false
+	}
+
+	resolved, pinErr := s.resolvePins(req)
+	if pinErr != nil {
+		return nil, pinErr
+	}
+	pins, extraClaims := resolved.pins, resolved.claims
+
+	// Merge in the pins of the www. variant
+	if req.includeWWW {
+		pins = s.mergeWWWPins(req, pins)
+	}
+
+	// Pre-trust the operator's future keys; they are SPKI hashes only
+	if req.pinType != pinTypeAKI {
+		pins, extraClaims = s.appendBackupPins(req.domain, pins, extraClaims)
+	}
+
+	// Keep the leaf first but make the backup pin order reproducible
+	if s.config.PinSort == config.PinSortLeafFirst && len(pins) > 2 {
+		sort.Strings(pins[1:])
+	}
+
+	// Bind the token to the client's challenge
+	if req.nonce != "" {
+		if extraClaims == nil {
+			extraClaims = make(map[string]interface{})
+		}
+		extraClaims["nonce"] = req.nonce
+	}
+
+	lifetime, raised := s.tokenLifetime(req.lifetime)
+	if raised {
+		logger.Warn("Token lifetime below MIN_TTL, raised",
+			"domain", req.domain,
+			"requested", s.requestedLifetime(req.lifetime).String(),
+			"min_ttl", s.config.MinTTL.String())
+	}
+
+	// Claim the domain as clients compare it rather than as it was requested
+	claimDomain := req.domain
+	if s.config.CanonicalDomainClaim {
+		claimDomain = domain.Normalize(req.domain)
+	}
+
+	var result *pinResult
+	switch {
+	case req.cose:
+		result, pinErr = s.signPinsCOSE(claimDomain, pins, lifetime, extraClaims)
+	case req.jsonJWS:
+		result, pinErr = s.signPinsJSON(claimDomain, pins, lifetime, extraClaims)
+	case req.detached:
+		result, pinErr = s.signPinsDetached(claimDomain, pins, lifetime, extraClaims)
+	default:
+		result, pinErr = s.signPins(claimDomain, pins, lifetime, extraClaims)
+	}
+	if pinErr != nil {
+		return nil, pinErr
+	}
+	result.leafFingerprint = resolved.leafFingerprint
+	result.connInfo = resolved.connInfo
+	return result, nil
+}
+
+// requestedLifetime returns the lifetime asked for by the request, or
+// SIGNATURE_LIFETIME when it did not ask for one
+func (s *Server) requestedLifetime(lifetime time.Duration) time.Duration {
+	if lifetime == 0 {
+		return s.config.SignatureLifetime
+	}
+	return lifetime
+}
+
+// tokenLifetime returns the lifetime a token is issued with: the requested
+// lifetime raised to MIN_TTL, reporting whether it had to be raised
+func (s *Server) tokenLifetime(lifetime time.Duration) (time.Duration, bool) {
+	lifetime = s.requestedLifetime(lifetime)
+	if lifetime < s.config.MinTTL {
+		return s.config.MinTTL, true
+	}
+	return lifetime, false
+}
+
+// mergeWWWPins adds the pins of the www. variant of the requested domain to pins,
+// skipping the variant if the request already names a www. host, the variant is not
+// whitelisted, or its pins cannot be resolved
+func (s *Server) mergeWWWPins(req pinRequest, pins []string) []string {
+	if strings.HasPrefix(strings.ToLower(req.domain), "www.") {
+		return pins
+	}
+
+	variant := "www." + req.domain
+	if !s.validator.IsAllowed(variant) {
+		logger.Info("Skipping www variant not in whitelist", "domain", req.domain, "variant", variant)
+		return pins
+	}
+
+	variantReq := req
+	variantReq.domain = variant
+	variantResolved, pinErr := s.resolvePins(variantReq)
+	if pinErr != nil {
+		logger.Warn("Skipping www variant", "domain", req.domain, "variant", variant, "error", pinErr.reason)
+		return pins
+	}
+
+	seen := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		seen[pin] = true
+	}
+	for _, pin := range variantResolved.pins {
+		if !seen[pin] {
+			seen[pin] = true
+			pins = append(pins, pin)
+		}
+	}
+
+	logger.Info("Included www variant", "domain", req.domain, "variant", variant, "variant_pins", len(variantResolved.pins))
+	return pins
+}
+
+// resolvePins validates the domain, retrieves its certificates and generates
+// the current pins along with any extra claims describing them
+func (s *Server) resolvePins(req pinRequest) (*resolvedPins, *pinError) {
+	domain := req.domain
+
+	// Validate domain format (basic validation for malformed domains)
+	if len(domain) == 0 || len(domain) > 253 {
+		return nil, &pinError{status: http.StatusBadRequest, message: "Invalid domain parameter", reason: "invalid_domain"}
+	}
+
+	// The request must name a concrete host even though the whitelist may contain wildcards
+	if strings.Contains(domain, "*") {
+		return nil, &pinError{status: http.StatusBadRequest, message: "Wildcard not allowed in request domain", reason: "wildcard_domain"}
+	}
+
+	// Best-effort protection against homograph domains such as "аpple.com" (Cyrillic а)
+	if s.config.RejectMixedScript && hasMixedScript(domain) {
+		logger.Warn("Mixed-script domain rejected", "domain", domain)
+		return nil, &pinError{status: http.StatusBadRequest, message: "Domain mixes scripts (possible homograph)", reason: "mixed_script"}
+	}
+
+	// Validate domain is in whitelist. With WHITELIST_BY_SAN, other domains are
+	// fetched and checked against the leaf's SANs below, but only if they are
+	// well-formed hostnames so arbitrary input is never dialed.
+	rule, allowed := s.validator.Match(domain)
+	if !allowed && !(s.config.WhitelistBySAN && isValidHostname(domain)) {
+		logger.Warn("Domain not in whitelist", s.domainLogAttrs(domain)...)
+		return nil, s.domainNotAllowed()
+	}
+	if allowed {
+		logger.Info("Domain matched whitelist rule", append(s.domainLogAttrs(domain), "rule", rule)...)
+	}
+
+	claims := make(map[string]interface{})
+
+	// Retrieve certificates for the domain
+	var certs []*x509.Certificate
+	var connInfo *cert.ConnectionInfo
+	var err error
+	if infoRetriever, ok := s.retriever.(connectionInfoRetriever); ok {
+		certs, connInfo, err = infoRetriever.GetCertificatesWithInfo(domain, req.fetchOpts)
+	} else {
+		certs, err = s.retriever.GetCertificatesWithOptions(domain, req.fetchOpts)
+	}
+	// A fetch refused or aborted by shutdown says nothing about the upstream
+	if errors.Is(err, cert.ErrShuttingDown) {
+		logger.Warn("Certificate retrieval aborted by shutdown", "domain", domain, "error", err)
+		return nil, &pinError{status: http.StatusServiceUnavailable, message: "Server is shutting down, retry later", reason: "shutting_down"}
+	}
+	if err == nil && len(certs) == 0 {
+		err = cert.ErrNoCertificates
+	}
+	if err != nil {
+		s.alerts.recordFailure(domain, err)
+	} else {
+		s.alerts.recordSuccess(domain)
+	}
+	if errors.Is(err, cert.ErrNoCertificates) {
+		logger.Error("Upstream presented no certificates",
+			"domain", domain,
+			"port", req.fetchOpts.Port,
+			"starttls", req.fetchOpts.STARTTLS,
+			"error", err)
+		return nil, &pinError{status: http.StatusUnprocessableEntity, message: "No certificate presented by domain", reason: "no_certificates"}
+	}
+	if err != nil {
+		upstreamReason, detail := cert.ClassifyDialError(err)
+		logger.Error("Failed to retrieve certificates",
+			"domain", domain,
+			"port", req.fetchOpts.Port,
+			"starttls", req.fetchOpts.STARTTLS,
+			"upstream_reason", upstreamReason,
+			"error", err)
+		return nil, &pinError{
+			status:  s.certFailureStatus(upstreamReason),
+			message: "Failed to retrieve certificate for domain",
+			reason:  "cert_retrieval_failed",
+			detail:  detail,
+		}
+	}
+
+	// Optionally tell clients how the upstream connection was secured, for debugging
+	if s.config.TLSInfoClaim && connInfo != nil {
+		claims["tls_version"] = connInfo.VersionName()
+		claims["cipher_suite"] = connInfo.CipherSuiteName()
+	}
+
+	// Let clients run their own revocation checks against the stapled response
+	if req.includeOCSP && connInfo != nil && len(connInfo.OCSPResponse) > 0 {
+		claims["ocsp"] = base64.StdEncoding.EncodeToString(connInfo.OCSPResponse)
+	}
+
+	// Do not trust the upstream to send the leaf first
+	if s.config.IdentifyLeaf {
+		certs = cert.OrderChain(certs, domain)
+	}
+
+	// The leaf must cover the requested domain and share a SAN with the whitelist
+	if !allowed {
+		rule, allowed = s.matchLeafSAN(domain, certs[0])
+		if !allowed {
+			logger.Warn("Domain not in whitelist and no whitelisted SAN", "domain", domain)
+			return nil, s.domainNotAllowed()
+		}
+		logger.Info("Domain allowed by certificate SAN", "domain", domain, "rule", rule)
+	}
+
+	// Alarm when a critical domain's leaf no longer has the key operators expect
+	if pinErr := s.checkPinDrift(domain, certs[0]); pinErr != nil {
+		return nil, pinErr
+	}
+
+	// Optionally tell clients which rule allowed the domain, for debugging
+	if s.config.MatchedRuleClaim {
+		claims["matched_rule"] = rule
+	}
+
+	logger.Debug("Retrieved certificate",
+		"domain", domain,
+		"subject", certs[0].Subject.CommonName,
+		"not_after", certs[0].NotAfter,
+		"sans", limitSANs(certs[0].DNSNames, s.config.MaxLoggedSANs),
+		"chain_length", len(certs))
+
+	// Let clients refresh pins before the underlying certificate expires
+	if req.certValidity {
+		claims["cert_not_before"] = certs[0].NotBefore.Unix()
+		claims["cert_not_after"] = certs[0].NotAfter.Unix()
+	}
+
+	// Refuse to pin leaf certificates that are not TLS server certificates
+	if s.config.RequireServerAuthEKU && len(certs) > 0 {
+		if err := cert.ValidateServerAuth(certs[0]); err != nil {
+			logger.Warn("Leaf certificate rejected", "domain", domain, "error", err)
+			return nil, &pinError{status: http.StatusUnprocessableEntity, message: "Certificate is not valid for TLS server authentication", reason: "missing_server_auth_eku"}
+		}
+	}
+
+	// Never pin a lone self-signed leaf unless explicitly allowed
+	if !s.config.AllowSelfSigned {
+		if err := cert.ValidateNotSelfSigned(certs); err != nil {
+			logger.Warn("Leaf certificate rejected", "domain", domain, "error", err)
+			return nil, &pinError{status: http.StatusUnprocessableEntity, message: "Refusing to pin self-signed certificate", reason: "self_signed"}
+		}
+	}
+
+	// Refuse anything issued under a distrusted CA, anywhere in the chain
+	if issuer, blocked := s.blockedIssuers.Match(certs); blocked {
+		logger.Warn("Certificate chain rejected", "domain", domain, "blocked_issuer", issuer)
+		return nil, &pinError{status: http.StatusUnprocessableEntity,
+			message: "Certificate chain includes a blocked issuer: " + issuer, reason: "blocked_issuer"}
+	}
+
+	// Refuse leaves that will expire before clients can rotate their pins
+	if minValidity := s.config.CertMinRemainingValidity; minValidity > 0 && len(certs) > 0 {
+		if remaining := time.Until(certs[0].NotAfter); remaining < minValidity {
+			logger.Warn("Leaf certificate expires too soon",
+				"domain", domain,
+				"not_after", certs[0].NotAfter,
+				"min_remaining_validity", minValidity.String())
+			return nil, &pinError{status: http.StatusUnprocessableEntity,
+				message: "Certificate expires too soon to be pinned; rotate the certificate first", reason: "cert_expiring"}
+		}
+	}
+
+	// Pin the issuing authority's key identifier instead of the SPKI
+	if req.pinType == pinTypeAKI {
+		aki, err := crypto.GenerateAKI(certs)
+		if err != nil {
+			logger.Warn("No authority key identifier available", "domain", domain, "error", err)
+			return nil, &pinError{status: http.StatusUnprocessableEntity, message: "Certificate has no authority key identifier", reason: "missing_aki"}
+		}
+		claims["pin_type"] = pinTypeAKI
+		return &resolvedPins{
+			pins:            []string{aki},
+			claims:          claims,
+			leafFingerprint: crypto.CertificateFingerprint(certs[0]),
+			connInfo:        connInfo,
+		}, nil
+	}
+
+	// Enforce the backup pin chain policy before choosing the backup certificate
+	if req.includeBackup {
+		if s.config.BackupRequireIntermediate && len(certs) < 2 {
+			logger.Warn("Chain has no intermediate for backup pin", "domain", domain, "chain_length", len(certs))
+			return nil, &pinError{status: http.StatusUnprocessableEntity, message: "Certificate chain has no intermediate for a backup pin", reason: "backup_missing_intermediate"}
+		}
+		if s.config.BackupMaxCerts > 0 && len(certs) > s.config.BackupMaxCerts {
+			logger.Warn("Chain too long for backup pin", "domain", domain, "chain_length", len(certs), "max", s.config.BackupMaxCerts)
+			return nil, &pinError{status: http.StatusUnprocessableEntity, message: "Certificate chain is longer than allowed for backup pins", reason: "backup_chain_too_long"}
+		}
+	}
+
+	// Determine which certificates to use for pin generation
+	var certsForPinning []*x509.Certificate
+	if req.includeBackup && len(certs) > 1 && !(s.config.ExcludeRootFromBackup && cert.IsSelfIssuedRoot(certs[1])) {
+		// Use leaf and intermediate certificate
+		certsForPinning = certs[:2]
+	} else if len(certs) > 0 {
+		// Use only leaf certificate
+		certsForPinning = certs[:1]
+	}
+
+	// Pin every root of every valid chain, for clients facing cross-signed hierarchies
+	if req.allRoots {
+		roots, err := cert.VerifiedRoots(certs, s.rootCAs)
+		if err != nil {
+			logger.Warn("Certificate chain did not verify", "domain", domain, "error", err)
+			return nil, &pinError{status: http.StatusUnprocessableEntity, message: "Certificate chain does not verify to a trusted root", reason: "no_verified_chains"}
+		}
+		certsForPinning = append(append([]*x509.Certificate{}, certsForPinning...), roots...)
+	}
+
+	// Generate SPKI hashes in TrustKit format: base64(SHA256(SPKI))
+	return &resolvedPins{
+		pins:            uniquePins(crypto.GenerateSPKIHashes(certsForPinning)),
+		claims:          claims,
+		leafFingerprint: crypto.CertificateFingerprint(certs[0]),
+		connInfo:        connInfo,
+	}, nil
+}
+
+// appendBackupPins appends the BACKUP_PINS of domain that are not live pins yet,
+// listing them in the backup_pins claim so clients can tell the key of a
+// planned rotation from the keys currently served
+func (s *Server) appendBackupPins(name string, pins []string, claims map[string]interface{}) ([]string, map[string]interface{}) {
+	var backup []string
+	for _, pin := range s.config.BackupPins[strings.ToLower(name)] {
+		if !slices.Contains(pins, pin) && !slices.Contains(backup, pin) {
+			backup = append(backup, pin)
+		}
+	}
+	if len(backup) == 0 {
+		return pins, claims
+	}
+
+	if claims == nil {
+		claims = make(map[string]interface{})
+	}
+	claims["backup_pins"] = backup
+	return append(pins, backup...), claims
+}
+
+// checkPinDrift compares the leaf's SPKI hash with the EXPECTED_PINS of domain,
+// warning on a mismatch and rejecting it with FAIL_ON_PIN_DRIFT
+func (s *Server) checkPinDrift(domain string, leaf *x509.Certificate) *pinError {
+	expected, ok := s.config.ExpectedPins[strings.ToLower(domain)]
+	if !ok {
+		return nil
+	}
+	live := crypto.GenerateSPKIHash(leaf)
+	if slices.Contains(expected, live) {
+		return nil
+	}
+
+	logger.Warn("Pin drift detected: leaf SPKI hash not in EXPECTED_PINS",
+		"domain", domain,
+		"expected_pins", expected,
+		"live_pin", live,
+		"subject", leaf.Subject.CommonName,
+		"not_before", leaf.NotBefore,
+		"fail_on_pin_drift", s.config.FailOnPinDrift)
+	if !s.config.FailOnPinDrift {
+		return nil
+	}
+	return &pinError{status: http.StatusUnprocessableEntity,
+		message: "Certificate key does not match the expected pin", reason: "pin_drift"}
+}
+
+// domainNotAllowed is the error for a domain outside the whitelist
+func (s *Server) domainNotAllowed() *pinError {
+	// Do not confirm which domains are configured
+	if s.config.HideWhitelist {
+		return &pinError{status: http.StatusNotFound, message: "Not found", reason: "domain_not_allowed"}
+	}
+	return &pinError{status: http.StatusForbidden, message: "Domain not found in whitelist", reason: "domain_not_allowed"}
+}
+
+// certFailureStatus returns the HTTP status for a certificate retrieval failure
+// with the given upstream reason: CERT_FAILURE_STATUS (422 when unset), or with
+// CLASSIFY_CERT_ERRORS 503 for transient upstream failures and 422 for
+// certificate problems (unclassified failures keep CERT_FAILURE_STATUS)
+func (s *Server) certFailureStatus(upstreamReason string) int {
+	status := s.config.CertFailureStatus
+	if status == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	if !s.config.ClassifyCertErrors {
+		return status
+	}
+	switch upstreamReason {
+	case cert.DialReasonTimeout, cert.DialReasonDNS, cert.DialReasonConnectionRefused, cert.DialReasonConnectionReset:
+		return http.StatusServiceUnavailable
+	case cert.DialReasonTLSAlert, cert.DialReasonNotTLS, cert.DialReasonUntrustedCertificate,
+		cert.DialReasonHostnameMismatch, cert.DialReasonInvalidCertificate:
+		return http.StatusUnprocessableEntity
+	default:
+		return status
+	}
+}
+
+// matchLeafSAN returns the whitelist rule matching a DNS SAN of leaf, provided
+// leaf is valid for domain (WHITELIST_BY_SAN)
+func (s *Server) matchLeafSAN(domain string, leaf *x509.Certificate) (string, bool) {
+	if err := leaf.VerifyHostname(domain); err != nil {
+		return "", false
+	}
+	for _, san := range leaf.DNSNames {
+		if rule, ok := s.validator.Match(san); ok {
+			return rule, true
+		}
+	}
+	return "", false
+}
+
+// uniquePins drops repeated pins, keeping the first occurrence of each
+func uniquePins(pins []string) []string {
+	seen := make(map[string]bool, len(pins))
+	unique := pins[:0]
+	for _, pin := range pins {
+		if !seen[pin] {
+			seen[pin] = true
+			unique = append(unique, pin)
+		}
+	}
+	return unique
+}
+
+// hasMixedScript reports whether name looks like a homograph (see domain.HasMixedScript)
+func hasMixedScript(name string) bool {
+	return domain.HasMixedScript(name)
+}
+
+// domainLogAttrs returns the log attributes naming the requested domain: the raw
+// value, and with LOG_NORMALIZED_DOMAIN also its normalized punycode form
+func (s *Server) domainLogAttrs(name string) []any {
+	if !s.config.LogNormalizedDomain {
+		return []any{"domain", name}
+	}
+	return []any{"domain", name, "normalized_domain", domain.Normalize(name)}
+}
+
+// isValidHostname reports whether name is a well-formed hostname (see domain.IsValidHostname)
+func isValidHostname(name string) bool {
+	return domain.IsValidHostname(name)
+}
+
+// signPins creates the signed compact JWS token for the given pins
+func (s *Server) signPins(domain string, pins []string, lifetime time.Duration, extraClaims map[string]interface{}) (*pinResult, *pinError) {
+	claims := s.responseClaims(extraClaims, lifetime)
+
+	jwsToken, pinErr := retrySign(s, domain, "JWS", func(key stdcrypto.Signer, keyID string) (string, error) {
+		return s.createJWS(key, keyID, s.config.JWSType, domain, pins, lifetime, claims)
+	})
+	if pinErr != nil {
+		return nil, pinErr
+	}
+	if pinErr := s.verifySigned(domain, []byte(jwsToken)); pinErr != nil {
+		return nil, pinErr
+	}
+	s.tokensIssued.Add(1)
+
+	return &pinResult{jws: jwsToken, pins: pins}, nil
+}
+
+// signPinsJSON creates the signed flattened JSON JWS for the given pins
+func (s *Server) signPinsJSON(domain string, pins []string, lifetime time.Duration, extraClaims map[string]interface{}) (*pinResult, *pinError) {
+	claims := s.responseClaims(extraClaims, lifetime)
+
+	jwsJSON, pinErr := retrySign(s, domain, "JWS", func(key stdcrypto.Signer, keyID string) ([]byte, error) {
+		return s.createJWSJSON(key, keyID, s.config.JWSType, domain, pins, lifetime, claims)
+	})
+	if pinErr != nil {
+		return nil, pinErr
+	}
+	if pinErr := s.verifySigned(domain, jwsJSON); pinErr != nil {
+		return nil, pinErr
+	}
+	s.tokensIssued.Add(1)
+
+	return &pinResult{jwsJSON: jwsJSON, pins: pins}, nil
+}
+
+// signPinsDetached creates the signed compact JWS for the given pins with a
+// detached payload, returned alongside it in the result
+func (s *Server) signPinsDetached(domain string, pins []string, lifetime time.Duration, extraClaims map[string]interface{}) (*pinResult, *pinError) {
+	claims := s.responseClaims(extraClaims, lifetime)
+
+	var payload []byte
+	jwsToken, pinErr := retrySign(s, domain, "JWS", func(key stdcrypto.Signer, keyID string) (string, error) {
+		token, detached, err := s.createDetachedJWS(key, keyID, s.config.JWSType, domain, pins, lifetime, claims)
+		payload = detached
+		return token, err
+	})
+	if pinErr != nil {
+		return nil, pinErr
+	}
+	if s.config.VerifyAfterSign {
+		if err := crypto.VerifyDetachedJWS(s.config.PublicKey, []byte(jwsToken), payload); err != nil {
+			logger.Error("Issued token failed verification", "domain", domain, "error", err)
+			return nil, &pinError{status: http.StatusInternalServerError, message: "Failed to generate signed token", reason: "jws_verification_failed"}
+		}
+	}
+	s.tokensIssued.Add(1)
+
+	return &pinResult{jws: jwsToken, payload: payload, pins: pins}, nil
+}
+
+// verifySigned checks a freshly minted token against the public key when
+// VERIFY_AFTER_SIGN is set, catching signing library mismatches at runtime
+func (s *Server) verifySigned(domain string, signed []byte) *pinError {
+	if !s.config.VerifyAfterSign {
+		return nil
+	}
+	if err := crypto.VerifyJWS(s.config.PublicKey, signed); err != nil {
+		logger.Error("Issued token failed verification", "domain", domain, "error", err)
+		return &pinError{status: http.StatusInternalServerError, message: "Failed to generate signed token", reason: "jws_verification_failed"}
+	}
+	return nil
+}
+
+// retrySign signs with the server key through sign, retrying once before
+// answering 500 with a <format>_creation_failed reason. The key is loaded once
+// at startup and never replaced, so the retry reuses it: it only rides out
+// transient signer failures, such as a failed entropy read or a remote
+// crypto.Signer timing out.
+func retrySign[T any](s *Server, domain, format string, sign func(key stdcrypto.Signer, keyID string) (T, error)) (T, *pinError) {
+	signed, err := sign(s.config.PrivateKey, s.keyID)
+	if err != nil {
+		logger.Warn("Failed to create "+format+" token, retrying", "domain", domain, "error", err)
+		signed, err = sign(s.config.PrivateKey, s.keyID)
+	}
+	if err != nil {
+		logger.Error("Failed to create "+format+" token", "domain", domain, "error", err)
+		return signed, &pinError{status: http.StatusInternalServerError, message: "Failed to generate signed token", reason: strings.ToLower(format) + "_creation_failed"}
+	}
+	return signed, nil
+}
+
+// responseClaims adds the server-wide optional claims to extraClaims for a
+// token valid for lifetime
+func (s *Server) responseClaims(extraClaims map[string]interface{}, lifetime time.Duration) map[string]interface{} {
+	claims := make(map[string]interface{})
+
+	// Optionally expose the server clock so clients can diagnose clock skew
+	if s.config.ServerTimeClaim {
+		claims["server_time"] = time.Now().Unix()
+	}
+	// Tag tokens with the deployment so they cannot be confused across environments
+	if s.config.Environment != "" {
+		claims["env"] = s.config.Environment
+	}
+	// Advise clients how much clock skew to allow when checking exp and nbf
+	if s.config.ClientSkewTolerance > 0 {
+		claims["skew_tolerance_seconds"] = int64(s.config.ClientSkewTolerance / time.Second)
+	}
+	// Debugging copies of iat and exp, to the second like the numeric claims
+	if s.config.IncludeHumanTimes {
+		now := time.Now().UTC()
+		claims["iat_str"] = now.Format(time.RFC3339)
+		claims["exp_str"] = now.Add(lifetime).Format(time.RFC3339)
+	}
+
+	if len(claims) == 0 {
+		return extraClaims
+	}
+	for k, v := range extraClaims {
+		claims[k] = v
+	}
+	return claims
+}
+
+// connectionInfoAttr returns a "tls" log group describing the upstream connection
+// The group is empty, and omitted from logs, when the connection is unknown
+func connectionInfoAttr(info *cert.ConnectionInfo) slog.Attr {
+	if info == nil {
+		return slog.Group("tls")
+	}
+	return slog.Group("tls", "version", info.VersionName(), "cipher_suite", info.CipherSuiteName())
+}
+
+// limitSANs returns at most max SANs for logging, followed by a "+N more"
+// marker when some were left out, so multi-SAN certificates cannot flood logs
+func limitSANs(sans []string, max int) []string {
+	if max <= 0 || len(sans) <= max {
+		return sans
+	}
+	limited := make([]string, max, max+1)
+	copy(limited, sans)
+	return append(limited, fmt.Sprintf("+%d more", len(sans)-max))
+}
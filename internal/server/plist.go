@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+// formatATSPlist is the format parameter value returning pins as an App Transport Security plist
+const formatATSPlist = "ats-plist"
+
+// plistHeader opens an XML property list document
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// atsPlist renders pins as an Info.plist NSAppTransportSecurity dictionary
+// pinning domain. The first pin is the leaf's and goes in NSPinnedLeafIdentities,
+// the rest (intermediates and roots) in NSPinnedCAIdentities.
+func atsPlist(domain string, pins []string) []byte {
+	var b bytes.Buffer
+	b.WriteString(plistHeader)
+	b.WriteString("<dict>\n")
+	b.WriteString("\t<key>NSAppTransportSecurity</key>\n")
+	b.WriteString("\t<dict>\n")
+	b.WriteString("\t\t<key>NSPinnedDomains</key>\n")
+	b.WriteString("\t\t<dict>\n")
+	b.WriteString("\t\t\t<key>")
+	xml.EscapeText(&b, []byte(domain))
+	b.WriteString("</key>\n")
+	b.WriteString("\t\t\t<dict>\n")
+	if len(pins) > 0 {
+		writePlistIdentities(&b, "NSPinnedLeafIdentities", pins[:1])
+	}
+	if len(pins) > 1 {
+		writePlistIdentities(&b, "NSPinnedCAIdentities", pins[1:])
+	}
+	b.WriteString("\t\t\t</dict>\n")
+	b.WriteString("\t\t</dict>\n")
+	b.WriteString("\t</dict>\n")
+	b.WriteString("</dict>\n")
+	b.WriteString("</plist>\n")
+	return b.Bytes()
+}
+
+// writePlistIdentities writes an ATS identity array of SPKI-SHA256-BASE64 entries
+func writePlistIdentities(b *bytes.Buffer, key string, pins []string) {
+	b.WriteString("\t\t\t\t<key>" + key + "</key>\n")
+	b.WriteString("\t\t\t\t<array>\n")
+	for _, pin := range pins {
+		b.WriteString("\t\t\t\t\t<dict>\n")
+		b.WriteString("\t\t\t\t\t\t<key>SPKI-SHA256-BASE64</key>\n")
+		b.WriteString("\t\t\t\t\t\t<string>")
+		xml.EscapeText(b, []byte(pin))
+		b.WriteString("</string>\n")
+		b.WriteString("\t\t\t\t\t</dict>\n")
+	}
+	b.WriteString("\t\t\t\t</array>\n")
+}
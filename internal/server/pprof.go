@@ -0,0 +1,30 @@
+//go:build !nopprof
+
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofCompiled reports that the profiling endpoints are built in (omitted with
+// the nopprof build tag)
+const pprofCompiled = true
+
+// NewPprofHandler returns a handler serving the runtime profiling endpoints
+// under /debug/pprof/, for use on a separate admin listener
+func NewPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	registerPprof(mux)
+	return mux
+}
+
+// registerPprof registers the net/http/pprof handlers on mux
+// The handlers are registered explicitly so nothing leaks via http.DefaultServeMux
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,17 @@
+//go:build nopprof
+
+package server
+
+import "net/http"
+
+// pprofCompiled reports that the profiling endpoints were left out with the
+// nopprof build tag; ENABLE_PPROF then has no effect
+const pprofCompiled = false
+
+// NewPprofHandler returns a handler answering 404, as profiling is not built in
+func NewPprofHandler() http.Handler {
+	return http.NotFoundHandler()
+}
+
+// registerPprof registers nothing, as profiling is not built in
+func registerPprof(mux *http.ServeMux) {}
@@ -0,0 +1,53 @@
+//go:build !nopprof
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPprof(t *testing.T) {
+	tests := []struct {
+		name           string
+		enable         bool
+		addr           string
+		expectedStatus int
+	}{
+		{"disabled", false, "", http.StatusNotFound},
+		{"enabled_on_main_listener", true, "", http.StatusOK},
+		{"enabled_on_admin_listener", true, "127.0.0.1:6060", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := createTestServer(t)
+			server.config.EnablePprof = tt.enable
+			server.config.PprofAddr = tt.addr
+			server = NewWithRetriever(server.config, server.retriever)
+
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				w := httptest.NewRecorder()
+
+				server.ServeHTTP(w, req)
+
+				if w.Code != tt.expectedStatus {
+					t.Errorf("%s: expected status %d, got %d", path, tt.expectedStatus, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestNewPprofHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	w := httptest.NewRecorder()
+
+	NewPprofHandler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
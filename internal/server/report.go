@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"pinning-server/internal/logger"
+	"pinning-server/internal/models"
+)
+
+// maxReportBodyBytes caps the size of a pin failure report body
+const maxReportBodyBytes = 16 << 10
+
+// maxReportPins caps the pins in each list of a report
+const maxReportPins = 20
+
+// maxReportClientInfo caps the client_info entries of a report
+const maxReportClientInfo = 16
+
+// reportWindow is the window over which REPORT_RATE_LIMIT is counted
+const reportWindow = time.Minute
+
+// forwardedReport is the JSON body POSTed to REPORT_SINK_URL
+type forwardedReport struct {
+	models.PinReport
+	RemoteAddr string `json:"remote_addr"`
+	ReceivedAt int64  `json:"received_at"`
+}
+
+// reportLimiter caps the reports each client may send per reportWindow
+type reportLimiter struct {
+	limit int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*reportCount
+}
+
+// reportCount holds the reports one client sent in its current window
+type reportCount struct {
+	windowStart time.Time
+	count       int
+}
+
+// newReportLimiter creates a limiter, or returns nil when limit is 0
+func newReportLimiter(limit int) *reportLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &reportLimiter{
+		limit:   limit,
+		now:     time.Now,
+		clients: make(map[string]*reportCount),
+	}
+}
+
+// allow counts a report from client and reports whether it is within the
+// limit; when rejected, retryAfter is the time left in the client's window
+func (l *reportLimiter) allow(client string) (ok bool, retryAfter time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, found := l.clients[client]
+	if !found || now.Sub(entry.windowStart) >= reportWindow {
+		if !found && len(l.clients) >= maxTrackedClients {
+			l.evict(now)
+		}
+		entry = &reportCount{windowStart: now}
+		l.clients[client] = entry
+	}
+
+	if entry.count >= l.limit {
+		return false, entry.windowStart.Add(reportWindow).Sub(now)
+	}
+	entry.count++
+	return true, 0
+}
+
+// evict drops clients whose window has ended, or an arbitrary client if none has
+// Callers must hold l.mu
+func (l *reportLimiter) evict(now time.Time) {
+	for client, entry := range l.clients {
+		if now.Sub(entry.windowStart) >= reportWindow {
+			delete(l.clients, client)
+		}
+	}
+	for client := range l.clients {
+		if len(l.clients) < maxTrackedClients {
+			break
+		}
+		delete(l.clients, client)
+	}
+}
+
+// reportSink forwards accepted reports to REPORT_SINK_URL
+type reportSink struct {
+	url    string
+	client *http.Client
+
+	// wg tracks deliveries in flight (waited on by tests)
+	wg sync.WaitGroup
+}
+
+// newReportSink creates a sink, or returns nil when no URL is configured
+func newReportSink(url string) *reportSink {
+	if url == "" {
+		return nil
+	}
+	return &reportSink{url: url, client: &http.Client{Timeout: alertTimeout}}
+}
+
+// forward POSTs the report to the sink in the background
+func (s *reportSink) forward(report forwardedReport) {
+	if s == nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.send(report)
+	}()
+}
+
+// send POSTs the report to the sink, logging delivery failures
+func (s *reportSink) send(report forwardedReport) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		logger.Error("Failed to encode pin report", "domain", report.Domain, "error", err)
+		return
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Error("Failed to forward pin report", "domain", report.Domain, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Error("Report sink rejected pin report", "domain", report.Domain, "status", resp.StatusCode)
+	}
+}
+
+// handlePinReport handles POST /v1/report, where clients report a failed pin
+// validation (a possible MITM). Accepted reports are logged and forwarded to
+// REPORT_SINK_URL when configured.
+func (s *Server) handlePinReport(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	headers := s.requestHeaderAttr(r)
+
+	if ok, retryAfter := s.reports.allow(clientKey(r.RemoteAddr)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		s.writeError(w, r, "Too many reports, retry later", http.StatusTooManyRequests)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", http.StatusTooManyRequests,
+			"error", "report_rate_limited",
+			"remote_addr", r.RemoteAddr,
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	var report models.PinReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReportBodyBytes)).Decode(&report); err != nil {
+		status := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		s.writeError(w, r, "Invalid report body", status)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"error", "invalid_report_body",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	if msg := s.validatePinReport(&report); msg != "" {
+		s.writeError(w, r, msg, http.StatusBadRequest)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"domain", report.Domain,
+			"status", http.StatusBadRequest,
+			"error", "invalid_report",
+			"duration_ms", time.Since(start).Milliseconds(),
+			headers)
+		return
+	}
+
+	logger.Warn("Pin validation failure reported",
+		"domain", report.Domain,
+		"expected_pins", report.ExpectedPins,
+		"served_pins", report.ServedPins,
+		"client_info", report.ClientInfo,
+		"remote_addr", r.RemoteAddr)
+
+	s.reportSink.forward(forwardedReport{
+		PinReport:  report,
+		RemoteAddr: r.RemoteAddr,
+		ReceivedAt: time.Now().Unix(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+
+	logger.Info("Request completed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"domain", report.Domain,
+		"status", http.StatusNoContent,
+		"duration_ms", time.Since(start).Milliseconds(),
+		headers)
+}
+
+// validatePinReport checks a report against the whitelist and size limits,
+// returning the client-facing error message or "" when it is valid
+func (s *Server) validatePinReport(report *models.PinReport) string {
+	if report.Domain == "" {
+		return "Missing required field: domain"
+	}
+	if !s.validator.IsAllowed(report.Domain) {
+		if s.config.HideWhitelist {
+			return "Invalid domain"
+		}
+		return "Domain not in whitelist"
+	}
+	if len(report.ExpectedPins) == 0 || len(report.ExpectedPins) > maxReportPins {
+		return fmt.Sprintf("expected_pins must contain between 1 and %d pins", maxReportPins)
+	}
+	if len(report.ServedPins) > maxReportPins {
+		return fmt.Sprintf("served_pins must contain at most %d pins", maxReportPins)
+	}
+	for _, pin := range append(append([]string{}, report.ExpectedPins...), report.ServedPins...) {
+		if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+			return "Invalid pin (expected base64 encoded SHA-256 hash)"
+		}
+	}
+	if len(report.ClientInfo) > maxReportClientInfo {
+		return fmt.Sprintf("client_info must contain at most %d entries", maxReportClientInfo)
+	}
+	return ""
+}
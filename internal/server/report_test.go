@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testExpectedPin = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	testServedPin   = "d6qzRu9zOECb90Uez27xWltNsj0e1Md7GkYYkVoZWmM="
+)
+
+// reportRecorder is a sink endpoint recording the reports it receives
+type reportRecorder struct {
+	mu      sync.Mutex
+	reports []forwardedReport
+}
+
+func (s *reportRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var report forwardedReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err == nil {
+		s.mu.Lock()
+		s.reports = append(s.reports, report)
+		s.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *reportRecorder) received() []forwardedReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]forwardedReport(nil), s.reports...)
+}
+
+func postReport(server *Server, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/report", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandlePinReport_Accepted(t *testing.T) {
+	recorder := &reportRecorder{}
+	sink := httptest.NewServer(recorder)
+	defer sink.Close()
+
+	base, retriever := createTestServer(t)
+	cfg := *base.config
+	cfg.ReportSinkURL = sink.URL
+	server := NewWithRetriever(&cfg, retriever)
+
+	body := `{"domain":"example.com","expected_pins":["` + testExpectedPin + `"],` +
+		`"served_pins":["` + testServedPin + `"],"client_info":{"platform":"ios","app_version":"2.4.1"}}`
+	w := postReport(server, body)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	server.reportSink.wg.Wait()
+	got := recorder.received()
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 forwarded report, got %d", len(got))
+	}
+	report := got[0]
+	if report.Domain != "example.com" || len(report.ExpectedPins) != 1 || report.ServedPins[0] != testServedPin {
+		t.Errorf("Unexpected forwarded report: %+v", report)
+	}
+	if report.ClientInfo["platform"] != "ios" {
+		t.Errorf("Expected client_info to be forwarded, got %v", report.ClientInfo)
+	}
+	if report.RemoteAddr == "" || report.ReceivedAt == 0 {
+		t.Errorf("Expected remote_addr and received_at to be set, got %+v", report)
+	}
+}
+
+func TestHandlePinReport_Malformed(t *testing.T) {
+	server, _ := createTestServer(t)
+
+	tooManyPins := make([]string, maxReportPins+1)
+	for i := range tooManyPins {
+		tooManyPins[i] = testExpectedPin
+	}
+	tooManyJSON, _ := json.Marshal(map[string]interface{}{"domain": "example.com", "expected_pins": tooManyPins})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"invalid_json", `{"domain":`, http.StatusBadRequest},
+		{"missing_domain", `{"expected_pins":["` + testExpectedPin + `"]}`, http.StatusBadRequest},
+		{"not_whitelisted", `{"domain":"evil.com","expected_pins":["` + testExpectedPin + `"]}`, http.StatusBadRequest},
+		{"no_expected_pins", `{"domain":"example.com","served_pins":["` + testServedPin + `"]}`, http.StatusBadRequest},
+		{"invalid_pin", `{"domain":"example.com","expected_pins":["not-a-pin"]}`, http.StatusBadRequest},
+		{"short_pin", `{"domain":"example.com","expected_pins":["` + testExpectedPin + `"],"served_pins":["AAAA"]}`, http.StatusBadRequest},
+		{"too_many_pins", string(tooManyJSON), http.StatusBadRequest},
+		{"too_large", `{"domain":"example.com","client_info":{"x":"` + strings.Repeat("a", maxReportBodyBytes) + `"}}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postReport(server, tt.body)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlePinReport_MethodNotAllowed(t *testing.T) {
+	server, _ := createTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/report", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
+
+func TestHandlePinReport_RateLimited(t *testing.T) {
+	base, retriever := createTestServer(t)
+	cfg := *base.config
+	cfg.ReportRateLimit = 2
+	server := NewWithRetriever(&cfg, retriever)
+
+	now := time.Now()
+	server.reports.now = func() time.Time { return now }
+
+	body := `{"domain":"example.com","expected_pins":["` + testExpectedPin + `"]}`
+	for i := range 2 {
+		if w := postReport(server, body); w.Code != http.StatusNoContent {
+			t.Fatalf("Report %d: expected status 204, got %d", i+1, w.Code)
+		}
+	}
+
+	// The limit applies before the body is read
+	w := postReport(server, `{}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Expected Retry-After 60, got %q", got)
+	}
+
+	// A new window accepts reports again
+	now = now.Add(reportWindow)
+	if w := postReport(server, body); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 in a new window, got %d", w.Code)
+	}
+}
+
+func TestHandlePinReport_NoSink(t *testing.T) {
+	server, _ := createTestServer(t)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"domain":        "example.com",
+		"expected_pins": []string{testExpectedPin},
+	})
+	w := postReport(server, string(body))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 without a sink, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -1,12 +1,23 @@
 package server
 
 import (
+	"context"
+	stdcrypto "crypto"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"net"
 	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"pinning-server/internal/cert"
 	"pinning-server/internal/config"
 	"pinning-server/internal/crypto"
 	"pinning-server/internal/domain"
+	"pinning-server/internal/logger"
 )
 
 // Server represents the HTTP server
@@ -16,33 +27,241 @@ type Server struct {
 	retriever cert.CertRetriever
 	keyID     string
 	mux       *http.ServeMux
+
+	// tokensIssued counts successfully signed pin tokens since start
+	tokensIssued atomic.Uint64
+
+	// requestsInFlight counts requests being served, and vars holds the metrics
+	// served under /debug/vars (nil unless ENABLE_EXPVAR is set)
+	requestsInFlight atomic.Int64
+	vars             *expvar.Map
+
+	// inflight bounds concurrent requests (nil when MAX_INFLIGHT_REQUESTS is 0)
+	inflight chan struct{}
+
+	// distinct caps the distinct domains each client may query (nil when unlimited)
+	distinct *distinctDomainLimiter
+
+	// alerts notifies ALERT_WEBHOOK_URL of repeatedly failing fetches (nil when unset)
+	alerts *failureAlerter
+
+	// reports limits pin failure reports per client (nil when unlimited) and
+	// reportSink forwards them to REPORT_SINK_URL (nil when unset)
+	reports    *reportLimiter
+	reportSink *reportSink
+
+	// resolver looks up whitelist entries for VALIDATE_DOMAINS_ON_START
+	// (net.DefaultResolver, replaceable in tests)
+	resolver domainResolver
+
+	// blockedIssuers rejects chains issued under distrusted CAs (nil when BLOCKED_ISSUERS is unset)
+	blockedIssuers *cert.IssuerBlocklist
+
+	// rootCAs overrides the roots used by include-all-roots (nil uses system roots)
+	rootCAs *x509.CertPool
+
+	// createJWS, createJWSJSON, createDetachedJWS and createCOSE sign tokens
+	// (crypto.CreateJWSWithClaims, crypto.CreateJWSJSONWithClaims,
+	// crypto.CreateDetachedJWS and crypto.CreateCOSEWithClaims, replaceable in tests)
+	createJWS         func(key stdcrypto.Signer, keyID, typ, domain string, pins []string, ttl time.Duration, claims map[string]interface{}) (string, error)
+	createJWSJSON     func(key stdcrypto.Signer, keyID, typ, domain string, pins []string, ttl time.Duration, claims map[string]interface{}) ([]byte, error)
+	createDetachedJWS func(key stdcrypto.Signer, keyID, typ, domain string, pins []string, ttl time.Duration, claims map[string]interface{}) (string, []byte, error)
+	createCOSE        func(key stdcrypto.Signer, keyID, domain string, pins []string, ttl time.Duration, claims map[string]interface{}) ([]byte, error)
 }
 
 // New creates a new HTTP server
 func New(cfg *config.Config) *Server {
-	return NewWithRetriever(cfg, cert.NewRetriever(cfg.CertDialTimeout, cfg.CertCacheTTL))
+	// Serve pins from local PEM files without dialing (air-gapped mode)
+	if cfg.CertSource == config.CertSourceDisk {
+		return NewWithRetriever(cfg, cert.NewDiskRetriever(cfg.CertDir))
+	}
+
+	retriever := cert.NewRetriever(cfg.CertDialTimeout, cfg.CertCacheTTL)
+	retriever.SetDomainCacheTTLs(cfg.CertCacheTTLOverrides)
+	if cfg.CertSANCache {
+		retriever.EnableSANCache(cfg.AllowedDomains)
+	}
+	if cfg.CertCacheCompress {
+		retriever.SetCache(cert.NewDERMemoryCache())
+	}
+	retriever.SetFallbackPorts(cfg.CertFallbackPorts)
+	retriever.SetSessionResumption(!cfg.CertDisableResumption)
+	retriever.SetFastLeaf(cfg.CertFastLeaf)
+	retriever.SetTimeouts(cert.Timeouts{
+		Resolve:   cfg.CertResolveTimeout,
+		Connect:   cfg.CertConnectTimeout,
+		Handshake: cfg.CertHandshakeTimeout,
+	})
+
+	// Start warm from the cache saved at the last shutdown
+	if cfg.CertCacheFile != "" {
+		loaded, err := retriever.LoadCacheFile(cfg.CertCacheFile)
+		if err != nil {
+			logger.Warn("Failed to load certificate cache", "path", cfg.CertCacheFile, "error", err)
+		} else {
+			logger.Info("Loaded certificate cache", "path", cfg.CertCacheFile, "entries", loaded)
+		}
+	}
+	return NewWithRetriever(cfg, retriever)
 }
 
 // NewWithRetriever creates a new HTTP server with a custom certificate retriever
 // This is useful for testing with fake retrievers
+// It panics if cfg fails validation, e.g. when PublicKey does not match PrivateKey
 func NewWithRetriever(cfg *config.Config, retriever cert.CertRetriever) *Server {
+	if err := cfg.Validate(); err != nil {
+		panic(fmt.Sprintf("server: invalid config: %v", err))
+	}
+
 	s := &Server{
 		config:    cfg,
 		validator: domain.NewValidatorWithOptions(cfg.AllowedDomains, cfg.AllowIPLiterals),
 		retriever: retriever,
 		keyID:     crypto.GenerateKeyID(cfg.PublicKey),
 		mux:       http.NewServeMux(),
+		resolver:  net.DefaultResolver,
+
+		createJWS:         crypto.CreateJWSWithClaims,
+		createJWSJSON:     crypto.CreateJWSJSONWithClaims,
+		createDetachedJWS: crypto.CreateDetachedJWS,
+		createCOSE:        crypto.CreateCOSEWithClaims,
 	}
 
-	// Register routes
-	s.mux.HandleFunc("/v1/pins", s.handleGetPins)
-	s.mux.HandleFunc("/health", s.handleHealth)
-	s.mux.HandleFunc("/readiness", s.handleReadiness)
+	s.distinct = newDistinctDomainLimiter(cfg.MaxDistinctDomainsPerClient, cfg.DistinctDomainsWindow)
+	s.alerts = newFailureAlerter(cfg.AlertWebhookURL, cfg.AlertFailureThreshold, cfg.AllowedDomains, cfg.WarmupDomains)
+	s.reports = newReportLimiter(cfg.ReportRateLimit)
+	s.reportSink = newReportSink(cfg.ReportSinkURL)
+	s.blockedIssuers = cert.NewIssuerBlocklist(cfg.BlockedIssuers)
+
+	if cfg.MaxInflightRequests > 0 {
+		s.inflight = make(chan struct{}, cfg.MaxInflightRequests)
+	}
+
+	// Register routes with the methods each accepts
+	s.handle("/v1/pins", s.handleGetPins, http.MethodGet)
+	s.handle("/v1/pins/check", s.handlePinCheck, http.MethodGet)
+	s.handle("/v1/pins/batch", s.handleBatchPins, http.MethodPost)
+	s.handle("/v1/bundle", s.handleBundle, http.MethodGet)
+	s.handle("/v1/report", s.handlePinReport, http.MethodPost)
+	s.handle("/health", s.handleHealth, http.MethodGet)
+	s.handle("/readiness", s.handleReadiness, http.MethodGet)
+	s.handle("/openapi.json", s.handleOpenAPI, http.MethodGet)
+	s.handle("/v1/features", s.handleFeatures, http.MethodGet)
+
+	// The config dump is only served when explicitly enabled with an admin token
+	if cfg.EnableAdminConfig && cfg.AdminToken != "" {
+		s.handle("/v1/admin/config", s.handleConfig, http.MethodGet)
+	}
+
+	// Profiling shares the main listener only when no admin address is configured
+	if cfg.EnablePprof && cfg.PprofAddr == "" {
+		registerPprof(s.mux)
+	}
+
+	if cfg.EnableExpvar {
+		s.vars = s.newExpvarMap()
+		s.handle("/debug/vars", s.handleExpvar, http.MethodGet)
+	}
 
 	return s
 }
 
+// NewHTTPServer creates the http.Server serving s on the configured port
+// with the configured timeouts and header size limit
+func (s *Server) NewHTTPServer() *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", s.config.Port),
+		Handler:           s,
+		ReadTimeout:       s.config.ReadTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		MaxHeaderBytes:    s.config.MaxHeaderBytes,
+	}
+}
+
+// fetchShutdowner is implemented by retrievers whose in-flight upstream fetches
+// can be drained on shutdown
+type fetchShutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Shutdown drains the retriever's in-flight certificate fetches, aborting those
+// still running once ctx is done so their requests fail fast with 503 instead
+// of being killed mid-way. Fetches started afterwards are refused. Run it
+// alongside the HTTP server's Shutdown and before SaveCertCache, so the saved
+// cache reflects only completed fetches.
+func (s *Server) Shutdown(ctx context.Context) error {
+	shutdowner, ok := s.retriever.(fetchShutdowner)
+	if !ok {
+		return nil
+	}
+	return shutdowner.Shutdown(ctx)
+}
+
 // ServeHTTP implements http.Handler
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.config.SecurityHeaders {
+		setSecurityHeaders(w, r)
+	}
+	if s.config.Environment != "" {
+		w.Header().Set("X-Environment", s.config.Environment)
+	}
+
+	// Shed load once saturated; probes bypass the limit so the instance is not
+	// restarted just for being busy
+	if r.URL.Path != "/health" && r.URL.Path != "/readiness" {
+		release, ok := s.acquireInflight()
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			s.writeError(w, r, "Server is busy, retry later", http.StatusServiceUnavailable)
+			logger.Warn("Request rejected",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", http.StatusServiceUnavailable,
+				"error", "too_many_inflight_requests")
+			return
+		}
+		defer release()
+	}
+
+	s.requestsInFlight.Add(1)
+	defer s.requestsInFlight.Add(-1)
+
 	s.mux.ServeHTTP(w, r)
 }
+
+// acquireInflight takes one of the MAX_INFLIGHT_REQUESTS slots shared by HTTP
+// and gRPC requests, reporting false when all are taken. The returned release
+// must be called once the request is done.
+func (s *Server) acquireInflight() (release func(), ok bool) {
+	if s.inflight == nil {
+		return func() {}, true
+	}
+	select {
+	case s.inflight <- struct{}{}:
+		return func() { <-s.inflight }, true
+	default:
+		return nil, false
+	}
+}
+
+// handle registers handler for pattern, answering other methods than the
+// allowed ones with a JSON 405 listing them in the Allow header
+func (s *Server) handle(pattern string, handler http.HandlerFunc, methods ...string) {
+	allow := strings.Join(methods, ", ")
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(methods, r.Method) {
+			handler(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", allow)
+		s.writeError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		logger.Info("Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", http.StatusMethodNotAllowed,
+			s.requestHeaderAttr(r))
+	})
+}
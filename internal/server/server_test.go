@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pinning-server/internal/cert"
+	"pinning-server/internal/models"
+)
+
+// TestNewHTTPServer_OversizedURI tests that the full server rejects request URIs
+// exceeding MAX_HEADER_BYTES with 431 instead of dropping the connection
+func TestNewHTTPServer_OversizedURI(t *testing.T) {
+	server, _ := createTestServer(t)
+	server.config.MaxHeaderBytes = 1024
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	httpServer := server.NewHTTPServer()
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+
+	baseURL := "http://" + listener.Addr().String()
+
+	// net/http allows 4096 bytes of slack on top of MaxHeaderBytes
+	resp, err := http.Get(baseURL + "/v1/pins?domain=" + strings.Repeat("a", 16*1024))
+	if err != nil {
+		t.Fatalf("Expected an HTTP response for an oversized URI, got error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+	}
+
+	// Requests within the limit are still served
+	resp, err = http.Get(baseURL + "/health")
+	if err != nil {
+		t.Fatalf("Failed to request health: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+// blockingRetriever blocks every retrieval until released
+type blockingRetriever struct {
+	entered chan struct{}
+	release chan struct{}
+	certs   []*x509.Certificate
+}
+
+func (b *blockingRetriever) GetCertificates(domain string) ([]*x509.Certificate, error) {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.certs, nil
+}
+
+func (b *blockingRetriever) GetCertificatesWithOptions(domain string, opts cert.FetchOptions) ([]*x509.Certificate, error) {
+	return b.GetCertificates(domain)
+}
+
+// TestMaxInflightRequests tests that requests beyond MAX_INFLIGHT_REQUESTS get 503
+func TestMaxInflightRequests(t *testing.T) {
+	const limit = 3
+	const total = 10
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+
+	base, _ := createTestServer(t)
+	cfg := *base.config
+	cfg.MaxInflightRequests = limit
+	retriever := &blockingRetriever{
+		entered: make(chan struct{}, total),
+		release: make(chan struct{}),
+		certs:   []*x509.Certificate{testCert},
+	}
+	server := NewWithRetriever(&cfg, retriever)
+
+	codes := make(chan int, total)
+	var wg sync.WaitGroup
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil)
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+				t.Error("Expected Retry-After header on 503")
+			}
+			codes <- w.Code
+		}()
+	}
+
+	// Wait until the limit is saturated; the remaining requests are rejected
+	for i := 0; i < limit; i++ {
+		<-retriever.entered
+	}
+
+	// Probes bypass the limiter while it is saturated
+	for _, path := range []string{"/health", "/readiness"} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected %s to bypass the limiter, got status %d", path, w.Code)
+		}
+	}
+
+	for i := 0; i < total-limit; i++ {
+		if code := <-codes; code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status %d for request over the limit, got %d", http.StatusServiceUnavailable, code)
+		}
+	}
+
+	close(retriever.release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Expected admitted request to succeed, got %d", code)
+		}
+	}
+}
+
+// TestShutdown_InFlightFetch tests that Shutdown lets a slow certificate fetch
+// finish within the deadline and aborts it with 503 past the deadline
+func TestShutdown_InFlightFetch(t *testing.T) {
+	tests := []struct {
+		name           string
+		delay          time.Duration
+		timeout        time.Duration
+		expectedStatus int
+	}{
+		{"drained", 100 * time.Millisecond, 5 * time.Second, http.StatusOK},
+		{"aborted", 10 * time.Second, 50 * time.Millisecond, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, retriever := createTestServer(t)
+			testCert, err := cert.GenerateTestCertificate("example.com")
+			if err != nil {
+				t.Fatalf("Failed to generate test certificate: %v", err)
+			}
+			retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+			retriever.SetDelay(tt.delay)
+
+			done := make(chan *httptest.ResponseRecorder, 1)
+			go func() {
+				w := httptest.NewRecorder()
+				server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil))
+				done <- w
+			}()
+			// Let the request reach the slow fetch
+			time.Sleep(20 * time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			_ = server.Shutdown(ctx)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Shutdown took %v", elapsed)
+			}
+
+			select {
+			case w := <-done:
+				if w.Code != tt.expectedStatus {
+					t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("In-flight request did not complete after Shutdown")
+			}
+
+			// Requests arriving after shutdown never start a fetch
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/pins?domain=example.com", nil))
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("Expected status %d after shutdown, got %d", http.StatusServiceUnavailable, w.Code)
+			}
+		})
+	}
+}
+
+// TestMethodNotAllowed tests that every route answers a disallowed method with
+// a JSON 405 listing the allowed methods in the Allow header
+func TestMethodNotAllowed(t *testing.T) {
+	base, retriever := createTestServer(t)
+	cfg := *base.config
+	cfg.EnableAdminConfig = true
+	cfg.AdminToken = "admin-secret"
+	cfg.EnableExpvar = true
+	server := NewWithRetriever(&cfg, retriever)
+
+	tests := []struct {
+		path   string
+		method string
+		allow  string
+	}{
+		{"/v1/pins?domain=example.com", http.MethodPost, "GET"},
+		{"/v1/pins/check?domain=example.com", http.MethodDelete, "GET"},
+		{"/v1/pins/batch", http.MethodGet, "POST"},
+		{"/v1/bundle?domain=example.com", http.MethodPost, "GET"},
+		{"/v1/report", http.MethodGet, "POST"},
+		{"/health", http.MethodPost, "GET"},
+		{"/readiness", http.MethodPut, "GET"},
+		{"/openapi.json", http.MethodPost, "GET"},
+		{"/v1/features", http.MethodPost, "GET"},
+		{"/v1/admin/config", http.MethodPost, "GET"},
+		{"/debug/vars", http.MethodPost, "GET"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.allow {
+				t.Errorf("Expected Allow %q, got %q", tt.allow, allow)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected JSON content type, got %q", ct)
+			}
+			var body models.Error
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if body.Code != http.StatusMethodNotAllowed || body.Error != "Method not allowed" {
+				t.Errorf("Expected method not allowed error, got %+v", body)
+			}
+		})
+	}
+}
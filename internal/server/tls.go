@@ -0,0 +1,39 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"pinning-server/internal/logger"
+)
+
+// NewTLSConfig builds the TLS configuration used when serving HTTPS directly
+// If allowedSNI is non-empty, handshakes whose SNI is not in the list (including
+// handshakes without SNI) are rejected
+func NewTLSConfig(certificate tls.Certificate, allowedSNI []string) *tls.Config {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(allowedSNI) > 0 {
+		allowed := make(map[string]bool, len(allowedSNI))
+		for _, name := range allowedSNI {
+			allowed[strings.ToLower(name)] = true
+		}
+
+		tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if !allowed[strings.ToLower(hello.ServerName)] {
+				logger.Warn("Rejected TLS handshake with unexpected SNI",
+					"sni", hello.ServerName,
+					"remote_addr", hello.Conn.RemoteAddr().String())
+				return nil, fmt.Errorf("unexpected server name %q", hello.ServerName)
+			}
+			// Continue with the base configuration
+			return nil, nil
+		}
+	}
+
+	return tlsConfig
+}
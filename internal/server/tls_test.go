@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// generateServingCertificate creates a self-signed serving certificate for the given names
+func generateServingCertificate(t *testing.T, names ...string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     names,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: privateKey}, pool
+}
+
+func TestNewTLSConfig_AllowedSNI(t *testing.T) {
+	certificate, pool := generateServingCertificate(t, "pins.example.com", "other.example.com")
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", NewTLSConfig(certificate, []string{"pins.example.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	tests := []struct {
+		name        string
+		serverName  string
+		expectError bool
+	}{
+		{"allowed_sni", "pins.example.com", false},
+		{"allowed_sni_case_insensitive", "PINS.example.com", false},
+		{"disallowed_sni", "other.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &net.Dialer{Timeout: 5 * time.Second}
+			conn, err := tls.DialWithDialer(dialer, "tcp", listener.Addr().String(), &tls.Config{
+				ServerName: tt.serverName,
+				RootCAs:    pool,
+				MinVersion: tls.VersionTLS12,
+			})
+			if tt.expectError {
+				if err == nil {
+					conn.Close()
+					t.Error("Expected handshake to fail for disallowed SNI")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected handshake to succeed, got: %v", err)
+			}
+			conn.Close()
+		})
+	}
+}
+
+func TestNewTLSConfig_NoAllowlist(t *testing.T) {
+	certificate, _ := generateServingCertificate(t, "pins.example.com")
+
+	if NewTLSConfig(certificate, nil).GetConfigForClient != nil {
+		t.Error("Expected no SNI check without an allowlist")
+	}
+}
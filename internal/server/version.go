@@ -0,0 +1,64 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response schema versions of /v1/pins, negotiated via the Accept header
+const (
+	responseVersion1 = 1 // {"jws": ...} or {"jws_json": ...}
+	responseVersion2 = 2 // models.PinResponseV2
+)
+
+// latestResponseVersion is the newest response schema served
+const latestResponseVersion = responseVersion2
+
+// vendorMediaTypePrefix and vendorMediaTypeSuffix enclose the version in
+// vendor media types such as application/vnd.pinning.v2+json
+const (
+	vendorMediaTypePrefix = "application/vnd.pinning.v"
+	vendorMediaTypeSuffix = "+json"
+)
+
+// vendorMediaType returns the vendor media type of a response schema version
+func vendorMediaType(version int) string {
+	return vendorMediaTypePrefix + strconv.Itoa(version) + vendorMediaTypeSuffix
+}
+
+// negotiateVersion selects the response schema version from the Accept header of r.
+// The first vendor media type naming a supported version wins; any other media
+// range (application/json, */*, ...) or no Accept header selects version 1. ok is
+// false when the client accepts only vendor versions and none is supported.
+func negotiateVersion(r *http.Request) (version int, ok bool) {
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.TrimSpace(accept) == "" {
+		return responseVersion1, true
+	}
+
+	unsupported := false
+	fallback := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+
+		if strings.HasPrefix(mediaType, vendorMediaTypePrefix) && strings.HasSuffix(mediaType, vendorMediaTypeSuffix) {
+			requested, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(mediaType, vendorMediaTypePrefix), vendorMediaTypeSuffix))
+			if err == nil && requested >= responseVersion1 && requested <= latestResponseVersion {
+				return requested, true
+			}
+			unsupported = true
+			continue
+		}
+		fallback = true
+	}
+
+	if unsupported && !fallback {
+		return 0, false
+	}
+	return responseVersion1, true
+}
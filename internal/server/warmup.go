@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"pinning-server/internal/logger"
+)
+
+// cacheFileSaver is implemented by retrievers whose cache can be saved to a file
+type cacheFileSaver interface {
+	SaveCacheFile(path string) error
+}
+
+// ErrNoCertCacheFile is returned by SaveCertCache when CERT_CACHE_FILE is not set
+var ErrNoCertCacheFile = errors.New("CERT_CACHE_FILE is not set")
+
+// SaveCertCache writes the certificate cache to CERT_CACHE_FILE so the next
+// start is warm. Called on shutdown and by the warm command.
+func (s *Server) SaveCertCache() error {
+	if s.config.CertCacheFile == "" {
+		return ErrNoCertCacheFile
+	}
+	saver, ok := s.retriever.(cacheFileSaver)
+	if !ok {
+		return errors.New("certificate retriever has no cache to save")
+	}
+	if err := saver.SaveCacheFile(s.config.CertCacheFile); err != nil {
+		return err
+	}
+	logger.Info("Saved certificate cache", "path", s.config.CertCacheFile)
+	return nil
+}
+
+// Warmup retrieves the certificates of each domain so that they are cached
+// before the first real request. Failures are logged and returned joined;
+// domains after a failure are still warmed.
+func (s *Server) Warmup(domains []string) error {
+	start := time.Now()
+
+	var errs []error
+	for _, domain := range domains {
+		if err := s.WarmDomain(domain); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	logger.Info("Warmup completed",
+		"domains", len(domains),
+		"failed", len(errs),
+		"duration_ms", time.Since(start).Milliseconds())
+	return errors.Join(errs...)
+}
+
+// WarmDomain retrieves the certificates of a whitelisted domain so that they are cached
+// Failures are logged and returned prefixed with the domain
+func (s *Server) WarmDomain(domain string) error {
+	if !s.validator.IsAllowed(domain) {
+		logger.Warn("Skipping warmup of domain not in whitelist", "domain", domain)
+		return fmt.Errorf("%s: domain not in whitelist", domain)
+	}
+	if _, err := s.retriever.GetCertificates(domain); err != nil {
+		s.alerts.recordFailure(domain, err)
+		logger.Warn("Failed to warm up domain", "domain", domain, "error", err)
+		return fmt.Errorf("%s: %w", domain, err)
+	}
+	s.alerts.recordSuccess(domain)
+	return nil
+}
+
+// domainResolveTimeout bounds the DNS lookup of each whitelist entry at startup
+const domainResolveTimeout = 5 * time.Second
+
+// domainResolver resolves host names (implemented by *net.Resolver)
+type domainResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// CheckDomainsResolve looks up each exact ALLOWED_DOMAINS entry in DNS and logs
+// a warning for those that do not resolve, to surface typos early. Wildcards
+// and IP literals are skipped. It returns the entries that failed to resolve.
+func (s *Server) CheckDomainsResolve() []string {
+	var unresolved []string
+	checked := 0
+	for _, entry := range s.config.AllowedDomains {
+		domain := strings.TrimSpace(entry)
+		if strings.Contains(domain, "*") || net.ParseIP(domain) != nil {
+			continue
+		}
+		checked++
+
+		ctx, cancel := context.WithTimeout(context.Background(), domainResolveTimeout)
+		_, err := s.resolver.LookupHost(ctx, domain)
+		cancel()
+		if err != nil {
+			logger.Warn("Whitelisted domain does not resolve", "domain", domain, "error", err)
+			unresolved = append(unresolved, domain)
+		}
+	}
+
+	logger.Info("Whitelist DNS check completed",
+		"domains", checked,
+		"unresolved", len(unresolved))
+	return unresolved
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"pinning-server/internal/cert"
+	"pinning-server/internal/logger"
+)
+
+func TestWarmup(t *testing.T) {
+	server, retriever := createTestServerWithFakeRetriever(t, []string{"example.com", "broken.example.com"})
+
+	testCert, err := cert.GenerateTestCertificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to generate test certificate: %v", err)
+	}
+	retriever.SetCertificates("example.com", []*x509.Certificate{testCert})
+
+	if err := server.Warmup([]string{"example.com"}); err != nil {
+		t.Errorf("Expected warmup to succeed, got: %v", err)
+	}
+
+	// Failures are reported without stopping the remaining domains
+	err = server.Warmup([]string{"broken.example.com", "other.com", "example.com"})
+	if err == nil {
+		t.Fatal("Expected warmup error")
+	}
+	if !strings.Contains(err.Error(), "broken.example.com") {
+		t.Errorf("Expected error to name the failed domain, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "other.com: domain not in whitelist") {
+		t.Errorf("Expected error to name the non-whitelisted domain, got: %v", err)
+	}
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 2 {
+		t.Errorf("Expected 2 failed domains, got: %v", lines)
+	}
+}
+
+// stubResolver resolves only the hosts it knows
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestCheckDomainsResolve(t *testing.T) {
+	var buf bytes.Buffer
+	previous := logger.Logger
+	logger.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	defer func() { logger.Logger = previous }()
+
+	server, _ := createTestServerWithFakeRetriever(t, []string{
+		"example.com", "exmaple.com", "*.example.com", "api.example.org", "192.0.2.10",
+	})
+	server.resolver = stubResolver{
+		"example.com":     {"192.0.2.1"},
+		"api.example.org": {"192.0.2.2"},
+	}
+
+	unresolved := server.CheckDomainsResolve()
+	if len(unresolved) != 1 || unresolved[0] != "exmaple.com" {
+		t.Errorf("Expected only exmaple.com to be unresolved, got %v", unresolved)
+	}
+
+	var warned []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode log line %q: %v", line, err)
+		}
+		if entry["level"] == "WARN" && entry["msg"] == "Whitelisted domain does not resolve" {
+			warned = append(warned, entry["domain"].(string))
+		}
+	}
+	if len(warned) != 1 || warned[0] != "exmaple.com" {
+		t.Errorf("Expected one warning for exmaple.com, got %v", warned)
+	}
+}